@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+const (
+	// KeyActionAdd adds a new signer key in the incoming state
+	KeyActionAdd = "add"
+	// KeyActionActivate moves an incoming signer key to active
+	KeyActionActivate = "activate"
+	// KeyActionRetire moves an active signer key to retiring
+	KeyActionRetire = "retire"
+	// KeyActionRemove removes a retiring signer key
+	KeyActionRemove = "remove"
+)
+
+// KeyRotationRequest represents a signer key rotation step
+type KeyRotationRequest struct {
+	// Network is the Vocdoni network of the key, ignored for the EVM faucet
+	Network string `json:"network,omitempty"`
+	// PrivKey is the hex private key to add, only for the add action
+	PrivKey string `json:"privKey,omitempty"`
+	// Address is the address of the key to transition
+	Address common.Address `json:"address,omitempty"`
+}
+
+// KeysResponse represents the signer keys of the faucet and their rotation state
+type KeysResponse struct {
+	// EVM are the keys of the EVM faucet, if enabled
+	EVM []*faucet.KeyStatus `json:"evm,omitempty"`
+	// Vocdoni are the keys per network of the Vocdoni faucet, if enabled
+	Vocdoni map[string][]*faucet.KeyStatus `json:"vocdoni,omitempty"`
+}
+
+func (a *API) enableAdminHandlers(adminToken string) error {
+	if adminToken == "" {
+		return nil
+	}
+	a.api.SetAdminToken(adminToken)
+	if err := a.enableListsHandlers(); err != nil {
+		return err
+	}
+	if err := a.enableAuditHandlers(); err != nil {
+		return err
+	}
+	if err := a.registerMethod(
+		"/admin/keys",
+		"GET",
+		bearerstdapi.MethodAccessTypeAdmin,
+		a.keysHandler,
+	); err != nil {
+		return err
+	}
+	return a.registerMethod(
+		"/admin/keys/{faucet}/{action}",
+		"POST",
+		bearerstdapi.MethodAccessTypeAdmin,
+		a.keyRotationHandler,
+	)
+}
+
+// keys returns the signer keys of the enabled faucets
+func (a *API) keys() (*KeysResponse, error) {
+	resp := &KeysResponse{}
+	if a.enableEVM {
+		resp.EVM = a.evmFaucet.Keys()
+	}
+	if a.enableVocdoni {
+		resp.Vocdoni = make(map[string][]*faucet.KeyStatus)
+		for _, network := range a.vocdoniFaucet.Network() {
+			keys, err := a.vocdoniFaucet.Keys(network)
+			if err != nil {
+				return nil, err
+			}
+			resp.Vocdoni[network] = keys
+		}
+	}
+	return resp, nil
+}
+
+// returns the signer keys of the faucet
+func (a *API) keysHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	resp, err := a.keys()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// executes a signer key rotation step
+func (a *API) keyRotationHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	req := &KeyRotationRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ErrInvalidRequest.Withf("cannot decode key rotation request: %s", err)
+	}
+	action := ctx.URLParam("action")
+	// the added keys are recorded by their address, never by their private key
+	audit := &faucet.AuditEntry{
+		Action:  "keys." + action,
+		Faucet:  ctx.URLParam("faucet"),
+		Network: req.Network,
+		Address: &req.Address,
+	}
+	var err error
+	switch ctx.URLParam("faucet") {
+	case EVM:
+		if !a.enableEVM {
+			return ErrUnsupportedNetwork.Withf("evm faucet not enabled")
+		}
+		audit.Network = a.evmFaucet.Network()
+		switch action {
+		case KeyActionAdd:
+			req.Address, err = a.evmFaucet.AddSigner(req.PrivKey)
+		case KeyActionActivate:
+			err = a.evmFaucet.ActivateSigner(req.Address)
+		case KeyActionRetire:
+			err = a.evmFaucet.RetireSigner(req.Address)
+		case KeyActionRemove:
+			err = a.evmFaucet.RemoveSigner(req.Address)
+		default:
+			return ErrInvalidRequest.Withf("unsupported key action %s", action)
+		}
+	case Vocdoni:
+		if !a.enableVocdoni {
+			return ErrUnsupportedNetwork.Withf("vocdoni faucet not enabled")
+		}
+		switch action {
+		case KeyActionAdd:
+			req.Address, err = a.vocdoniFaucet.AddSigner(req.Network, req.PrivKey)
+		case KeyActionActivate:
+			err = a.vocdoniFaucet.ActivateSigner(req.Network, req.Address)
+		case KeyActionRetire:
+			err = a.vocdoniFaucet.RetireSigner(req.Network, req.Address)
+		case KeyActionRemove:
+			err = a.vocdoniFaucet.RemoveSigner(req.Network, req.Address)
+		default:
+			return ErrInvalidRequest.Withf("unsupported key action %s", action)
+		}
+	default:
+		return ErrInvalidRequest.Withf("unsupported faucet %s", ctx.URLParam("faucet"))
+	}
+	if (req.Address == common.Address{}) {
+		audit.Address = nil
+	}
+	a.auditAdmin(msg, audit, err)
+	if err != nil {
+		return fmt.Errorf("cannot %s key: %w", action, err)
+	}
+	return a.keysHandler(msg, ctx)
+}
+
+const (
+	// ListActionAdd adds an entry to a list
+	ListActionAdd = "add"
+	// ListActionRemove removes an entry from a list
+	ListActionRemove = "remove"
+)
+
+// ListsResponse represents the allow and deny lists of the recipient addresses
+type ListsResponse struct {
+	// Restricted are the networks only accepting the addresses of the allow list
+	Restricted []string `json:"restricted"`
+	// Allow is the allow list
+	Allow []*faucet.ListEntry `json:"allow"`
+	// Deny is the deny list
+	Deny []*faucet.ListEntry `json:"deny"`
+}
+
+// SetAddressLists checks the claims against the given allow and deny lists,
+// which are managed with the admin API. It must be called before Init.
+func (a *API) SetAddressLists(lists *faucet.AddressLists) {
+	a.lists = lists
+}
+
+func (a *API) enableListsHandlers() error {
+	if a.lists == nil {
+		return nil
+	}
+	if err := a.registerMethod(
+		"/admin/lists",
+		"GET",
+		bearerstdapi.MethodAccessTypeAdmin,
+		a.listsHandler,
+	); err != nil {
+		return err
+	}
+	return a.registerMethod(
+		"/admin/lists/{list}/{action}",
+		"POST",
+		bearerstdapi.MethodAccessTypeAdmin,
+		a.listEntryHandler,
+	)
+}
+
+// returns the allow and deny lists
+func (a *API) listsHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	allow, err := a.lists.Entries(faucet.ListAllow)
+	if err != nil {
+		return err
+	}
+	deny, err := a.lists.Entries(faucet.ListDeny)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&ListsResponse{
+		Restricted: a.lists.Restricted(),
+		Allow:      allow,
+		Deny:       deny,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// adds or removes an entry of a list
+func (a *API) listEntryHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	entry := &faucet.ListEntry{}
+	if err := json.Unmarshal(msg.Data, entry); err != nil {
+		return ErrInvalidRequest.Withf("cannot decode list entry: %s", err)
+	}
+	kind := faucet.ListKind(ctx.URLParam("list"))
+	if kind != faucet.ListAllow && kind != faucet.ListDeny {
+		return ErrInvalidRequest.Withf("unsupported list %s", kind)
+	}
+	var err error
+	action := ctx.URLParam("action")
+	switch action {
+	case ListActionAdd:
+		err = a.lists.Add(kind, entry)
+	case ListActionRemove:
+		err = a.lists.Remove(kind, entry.Address, entry.Network)
+	default:
+		return ErrInvalidRequest.Withf("unsupported list action %s", action)
+	}
+	audit := &faucet.AuditEntry{
+		Action:  "lists." + string(kind) + "." + action,
+		Network: entry.Network,
+		Address: &entry.Address,
+	}
+	if entry.Reason != "" {
+		audit.Details = map[string]string{"reason": entry.Reason}
+	}
+	a.auditAdmin(msg, audit, err)
+	if err != nil {
+		return err
+	}
+	return a.listsHandler(msg, ctx)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -15,7 +16,11 @@ import (
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
 	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/internal"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -25,17 +30,25 @@ const (
 	MaxRequest = 10000
 )
 
-var (
-	ErrInvalidToken       = errors.New("invalid token")
-	ErrInvalidFromAddress = errors.New("invalid from address")
-)
-
 // FaucetRequestData represents the data of a faucet request
 type FaucetRequestData struct {
-	// Network represents one of the supported faucet networks (evm or vocdoni)
+	// Faucet represents the faucet to request (evm or vocdoni)
+	Faucet string `json:"faucet"`
+	// Network represents one of the supported networks of the faucet
 	Network string `json:"network"`
 	// From represents the address for the faucet to send tokens
 	From types.HexBytes `json:"from"`
+	// Challenge is reserved for the solution of a faucet challenge, it is
+	// not verified yet
+	Challenge string `json:"challenge,omitempty"`
+	// Captcha is reserved for a captcha token, it is not verified yet
+	Captcha string `json:"captcha,omitempty"`
+	// Metadata is additional information of the request, only logged
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Nonce is the faucet nonce signed by the from address, for signed claims
+	Nonce string `json:"nonce,omitempty"`
+	// Signature is the EIP-191 signature of the claim message by the from address
+	Signature types.HexBytes `json:"signature,omitempty"`
 }
 
 // FaucetResponse represents the message on the response of a faucet request
@@ -46,6 +59,69 @@ type FaucetResponse struct {
 	FaucetPackage []byte `json:"faucetPackage,omitempty"`
 	// TxHash is the EVM tx hash
 	TxHash types.HexBytes `json:"txHash,omitempty"`
+	// Deferred is the id of the EVM send queued until the gas price is
+	// below the cap, the tx is sent then
+	Deferred string `json:"deferred,omitempty"`
+	// Identifier is the identifier of the Vocdoni faucet package
+	Identifier string `json:"identifier,omitempty"`
+	// Tier is the tier the amount was granted with, if any
+	Tier string `json:"tier,omitempty"`
+}
+
+// VocdoniInfoResponse represents the public information of the Vocdoni faucet
+type VocdoniInfoResponse struct {
+	// Networks contains the information per Vocdoni network
+	Networks map[string]*VocdoniNetworkInfo `json:"networks"`
+}
+
+// VocdoniNetworkInfo represents the public information of a Vocdoni faucet network
+type VocdoniNetworkInfo struct {
+	// Addresses are the faucet addresses signing the packages
+	Addresses []common.Address `json:"addresses"`
+}
+
+// PackageStatusResponse represents the status of an issued Vocdoni faucet package
+type PackageStatusResponse struct {
+	*faucet.PackageRecord
+	// Status is the current status of the package
+	Status faucet.PackageStatus `json:"status"`
+}
+
+// StatusResponse represents the current status of the faucet
+type StatusResponse struct {
+	// EVM is the status of the EVM faucet, if enabled
+	EVM *faucet.EVMStatus `json:"evm,omitempty"`
+}
+
+// InfoResponse represents the public information of the faucet
+type InfoResponse struct {
+	// Version is the faucet version
+	Version string `json:"version"`
+	// Faucets are the enabled faucets (evm or vocdoni)
+	Faucets []string `json:"faucets"`
+	// Networks are the networks supported by the enabled faucets
+	Networks []*NetworkInfo `json:"networks"`
+}
+
+// NetworkInfo represents the public information of a faucet network
+type NetworkInfo struct {
+	// Faucet is the faucet serving the network (evm or vocdoni)
+	Faucet string `json:"faucet"`
+	// Network is the network name to use in the requests
+	Network string `json:"network"`
+	// ChainID is the chain ID of the network
+	ChainID string `json:"chainId"`
+	// Amount is the amount sent per request
+	Amount string `json:"amount"`
+	// TopUpTarget is the balance the requests top the addresses up to
+	// instead of sending the amount, if the top up mode is enabled
+	TopUpTarget string `json:"topUpTarget,omitempty"`
+	// Threshold is the balance from which an address cannot get tokens
+	Threshold string `json:"threshold"`
+	// Cooldown is the minimum time between requests for the same address
+	Cooldown string `json:"cooldown,omitempty"`
+	// Addresses are the faucet addresses
+	Addresses []common.Address `json:"addresses"`
 }
 
 // FaucetPackage represents the data of a faucet package
@@ -64,17 +140,39 @@ type API struct {
 	api           *bearerstdapi.BearerStandardAPI
 	evmFaucet     *faucet.EVM
 	vocdoniFaucet *faucet.Vocdoni
+	enableEVM     bool
+	enableVocdoni bool
+	// routes methods registered, for documentation purposes
+	routes []Route
+	// claimNonces issued for the signed claims, nil if not enabled
+	claimNonces *signedNonces
+	// publicClaims if true the signed claims do not need a bearer token
+	publicClaims bool
+	// sessions of the signed in addresses, nil if not enabled
+	sessions *sessionStore
+	// identities gate of the claims, nil if not enabled
+	identities *identityGate
+	// lists allow and deny lists of the recipients, nil if not enabled
+	lists *faucet.AddressLists
+	// tiers of the claims with their own grant settings, nil if not enabled
+	tiers *tierIndex
+	// audit log of the claims and the admin changes, nil if not enabled
+	audit *faucet.AuditLog
+	// storage keeps the request quotas of the bearer tokens, the used nonces
+	// and the sign in sessions
+	storage storage.Storage
 }
 
 // NewAPI returns a new instance of the API
 func NewAPI() *API {
-	return &API{}
+	return &API{storage: storage.NewMemory()}
 }
 
 // Init initianizes an API instance
 func (a *API) Init(router *httprouter.HTTProuter,
 	baseRoute,
-	whitelist string,
+	whitelist,
+	adminToken string,
 	enableEVM,
 	enableVocdoni bool,
 	vfaucet *faucet.Vocdoni,
@@ -98,9 +196,23 @@ func (a *API) Init(router *httprouter.HTTProuter,
 		return err
 	}
 	// add whitelisted bearer tokens
-	bearerWhitelist := strings.Split(whitelist, ",")
-	for _, token := range bearerWhitelist {
-		a.api.AddAuthToken(token, int64(MaxRequest))
+	for _, token := range strings.Split(whitelist, ",") {
+		if token == "" {
+			continue
+		}
+		if err := a.addToken(token, ""); err != nil {
+			return err
+		}
+	}
+	if a.tiers != nil {
+		for token, tier := range a.tiers.tokens {
+			if err := a.addToken(token, tier); err != nil {
+				return err
+			}
+		}
+	}
+	if err := a.shareState(); err != nil {
+		return err
 	}
 	// attach faucet modules
 	a.attach(vfaucet, efaucet)
@@ -108,29 +220,104 @@ func (a *API) Init(router *httprouter.HTTProuter,
 	if err := a.enableFaucetHandlers(enableEVM, enableVocdoni); err != nil {
 		return fmt.Errorf("cannot enable handlers %w", err)
 	}
+	if err := a.enableAuthHandlers(); err != nil {
+		return fmt.Errorf("cannot enable auth handlers %w", err)
+	}
+	if err := a.enableIdentityHandlers(); err != nil {
+		return fmt.Errorf("cannot enable identity handlers %w", err)
+	}
+	if err := a.enableAdminHandlers(adminToken); err != nil {
+		return fmt.Errorf("cannot enable admin handlers %w", err)
+	}
 	return nil
 }
 
 func (a *API) enableFaucetHandlers(enableEVM, enableVocdoni bool) error {
+	a.enableEVM = enableEVM
+	a.enableVocdoni = enableVocdoni
+	if err := a.registerMethod(
+		"/status",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		a.statusHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.registerMethod(
+		"/info",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		a.infoHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.registerMethod(
+		"/openapi.json",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		a.openAPIHandler,
+	); err != nil {
+		return err
+	}
+	// the public signed claims and the sessions are authorized by the
+	// handlers instead of requiring a whitelisted bearer token
+	claimAccessType := bearerstdapi.MethodAccessTypePrivate
+	if a.publicClaims || a.sessions != nil {
+		claimAccessType = bearerstdapi.MethodAccessTypePublic
+	}
+	if a.claimNonces != nil {
+		if err := a.registerMethod(
+			"/claim/nonce/{from}",
+			"GET",
+			bearerstdapi.MethodAccessTypePublic,
+			a.claimNonceHandler,
+		); err != nil {
+			return err
+		}
+	}
+	if err := a.registerMethod(
+		"/claim",
+		"POST",
+		claimAccessType,
+		a.claimHandler,
+	); err != nil {
+		return err
+	}
 	if enableEVM {
-		if err := a.api.RegisterMethod(
+		if err := a.registerMethod(
 			"/evm/{network}/{from}",
 			"GET",
-			bearerstdapi.MethodAccessTypePrivate,
+			claimAccessType,
 			a.faucetHandler,
 		); err != nil {
 			return err
 		}
 	}
 	if enableVocdoni {
-		if err := a.api.RegisterMethod(
+		if err := a.registerMethod(
 			"/vocdoni/{network}/{from}",
 			"GET",
-			bearerstdapi.MethodAccessTypePrivate,
+			claimAccessType,
 			a.faucetHandler,
 		); err != nil {
 			return err
 		}
+		if err := a.registerMethod(
+			"/vocdoni/info",
+			"GET",
+			bearerstdapi.MethodAccessTypePublic,
+			a.vocdoniInfoHandler,
+		); err != nil {
+			return err
+		}
+		if err := a.registerMethod(
+			"/vocdoni/package/{identifier}",
+			"GET",
+			bearerstdapi.MethodAccessTypePrivate,
+			a.packageStatusHandler,
+		); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -170,32 +357,151 @@ func (a *API) fromParse(from string) (*common.Address, error) {
 func (a *API) faucetHandler(msg *bearerstdapi.BearerStandardAPIdata,
 	ctx *httprouter.HTTPContext,
 ) error {
+	// get faucet and network url params
+	origin := strings.Split(ctx.Request.URL.Path, "/")
+	// get from url param
+	from, err := a.fromParse(ctx.URLParam("from"))
+	if err != nil {
+		return err
+	}
+	req := &FaucetRequestData{
+		Faucet:  origin[2],
+		Network: ctx.URLParam("network"),
+		From:    from.Bytes(),
+	}
+	// the GET claims cannot be signed
+	if a.claimNonces != nil {
+		a.auditClaim(ctx, msg.AuthToken, req, nil, ErrSignatureRequired)
+		return ErrSignatureRequired
+	}
+	if err := a.authorize(msg, *from); err != nil {
+		a.auditClaim(ctx, msg.AuthToken, req, nil, err)
+		return err
+	}
+	return a.claim(ctx, msg.AuthToken, req)
+}
+
+// handles a POST claim request with a JSON body, if the signed claims are
+// enabled the requests must be signed, and if they are public they do not
+// need a bearer token
+func (a *API) claimHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	req := &FaucetRequestData{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ErrInvalidRequest.Withf("cannot decode claim request: %s", err)
+	}
+	if len(req.From) != common.AddressLength {
+		return ErrInvalidFromAddress
+	}
+	if a.claimNonces != nil {
+		if err := a.verifySignedClaim(req); err != nil {
+			a.auditClaim(ctx, msg.AuthToken, req, nil, err)
+			return err
+		}
+		if a.publicClaims {
+			return a.claim(ctx, "", req)
+		}
+	}
+	if err := a.authorize(msg, common.BytesToAddress(req.From)); err != nil {
+		a.auditClaim(ctx, msg.AuthToken, req, nil, err)
+		return err
+	}
+	return a.claim(ctx, msg.AuthToken, req)
+}
+
+// authorize checks the auth token of a request claiming for the from address
+// is a whitelisted bearer token with requests available, consuming one, or
+// the token of a session signed in with the from address
+func (a *API) authorize(msg *bearerstdapi.BearerStandardAPIdata, from common.Address) error {
+	if a.sessions != nil {
+		address, ok, err := a.sessions.address(msg.AuthToken)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if address != from {
+				return ErrSessionAddress
+			}
+			return nil
+		}
+	}
 	// get auth token
 	token, err := uuid.Parse(msg.AuthToken)
 	if err != nil {
-		return err
+		return ErrInvalidToken.WithErr(err)
 	}
-	// authorize
 	if a.api.GetAuthTokens(token.String()) == 0 {
 		return ErrInvalidToken
 	}
-	// get network url param
-	origin := strings.Split(ctx.Request.URL.Path, "/")
-	network := a.networkParse(ctx.URLParam("network"), origin[2])
-	// get from url param
-	from, err := a.fromParse(ctx.URLParam("from"))
+	return a.useToken(token.String())
+}
+
+// claim grants the claim and replies with the grant, the claim and its
+// outcome are recorded in the audit log
+func (a *API) claim(ctx *httprouter.HTTPContext, token string, req *FaucetRequestData) error {
+	resp, err := a.grant(ctx, token, req)
+	a.auditClaim(ctx, token, req, resp, err)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(resp)
 	if err != nil {
 		return err
 	}
+	return ctx.Send(msg, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// grant sends the faucet funds of the requested network if the recipient is
+// not denied, with the grant settings of the tier of the authorized token or
+// the identity if any. If the identity gate is enabled the identity of the
+// request must not have claimed before
+func (a *API) grant(ctx *httprouter.HTTPContext, token string, req *FaucetRequestData) (*FaucetResponse, error) {
+	if a.lists != nil {
+		if err := a.lists.Check(req.Network, common.BytesToAddress(req.From)); err != nil {
+			return nil, err
+		}
+	}
+	identityToken := ctx.Request.Header.Get(IdentityHeader)
+	tier := a.tier(token, identityToken, req.Network)
+	if a.identities == nil {
+		return a.dispatch(req, tier)
+	}
+	release, err := a.identities.reserve(a.storage, identityToken, req.Faucet, req.Network)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.dispatch(req, tier)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// dispatch sends the funds of the requested network with its faucet, with
+// the grant settings of the tier if not nil
+func (a *API) dispatch(req *FaucetRequestData, tier *tierGrant) (*FaucetResponse, error) {
+	network := a.networkParse(req.Network, req.Faucet)
+	from := common.BytesToAddress(req.From)
 	// handle
-	log.Debugf("faucet request from %s for network %+v", from.String(), network)
+	log.Debugw("faucet request",
+		"from", from.String(),
+		"faucet", req.Faucet,
+		"network", req.Network,
+		"metadata", req.Metadata,
+	)
 	switch network {
 	case faucet.FaucetNetworksUndefined:
-		return fmt.Errorf("%s", "unsupported network")
+		return nil, ErrUnsupportedNetwork.Withf("%s/%s", req.Faucet, req.Network)
 	case faucet.FaucetNetworksVocdoniDev,
 		faucet.FaucetNetworksVocdoniStage,
-		faucet.FaucetNetworksVocdoniAzeno:
-		return a.vocdoniFaucetHandler(ctx, network, *from)
+		faucet.FaucetNetworksVocdoniAzeno,
+		faucet.FaucetNetworksVocdoniLTS:
+		if !a.enableVocdoni {
+			return nil, ErrUnsupportedNetwork.Withf("unavailable network")
+		}
+		return a.vocdoniFaucetHandler(network, req.Network, from, tier)
 	case faucet.FaucetNetworksEthereum,
 		faucet.FaucetNetworksGoerli,
 		faucet.FaucetNetworksSepolia,
@@ -203,36 +509,51 @@ func (a *API) faucetHandler(msg *bearerstdapi.BearerStandardAPIdata,
 		faucet.FaucetNetworksMumbai,
 		faucet.FaucetNetworksGnosisChain,
 		faucet.FaucetNetworksEVMTest:
-		return a.evmFaucetHandler(ctx, network, *from)
+		if !a.enableEVM {
+			return nil, ErrUnsupportedNetwork.Withf("unavailable network")
+		}
+		return a.evmFaucetHandler(network, from, tier)
 	}
-	return fmt.Errorf("cannot handle request")
+	return nil, ErrUnsupportedNetwork.Withf("cannot handle request")
 }
 
 // request evm funds to the faucet
-func (a *API) evmFaucetHandler(ctx *httprouter.HTTPContext,
-	network faucet.FaucetNetworks,
+func (a *API) evmFaucetHandler(network faucet.FaucetNetworks,
 	from common.Address,
-) error {
+	tier *tierGrant,
+) (*FaucetResponse, error) {
 	if faucet.EVMSupportedFaucetNetworksMap[a.evmFaucet.Network()] != network {
-		return fmt.Errorf("unavailable network")
+		return nil, ErrUnsupportedNetwork.Withf("unavailable network")
 	}
-	txHash, err := a.evmFaucet.SendTokens(context.Background(), from)
-	if err != nil {
-		return fmt.Errorf("error sending evm tokens: %s", err)
+	grant := &faucet.GrantSettings{}
+	if tier != nil {
+		grant = tier.grant
+	}
+	txHash, amount, err := a.evmFaucet.SendTokensWith(context.Background(), from, grant)
+	deferredErr := &faucet.DeferredSendError{}
+	if err != nil && !errors.As(err, &deferredErr) {
+		return nil, fmt.Errorf("error sending evm tokens: %w", err)
 	}
 	resp := &FaucetResponse{
-		TxHash: types.HexBytes(txHash.Bytes()),
-		Amount: fmt.Sprint(a.evmFaucet.Amout()),
+		Amount: fmt.Sprint(amount),
 	}
-	msg, err := json.Marshal(resp)
 	if err != nil {
-		return err
+		resp.Deferred = deferredErr.ID
+	} else {
+		resp.TxHash = types.HexBytes(txHash.Bytes())
 	}
-	return ctx.Send(msg, bearerstdapi.HTTPstatusCodeOK)
+	if tier != nil {
+		resp.Tier = tier.name
+	}
+	return resp, nil
 }
 
 // request vocdoni funds to the faucet
-func (a *API) vocdoniFaucetHandler(ctx *httprouter.HTTPContext, network faucet.FaucetNetworks, from common.Address) error {
+func (a *API) vocdoniFaucetHandler(network faucet.FaucetNetworks,
+	networkName string,
+	from common.Address,
+	tier *tierGrant,
+) (*FaucetResponse, error) {
 	networkFound := false
 	for _, faucetNetwork := range a.vocdoniFaucet.Network() {
 		if faucet.VocdoniSupportedFaucetNetworksMap[faucetNetwork] == network {
@@ -240,11 +561,19 @@ func (a *API) vocdoniFaucetHandler(ctx *httprouter.HTTPContext, network faucet.F
 		}
 	}
 	if !networkFound {
-		return fmt.Errorf("unavailable network")
+		return nil, ErrUnsupportedNetwork.Withf("unavailable network")
 	}
-	fpackage, err := a.vocdoniFaucet.GenerateFaucetPackage(from)
+	grant := &faucet.GrantSettings{}
+	if tier != nil {
+		grant = tier.grant
+	}
+	fpackage, err := a.vocdoniFaucet.GenerateFaucetPackageWith(networkName, from, grant)
 	if err != nil {
-		return fmt.Errorf("could not generate faucet package: %w", err)
+		return nil, fmt.Errorf("could not generate faucet package: %w", err)
+	}
+	payload := &models.FaucetPayload{}
+	if err := proto.Unmarshal(fpackage.Payload, payload); err != nil {
+		return nil, err
 	}
 
 	fpackageBytes, err := json.Marshal(FaucetPackage{
@@ -252,16 +581,121 @@ func (a *API) vocdoniFaucetHandler(ctx *httprouter.HTTPContext, network faucet.F
 		Signature:     fpackage.Signature,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resp := &FaucetResponse{
-		Amount:        fmt.Sprint(a.vocdoniFaucet.Amount()),
+		Amount:        fmt.Sprint(payload.Amount),
 		FaucetPackage: fpackageBytes,
+		Identifier:    fmt.Sprint(payload.Identifier),
 	}
-	msg, err := json.Marshal(resp)
+	if tier != nil {
+		resp.Tier = tier.name
+	}
+	return resp, nil
+}
+
+// returns the current status of the faucet
+func (a *API) statusHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	resp := &StatusResponse{}
+	if a.enableEVM {
+		resp.EVM = a.evmFaucet.Status()
+	}
+	data, err := json.Marshal(resp)
 	if err != nil {
 		return err
 	}
-	return ctx.Send(msg, bearerstdapi.HTTPstatusCodeOK)
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// returns the public information of the faucet
+func (a *API) infoHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	resp := &InfoResponse{
+		Version:  internal.Version,
+		Faucets:  []string{},
+		Networks: []*NetworkInfo{},
+	}
+	if a.enableEVM {
+		resp.Faucets = append(resp.Faucets, EVM)
+		info := &NetworkInfo{
+			Faucet:    EVM,
+			Network:   a.evmFaucet.Network(),
+			ChainID:   fmt.Sprint(a.evmFaucet.ChainID()),
+			Amount:    fmt.Sprint(a.evmFaucet.Amout()),
+			Threshold: fmt.Sprint(a.evmFaucet.Threshold()),
+			Cooldown:  a.evmFaucet.Cooldown().String(),
+			Addresses: a.evmFaucet.Addresses(),
+		}
+		if target := a.evmFaucet.TopUpTarget(); target > 0 {
+			info.TopUpTarget = fmt.Sprint(target)
+		}
+		resp.Networks = append(resp.Networks, info)
+	}
+	if a.enableVocdoni {
+		resp.Faucets = append(resp.Faucets, Vocdoni)
+		addresses := a.vocdoniFaucet.Addresses()
+		for _, network := range a.vocdoniFaucet.Network() {
+			chainID, err := a.vocdoniFaucet.ChainID(network)
+			if err != nil {
+				return err
+			}
+			settings := a.vocdoniFaucet.Settings(network)
+			info := &NetworkInfo{
+				Faucet:    Vocdoni,
+				Network:   network,
+				ChainID:   chainID,
+				Amount:    fmt.Sprint(settings.Amount),
+				Threshold: fmt.Sprint(settings.Threshold),
+				Cooldown:  settings.Cooldown.String(),
+				Addresses: addresses[network],
+			}
+			if settings.TopUpTarget > 0 {
+				info.TopUpTarget = fmt.Sprint(settings.TopUpTarget)
+			}
+			resp.Networks = append(resp.Networks, info)
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// returns the status of an issued vocdoni faucet package
+func (a *API) packageStatusHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	identifier, err := strconv.ParseUint(ctx.URLParam("identifier"), 10, 64)
+	if err != nil {
+		return ErrInvalidRequest.Withf("invalid package identifier: %s", err)
+	}
+	pr, err := a.vocdoniFaucet.Package(identifier)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&PackageStatusResponse{PackageRecord: pr, Status: pr.Status()})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// returns the public information of the vocdoni faucet
+func (a *API) vocdoniInfoHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	resp := &VocdoniInfoResponse{Networks: make(map[string]*VocdoniNetworkInfo)}
+	for network, addresses := range a.vocdoniFaucet.Addresses() {
+		resp.Networks[network] = &VocdoniNetworkInfo{Addresses: addresses}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
 }
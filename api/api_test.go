@@ -10,7 +10,10 @@ import (
 	"math/big"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,6 +27,9 @@ import (
 	faucetapi "go.vocdoni.io/vocdoni-faucet/api"
 	"go.vocdoni.io/vocdoni-faucet/config"
 	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/identity"
+	"go.vocdoni.io/vocdoni-faucet/internal"
+	"go.vocdoni.io/vocdoni-faucet/storage"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -57,6 +63,8 @@ func TestAPI(t *testing.T) {
 	// create vocdoni faucet
 	v := faucet.NewVocdoni()
 	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	store := storage.NewMemory()
+	v.TrackPackages(store)
 
 	// create ethereum faucet
 	e := faucet.NewEVM()
@@ -73,7 +81,9 @@ func TestAPI(t *testing.T) {
 	// api whitelist
 	token, err := uuid.NewUUID()
 	qt.Assert(t, err, qt.IsNil)
-	qt.Assert(t, api.Init(&router, "/faucet", token.String(), true, true, v, e), qt.IsNil)
+	adminToken, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), adminToken.String(), true, true, v, e), qt.IsNil)
 	c := newTestHTTPclient(t, addr, &token)
 
 	// create vocdoni request
@@ -98,6 +108,65 @@ func TestAPI(t *testing.T) {
 	fromAddress, err := ethereum.AddrFromSignature(fPackage.FaucetPayload, fPackage.Signature)
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, fromAddress, qt.DeepEquals, v.Signer().Address())
+
+	// should not issue a new package while the previous is not redeemed
+	errorCode := func(resp []byte) string {
+		errResp := &faucetapi.ErrorResponse{}
+		qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+		return errResp.Code
+	}
+	resp, code = c.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 409)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrPendingPackage.Code)
+	resp, code = c.request("GET", nil, "vocdoni", "dev", "0x1234")
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidFromAddress.Code)
+
+	// should claim with a POST JSON request
+	claimAddress := evmcommon.HexToAddress("0xBBbfD269cf7F6C7a7afa92A32127fbc72593638e")
+	claim := func(req *faucetapi.FaucetRequestData) ([]byte, int) {
+		body, err := json.Marshal(req)
+		qt.Assert(t, err, qt.IsNil)
+		return c.request("POST", body, "claim")
+	}
+	resp, code = claim(&faucetapi.FaucetRequestData{
+		Faucet:   faucetapi.Vocdoni,
+		Network:  "dev",
+		From:     claimAddress.Bytes(),
+		Metadata: map[string]string{"source": "test"},
+	})
+	qt.Assert(t, code, qt.Equals, 200)
+	claimData := &faucetapi.FaucetResponse{}
+	qt.Assert(t, json.Unmarshal(resp, claimData), qt.IsNil)
+	qt.Assert(t, claimData.Amount, qt.Equals, "100")
+	resp, code = claim(&faucetapi.FaucetRequestData{Faucet: faucetapi.Vocdoni, Network: "dev", From: claimAddress.Bytes()})
+	qt.Assert(t, code, qt.Equals, 409)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrPendingPackage.Code)
+	resp, code = claim(&faucetapi.FaucetRequestData{Faucet: faucetapi.Vocdoni, Network: "invalid", From: claimAddress.Bytes()})
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrUnsupportedNetwork.Code)
+	resp, code = claim(&faucetapi.FaucetRequestData{Faucet: faucetapi.Vocdoni, Network: "dev", From: []byte{1, 2}})
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidFromAddress.Code)
+	resp, code = c.request("POST", []byte("{"), "claim")
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidRequest.Code)
+
+	// get the faucet addresses
+	resp, code = c.request("GET", nil, "vocdoni", "info")
+	qt.Assert(t, code, qt.Equals, 200)
+	vocdoniInfo := &faucetapi.VocdoniInfoResponse{}
+	qt.Assert(t, json.Unmarshal(resp, vocdoniInfo), qt.IsNil)
+	qt.Assert(t, vocdoniInfo.Networks["dev"].Addresses, qt.DeepEquals, []evmcommon.Address{v.Signer().Address()})
+
+	// get the package status
+	qt.Assert(t, respData.Identifier, qt.Equals, fmt.Sprint(faucetPayloadData.Identifier))
+	resp, code = c.request("GET", nil, "vocdoni", "package", respData.Identifier)
+	qt.Assert(t, code, qt.Equals, 200)
+	packageStatus := &faucetapi.PackageStatusResponse{}
+	qt.Assert(t, json.Unmarshal(resp, packageStatus), qt.IsNil)
+	qt.Assert(t, packageStatus.Status, qt.Equals, faucet.PackageStatusIssued)
+	qt.Assert(t, packageStatus.Identifier, qt.Equals, faucetPayloadData.Identifier)
 	t.Logf("%s", fmt.Sprintf(
 		`"response": {
 			"code": %d,
@@ -145,23 +214,682 @@ func TestAPI(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 	// balance updated
 	qt.Assert(t, balance.Cmp(big.NewInt(int64(100))), qt.Equals, 0)
+
+	// get status
+	resp, code = c.request("GET", nil, "status")
+	qt.Assert(t, code, qt.Equals, 200)
+	status := &faucetapi.StatusResponse{}
+	qt.Assert(t, json.Unmarshal(resp, status), qt.IsNil)
+	qt.Assert(t, status.EVM.Network, qt.Equals, "evmtest")
+	qt.Assert(t, status.EVM.Gas.LastGasPrice, qt.Not(qt.Equals), "")
+
+	// get the faucet info
+	resp, code = c.request("GET", nil, "info")
+	qt.Assert(t, code, qt.Equals, 200)
+	info := &faucetapi.InfoResponse{}
+	qt.Assert(t, json.Unmarshal(resp, info), qt.IsNil)
+	qt.Assert(t, info.Version, qt.Equals, internal.Version)
+	qt.Assert(t, info.Faucets, qt.DeepEquals, []string{faucetapi.EVM, faucetapi.Vocdoni})
+	qt.Assert(t, info.Networks, qt.DeepEquals, []*faucetapi.NetworkInfo{
+		{
+			Faucet:    faucetapi.EVM,
+			Network:   "evmtest",
+			ChainID:   "1337",
+			Amount:    "100",
+			Threshold: "100",
+			Cooldown:  "0s",
+			Addresses: []evmcommon.Address{e.Signers()[0].SignKeys.Address()},
+		},
+		{
+			Faucet:    faucetapi.Vocdoni,
+			Network:   "dev",
+			ChainID:   "dev",
+			Amount:    "100",
+			Threshold: "100",
+			Cooldown:  "0s",
+			Addresses: []evmcommon.Address{v.Signer().Address()},
+		},
+	})
+
+	// admin methods should require the admin token
+	_, code = c.request("GET", nil, "admin", "keys")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+	admin := newTestHTTPclient(t, addr, &adminToken)
+	resp, code = admin.request("GET", nil, "admin", "keys")
+	qt.Assert(t, code, qt.Equals, 200)
+	keys := &faucetapi.KeysResponse{}
+	qt.Assert(t, json.Unmarshal(resp, keys), qt.IsNil)
+	qt.Assert(t, keys.Vocdoni["dev"], qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: v.Signer().Address(), State: faucet.KeyStateActive},
+	})
+
+	// rotate the vocdoni key
+	newSigner := ethereum.NewSignKeys()
+	qt.Assert(t, newSigner.Generate(), qt.IsNil)
+	_, newKey := newSigner.HexString()
+	rotationStep := func(action string, req *faucetapi.KeyRotationRequest) int {
+		body, err := json.Marshal(req)
+		qt.Assert(t, err, qt.IsNil)
+		resp, code := admin.request("POST", body, "admin", "keys", "vocdoni", action)
+		t.Logf("%s response: %s", action, resp)
+		return code
+	}
+	qt.Assert(t, rotationStep(faucetapi.KeyActionAdd,
+		&faucetapi.KeyRotationRequest{Network: "dev", PrivKey: newKey}), qt.Equals, 200)
+	qt.Assert(t, rotationStep(faucetapi.KeyActionRetire,
+		&faucetapi.KeyRotationRequest{Network: "dev", Address: v.Signer().Address()}), qt.Equals, 409)
+	qt.Assert(t, rotationStep(faucetapi.KeyActionActivate,
+		&faucetapi.KeyRotationRequest{Network: "dev", Address: newSigner.Address()}), qt.Equals, 200)
+	qt.Assert(t, rotationStep(faucetapi.KeyActionRetire,
+		&faucetapi.KeyRotationRequest{Network: "dev", Address: v.Signer().Address()}), qt.Equals, 200)
+	qt.Assert(t, rotationStep("invalid",
+		&faucetapi.KeyRotationRequest{Network: "dev", Address: v.Signer().Address()}), qt.Equals, 400)
+	keys2, err := v.Keys("dev")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, keys2, qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: v.Signer().Address(), State: faucet.KeyStateRetiring},
+		{Address: newSigner.Address(), State: faucet.KeyStateActive},
+	})
+}
+
+func TestSignedClaims(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	shared := storage.NewMemory()
+	api.SetStorage(shared)
+	qt.Assert(t, api.SetSignedClaims(false, true), qt.IsNotNil)
+	qt.Assert(t, api.SetSignedClaims(true, true), qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	c := newTestHTTPclient(t, addr, nil)
+
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	getNonce := func(address evmcommon.Address) *faucetapi.ClaimNonceResponse {
+		resp, code := c.request("GET", nil, "claim", "nonce", address.Hex())
+		qt.Assert(t, code, qt.Equals, 200)
+		nonce := &faucetapi.ClaimNonceResponse{}
+		qt.Assert(t, json.Unmarshal(resp, nonce), qt.IsNil)
+		qt.Assert(t, nonce.Message, qt.Equals, faucetapi.ClaimMessage(address, nonce.Nonce))
+		return nonce
+	}
+	claim := func(req *faucetapi.FaucetRequestData) ([]byte, int) {
+		body, err := json.Marshal(req)
+		qt.Assert(t, err, qt.IsNil)
+		return c.request("POST", body, "claim")
+	}
+	signedClaim := func(signer *ethereum.SignKeys, from evmcommon.Address, nonce string) *faucetapi.FaucetRequestData {
+		signature, err := signer.SignEthereum([]byte(faucetapi.ClaimMessage(from, nonce)))
+		qt.Assert(t, err, qt.IsNil)
+		return &faucetapi.FaucetRequestData{
+			Faucet:    faucetapi.Vocdoni,
+			Network:   "dev",
+			From:      from.Bytes(),
+			Nonce:     nonce,
+			Signature: signature,
+		}
+	}
+	errorCode := func(resp []byte) string {
+		errResp := &faucetapi.ErrorResponse{}
+		qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+		return errResp.Code
+	}
+
+	// should claim without a bearer token if signed by the from address
+	req := signedClaim(signer, signer.Address(), getNonce(signer.Address()).Nonce)
+	_, code := claim(req)
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// should refuse a replayed request
+	resp, code := claim(req)
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidNonce.Code)
+
+	// should refuse the claims not signed by the from address
+	other := ethereum.NewSignKeys()
+	qt.Assert(t, other.Generate(), qt.IsNil)
+	resp, code = claim(signedClaim(other, randomEVMAddress, getNonce(randomEVMAddress).Nonce))
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidSignature.Code)
+
+	// should refuse a nonce issued for another address
+	resp, code = claim(signedClaim(other, other.Address(), getNonce(randomEVMAddress).Nonce))
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidNonce.Code)
+
+	// should keep the nonce of an invalid signature and the nonces issued
+	// before a new one
+	previous := getNonce(other.Address()).Nonce
+	getNonce(other.Address())
+	resp, code = claim(signedClaim(signer, other.Address(), previous))
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidSignature.Code)
+	_, code = claim(signedClaim(other, other.Address(), previous))
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// should refuse the unsigned claims, even with a bearer token
+	third := ethereum.NewSignKeys()
+	qt.Assert(t, third.Generate(), qt.IsNil)
+	resp, code = claim(&faucetapi.FaucetRequestData{Faucet: faucetapi.Vocdoni, Network: "dev", From: third.Address().Bytes()})
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSignatureRequired.Code)
+	tokenClient := newTestHTTPclient(t, addr, &token)
+	resp, code = tokenClient.request("POST", []byte(fmt.Sprintf(
+		`{"faucet":"vocdoni","network":"dev","from":"%s"}`, third.Address().Hex())), "claim")
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSignatureRequired.Code)
+	resp, code = tokenClient.request("GET", nil, "vocdoni", "dev", third.Address().Hex())
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSignatureRequired.Code)
+
+	// should require a bearer token for the signed claims too unless public
+	router2 := httprouter.HTTProuter{}
+	qt.Assert(t, router2.Init("127.0.0.1", 0), qt.IsNil)
+	addr2, err := url.Parse("http://" + path.Join(router2.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api2 := faucetapi.NewAPI()
+	api2.SetStorage(shared)
+	qt.Assert(t, api2.SetSignedClaims(true, false), qt.IsNil)
+	qt.Assert(t, api2.Init(&router2, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	// the nonces issued and used are shared by the replicas using the storage
+	shareBody, err := json.Marshal(signedClaim(third, third.Address(), getNonce(third.Address()).Nonce))
+	qt.Assert(t, err, qt.IsNil)
+	replica := newTestHTTPclient(t, addr, nil)
+	c, tokenClient = newTestHTTPclient(t, addr2, nil), newTestHTTPclient(t, addr2, &token)
+	body, err := json.Marshal(signedClaim(third, third.Address(), getNonce(third.Address()).Nonce))
+	qt.Assert(t, err, qt.IsNil)
+	_, code = c.request("POST", body, "claim")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+	_, code = tokenClient.request("POST", shareBody, "claim")
+	qt.Assert(t, code, qt.Equals, 200)
+	resp, code = replica.request("POST", shareBody, "claim")
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidNonce.Code)
+}
+
+func TestSIWE(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	shared := storage.NewMemory()
+	api.SetStorage(shared)
+	qt.Assert(t, api.SetSIWE("faucet.test", time.Minute), qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	c := newTestHTTPclient(t, addr, nil)
+	errorCode := func(resp []byte) string {
+		errResp := &faucetapi.ErrorResponse{}
+		qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+		return errResp.Code
+	}
+
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	getNonce := func() string {
+		resp, code := c.request("GET", nil, "auth", "nonce")
+		qt.Assert(t, code, qt.Equals, 200)
+		nonce := &faucetapi.AuthNonceResponse{}
+		qt.Assert(t, json.Unmarshal(resp, nonce), qt.IsNil)
+		return nonce.Nonce
+	}
+	signInWith := func(keys *ethereum.SignKeys, domain, nonce string) ([]byte, int) {
+		message := fmt.Sprintf("%s wants you to sign in with your Ethereum account:\n%s\n\n"+
+			"Sign in to the faucet\n\nURI: https://%s\nVersion: 1\nChain ID: 1\nNonce: %s\nIssued At: %s",
+			domain, signer.Address().Hex(), domain, nonce, time.Now().UTC().Format(time.RFC3339))
+		signature, err := keys.SignEthereum([]byte(message))
+		qt.Assert(t, err, qt.IsNil)
+		body, err := json.Marshal(&faucetapi.AuthVerifyRequest{Message: message, Signature: signature})
+		qt.Assert(t, err, qt.IsNil)
+		return c.request("POST", body, "auth", "verify")
+	}
+	signIn := func(domain, nonce string) ([]byte, int) {
+		return signInWith(signer, domain, nonce)
+	}
+
+	// should not use the nonce of an invalid signature
+	other := ethereum.NewSignKeys()
+	qt.Assert(t, other.Generate(), qt.IsNil)
+	nonce := getNonce()
+	resp, code := signInWith(other, "faucet.test", nonce)
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidSignature.Code)
+
+	// should sign in and claim for the signed in address
+	for i := 0; i < 10; i++ {
+		getNonce()
+	}
+	resp, code = signIn("faucet.test", nonce)
+	qt.Assert(t, code, qt.Equals, 200)
+	session := &faucetapi.AuthSessionResponse{}
+	qt.Assert(t, json.Unmarshal(resp, session), qt.IsNil)
+	qt.Assert(t, session.Address, qt.Equals, signer.Address())
+	sc := newTestHTTPclient(t, addr, nil)
+	sc.session = session.Token
+	_, code = sc.request("GET", nil, "vocdoni", "dev", signer.Address().Hex())
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// should not claim for other addresses with the session
+	resp, code = sc.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSessionAddress.Code)
+	body, err := json.Marshal(&faucetapi.FaucetRequestData{
+		Faucet: faucetapi.Vocdoni, Network: "dev", From: randomEVMAddress.Bytes(),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	resp, code = sc.request("POST", body, "claim")
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSessionAddress.Code)
+
+	// the cooldowns apply to the signed in address
+	resp, code = sc.request("POST", []byte(fmt.Sprintf(
+		`{"faucet":"vocdoni","network":"dev","from":"%s"}`, signer.Address().Hex())), "claim")
+	qt.Assert(t, code, qt.Equals, 409)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrPendingPackage.Code)
+
+	// should refuse reused nonces, other domains and unknown tokens
+	resp, code = signIn("faucet.test", nonce)
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidNonce.Code)
+	resp, code = signIn("faucet.test", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidNonce.Code)
+	resp, code = signIn("evil.test", getNonce())
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidRequest.Code)
+	sc.session = "invalid"
+	resp, code = sc.request("GET", nil, "vocdoni", "dev", signer.Address().Hex())
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrInvalidToken.Code)
+
+	// the bearer tokens are still accepted for any address
+	_, code = newTestHTTPclient(t, addr, &token).request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// the sessions are shared by the replicas using the storage
+	router2 := httprouter.HTTProuter{}
+	qt.Assert(t, router2.Init("127.0.0.1", 0), qt.IsNil)
+	addr2, err := url.Parse("http://" + path.Join(router2.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api2 := faucetapi.NewAPI()
+	api2.SetStorage(shared)
+	qt.Assert(t, api2.SetSIWE("faucet.test", time.Minute), qt.IsNil)
+	qt.Assert(t, api2.Init(&router2, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	sc = newTestHTTPclient(t, addr2, nil)
+	sc.session = session.Token
+	resp, code = sc.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrSessionAddress.Code)
+}
+
+func TestIdentityGate(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	github := identity.NewFakeGitHub()
+	defer github.Close()
+	api := faucetapi.NewAPI()
+	apiStore := storage.NewMemory()
+	api.SetStorage(apiStore)
+	qt.Assert(t, api.SetIdentityGate(github.Provider(), 24*time.Hour, time.Hour), qt.IsNil)
+	qt.Assert(t, api.SetTiers(map[string]*faucetapi.Tier{"partners": {
+		Identities: []string{"github:3"},
+		Networks:   map[string]*faucetapi.TierNetwork{"dev": {Amount: 500}},
+	}}), qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+	errorCode := func(resp []byte) string {
+		errResp := &faucetapi.ErrorResponse{}
+		qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+		return errResp.Code
+	}
+	login := func(code string) ([]byte, int) {
+		resp, status := c.request("GET", nil, "identity", "github", "login")
+		qt.Assert(t, status, qt.Equals, 200)
+		loginResp := &faucetapi.IdentityLoginResponse{}
+		qt.Assert(t, json.Unmarshal(resp, loginResp), qt.IsNil)
+		authURL, err := url.Parse(loginResp.URL)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, authURL.Query().Get("state"), qt.Equals, loginResp.State)
+		body, err := json.Marshal(&faucetapi.IdentityVerifyRequest{Code: code, State: loginResp.State})
+		qt.Assert(t, err, qt.IsNil)
+		return c.request("POST", body, "identity", "github", "verify")
+	}
+	claim := func(identityToken string, to evmcommon.Address) ([]byte, int) {
+		req, err := http.NewRequest("GET", addr.String()+"/vocdoni/dev/"+to.Hex(), nil)
+		qt.Assert(t, err, qt.IsNil)
+		req.Header.Set("Authorization", "Bearer "+token.String())
+		req.Header.Set(faucetapi.IdentityHeader, identityToken)
+		resp, err := http.DefaultClient.Do(req)
+		qt.Assert(t, err, qt.IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		qt.Assert(t, err, qt.IsNil)
+		return data, resp.StatusCode
+	}
+
+	// should refuse the accounts younger than the minimum age
+	github.AddCode("new", &identity.Identity{ID: "1", Login: "new", CreatedAt: time.Now().Add(-time.Hour)})
+	resp, code := login("new")
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrIdentityRejected.Code)
+	resp, code = login("unknown")
+	qt.Assert(t, code, qt.Equals, 502)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrIdentityProvider.Code)
+
+	// should claim once per identity during the cooldown, with any address
+	github.AddCode("old", &identity.Identity{ID: "2", Login: "old", CreatedAt: time.Now().Add(-48 * time.Hour)})
+	resp, code = login("old")
+	qt.Assert(t, code, qt.Equals, 200)
+	identityResp := &faucetapi.IdentityResponse{}
+	qt.Assert(t, json.Unmarshal(resp, identityResp), qt.IsNil)
+	qt.Assert(t, identityResp.Login, qt.Equals, "old")
+	resp, code = claim("", randomEVMAddress)
+	qt.Assert(t, code, qt.Equals, 401)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrIdentityRequired.Code)
+	_, code = claim(identityResp.Token, randomEVMAddress)
+	qt.Assert(t, code, qt.Equals, 200)
+	resp, code = claim(identityResp.Token, evmcommon.HexToAddress("0x01"))
+	qt.Assert(t, code, qt.Equals, 429)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrCooldownActive.Code)
+	// the identity claims are kept in the storage, shared by the replicas
+	identityClaim, err := apiStore.Claim("identity/github:2/vocdoni/dev")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, identityClaim.Count, qt.Equals, uint64(1))
+
+	// the failed claims do not count
+	github.AddCode("old2", &identity.Identity{ID: "3", Login: "old2", CreatedAt: time.Now().Add(-48 * time.Hour)})
+	resp, code = login("old2")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, identityResp), qt.IsNil)
+	resp, code = claim(identityResp.Token, randomEVMAddress)
+	qt.Assert(t, code, qt.Equals, 409)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrPendingPackage.Code)
+	_, err = apiStore.Claim("identity/github:3/vocdoni/dev")
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+	// the identities of a tier are granted the tier amount
+	resp, code = claim(identityResp.Token, evmcommon.HexToAddress("0x02"))
+	qt.Assert(t, code, qt.Equals, 200)
+	faucetResp := &faucetapi.FaucetResponse{}
+	qt.Assert(t, json.Unmarshal(resp, faucetResp), qt.IsNil)
+	qt.Assert(t, faucetResp.Amount, qt.Equals, "500")
+	qt.Assert(t, faucetResp.Tier, qt.Equals, "partners")
+}
+
+func TestAddressLists(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	api.SetAddressLists(faucet.NewAddressLists([]string{"dev"}))
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	adminToken, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), adminToken.String(), false, true, v, faucet.NewEVM()),
+		qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+	admin := newTestHTTPclient(t, addr, &adminToken)
+	errorCode := func(resp []byte) string {
+		errResp := &faucetapi.ErrorResponse{}
+		qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+		return errResp.Code
+	}
+	manage := func(list, action string, entry *faucet.ListEntry) ([]byte, int) {
+		body, err := json.Marshal(entry)
+		qt.Assert(t, err, qt.IsNil)
+		return admin.request("POST", body, "admin", "lists", list, action)
+	}
+	denied := evmcommon.HexToAddress("0x01")
+
+	// should require the admin token
+	_, code := c.request("GET", nil, "admin", "lists")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+
+	// should refuse the denied addresses with the reason
+	resp, code := manage("deny", "add", &faucet.ListEntry{Address: denied, Reason: "abuse"})
+	qt.Assert(t, code, qt.Equals, 200)
+	lists := &faucetapi.ListsResponse{}
+	qt.Assert(t, json.Unmarshal(resp, lists), qt.IsNil)
+	qt.Assert(t, lists.Restricted, qt.DeepEquals, []string{"dev"})
+	qt.Assert(t, lists.Deny, qt.HasLen, 1)
+	qt.Assert(t, lists.Deny[0].Reason, qt.Equals, "abuse")
+	qt.Assert(t, lists.Deny[0].Source, qt.Equals, faucet.ListSourceAdmin)
+	resp, code = c.request("GET", nil, "vocdoni", "dev", denied.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrAddressDenied.Code)
+
+	// should only accept the allowed addresses on the restricted networks
+	resp, code = c.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrAddressNotAllowed.Code)
+	_, code = manage("allow", "add", &faucet.ListEntry{Address: randomEVMAddress, Network: "dev"})
+	qt.Assert(t, code, qt.Equals, 200)
+	_, code = c.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// should remove the entries
+	_, code = manage("deny", "remove", &faucet.ListEntry{Address: denied})
+	qt.Assert(t, code, qt.Equals, 200)
+	resp, code = c.request("GET", nil, "vocdoni", "dev", denied.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrAddressNotAllowed.Code)
+	resp, code = manage("deny", "remove", &faucet.ListEntry{Address: denied})
+	qt.Assert(t, code, qt.Equals, 404)
+	qt.Assert(t, errorCode(resp), qt.Equals, faucetapi.ErrListEntryNotFound.Code)
+	_, code = manage("other", "add", &faucet.ListEntry{Address: denied})
+	qt.Assert(t, code, qt.Equals, 400)
+}
+
+func TestAuditLog(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	audit, err := faucet.OpenAuditLog(filepath.Join(t.TempDir(), faucet.AuditLogFile))
+	qt.Assert(t, err, qt.IsNil)
+	defer audit.Close()
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	api.SetAuditLog(audit)
+	api.SetAddressLists(faucet.NewAddressLists(nil))
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	adminToken, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), adminToken.String(), false, true, v, faucet.NewEVM()),
+		qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+	admin := newTestHTTPclient(t, addr, &adminToken)
+	denied := evmcommon.HexToAddress("0x01")
+
+	// should record the granted and the refused claims and the admin changes
+	resp, code := c.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 200)
+	granted := &faucetapi.FaucetResponse{}
+	qt.Assert(t, json.Unmarshal(resp, granted), qt.IsNil)
+	body, err := json.Marshal(&faucet.ListEntry{Address: denied, Reason: "abuse"})
+	qt.Assert(t, err, qt.IsNil)
+	_, code = admin.request("POST", body, "admin", "lists", "deny", "add")
+	qt.Assert(t, code, qt.Equals, 200)
+	_, code = c.request("GET", nil, "vocdoni", "dev", denied.Hex())
+	qt.Assert(t, code, qt.Equals, 403)
+
+	// should require the admin token for exporting
+	_, code = c.request("GET", nil, "admin", "audit")
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+	resp, code = admin.request("GET", nil, "admin", "audit")
+	qt.Assert(t, code, qt.Equals, 200)
+	export := &faucetapi.AuditResponse{}
+	qt.Assert(t, json.Unmarshal(resp, export), qt.IsNil)
+	qt.Assert(t, export.Seq, qt.Equals, uint64(3))
+	qt.Assert(t, export.Entries, qt.HasLen, 3)
+	qt.Assert(t, export.Head, qt.DeepEquals, export.Entries[2].Hash)
+
+	claim := export.Entries[0]
+	qt.Assert(t, claim.Kind, qt.Equals, faucet.AuditKindClaim)
+	qt.Assert(t, claim.Decision, qt.Equals, faucet.AuditDecisionGranted)
+	qt.Assert(t, *claim.Address, qt.Equals, randomEVMAddress)
+	qt.Assert(t, claim.Network, qt.Equals, "dev")
+	qt.Assert(t, claim.Token, qt.Equals, faucet.TokenFingerprint(token.String()))
+	qt.Assert(t, claim.PackageIdentifier, qt.Equals, granted.Identifier)
+	qt.Assert(t, claim.Amount, qt.Equals, granted.Amount)
+
+	change := export.Entries[1]
+	qt.Assert(t, change.Kind, qt.Equals, faucet.AuditKindAdmin)
+	qt.Assert(t, change.Action, qt.Equals, "lists.deny.add")
+	qt.Assert(t, change.Details["reason"], qt.Equals, "abuse")
+	qt.Assert(t, change.Token, qt.Equals, faucet.TokenFingerprint(adminToken.String()))
+
+	refused := export.Entries[2]
+	qt.Assert(t, refused.Decision, qt.Equals, faucet.AuditDecisionRefused)
+	qt.Assert(t, refused.Error, qt.Contains, "abuse")
+
+	// should filter the entries by time
+	resp, code = admin.request("GET", nil, "admin", "audit?from="+time.Now().UTC().Add(time.Hour).Format(time.RFC3339))
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, export), qt.IsNil)
+	qt.Assert(t, export.Entries, qt.HasLen, 0)
+	_, code = admin.request("GET", nil, "admin", "audit?from=yesterday")
+	qt.Assert(t, code, qt.Equals, 400)
+}
+
+func TestTiers(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	partnerToken, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	tiersFile := filepath.Join(t.TempDir(), "tiers.json")
+	qt.Assert(t, os.WriteFile(tiersFile, []byte(`{
+		"partners": {
+			"tokens": ["`+partnerToken.String()+`"],
+			"networks": {"dev": {"amount": 1000, "cooldown": "1h"}}
+		}
+	}`), 0o600), qt.IsNil)
+	tiers, err := faucetapi.LoadTiers(tiersFile)
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	qt.Assert(t, api.SetTiers(tiers), qt.IsNil)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	claim := func(c *testHTTPclient, to evmcommon.Address) (*faucetapi.FaucetResponse, int) {
+		resp, code := c.request("GET", nil, "vocdoni", "dev", to.Hex())
+		faucetResp := &faucetapi.FaucetResponse{}
+		if code == 200 {
+			qt.Assert(t, json.Unmarshal(resp, faucetResp), qt.IsNil)
+		}
+		return faucetResp, code
+	}
+
+	// should grant the network amount without tier
+	resp, code := claim(newTestHTTPclient(t, addr, &token), randomEVMAddress)
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, resp.Amount, qt.Equals, "100")
+	qt.Assert(t, resp.Tier, qt.Equals, "")
+
+	// should accept the tier token and grant the tier amount
+	partner := newTestHTTPclient(t, addr, &partnerToken)
+	resp, code = claim(partner, evmcommon.HexToAddress("0x01"))
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, resp.Amount, qt.Equals, "1000")
+	qt.Assert(t, resp.Tier, qt.Equals, "partners")
+
+	// should not accept invalid tiers
+	qt.Assert(t, api.SetTiers(map[string]*faucetapi.Tier{
+		"a": {Tokens: []string{partnerToken.String()}},
+		"b": {Tokens: []string{strings.ToUpper(partnerToken.String())}},
+	}), qt.ErrorMatches, "token of tier . already in tier .")
+	qt.Assert(t, api.SetTiers(map[string]*faucetapi.Tier{
+		"a": {Tokens: []string{"token"}},
+	}), qt.ErrorMatches, "invalid token of tier a: .*")
+	qt.Assert(t, api.SetTiers(map[string]*faucetapi.Tier{
+		"a": {Networks: map[string]*faucetapi.TierNetwork{"dev": {Cooldown: "invalid"}}},
+	}), qt.IsNotNil)
+}
+
+func TestTokenQuota(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+
+	// should keep the remaining requests of a stored token
+	store := storage.NewMemory()
+	qt.Assert(t, store.SetToken(&storage.Token{Token: token.String(), Remaining: 1}), qt.IsNil)
+	api := faucetapi.NewAPI()
+	api.SetStorage(store)
+	qt.Assert(t, api.Init(&router, "/faucet", token.String(), "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	c := newTestHTTPclient(t, addr, &token)
+	_, code := c.request("GET", nil, "vocdoni", "dev", randomEVMAddress.Hex())
+	qt.Assert(t, code, qt.Equals, 200)
+	stored, err := store.Token(token.String())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored.Remaining, qt.Equals, uint64(0))
+
+	// should refuse the token without requests left
+	resp, code := c.request("GET", nil, "vocdoni", "dev", evmcommon.HexToAddress("0x01").Hex())
+	qt.Assert(t, code, qt.Equals, 401)
+	errResp := &faucetapi.ErrorResponse{}
+	qt.Assert(t, json.Unmarshal(resp, errResp), qt.IsNil)
+	qt.Assert(t, errResp.Code, qt.Equals, faucetapi.ErrInvalidToken.Code)
 }
 
 type testHTTPclient struct {
 	c     *http.Client
 	token *uuid.UUID
-	addr  *url.URL
-	t     *testing.T
+	// session is a sign in session token, used instead of the token if set
+	session string
+	addr    *url.URL
+	t       *testing.T
 }
 
 func (c *testHTTPclient) request(method string, body []byte, urlPath ...string) ([]byte, int) {
 	u, err := url.Parse(c.addr.String())
 	qt.Assert(c.t, err, qt.IsNil)
-	u.Path = path.Join(u.Path, path.Join(urlPath...))
+	urlPathQuery, query, _ := strings.Cut(path.Join(urlPath...), "?")
+	u.Path = path.Join(u.Path, urlPathQuery)
+	u.RawQuery = query
 	headers := http.Header{}
 	if c.token != nil {
 		headers = http.Header{"Authorization": []string{"Bearer " + c.token.String()}}
 	}
+	if c.session != "" {
+		headers = http.Header{"Authorization": []string{"Bearer " + c.session}}
+	}
 	resp, err := c.c.Do(&http.Request{
 		Method: method,
 		URL:    u,
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+// AuditResponse represents an export of the audit log
+type AuditResponse struct {
+	// Seq is the sequence of the last entry of the log
+	Seq uint64 `json:"seq"`
+	// Head is the hash of the last entry of the log
+	Head types.HexBytes `json:"head"`
+	// Entries are the exported entries
+	Entries []*faucet.AuditEntry `json:"entries"`
+}
+
+// SetAuditLog records the claims and the admin changes in the given audit
+// log, which can be exported with the admin API. It must be called before Init.
+func (a *API) SetAuditLog(audit *faucet.AuditLog) {
+	a.audit = audit
+}
+
+func (a *API) enableAuditHandlers() error {
+	if a.audit == nil {
+		return nil
+	}
+	return a.registerMethod(
+		"/admin/audit",
+		"GET",
+		bearerstdapi.MethodAccessTypeAdmin,
+		a.auditHandler,
+	)
+}
+
+// record appends the entry to the audit log, the failures are logged as the
+// audited action is already done
+func (a *API) record(entry *faucet.AuditEntry) {
+	if err := a.audit.Record(entry); err != nil {
+		log.Errorw("cannot record audit log entry", "kind", entry.Kind, "action", entry.Action, "error", err.Error())
+	}
+}
+
+// auditClaim records a claim and its outcome, the response if granted or the
+// error if refused
+func (a *API) auditClaim(ctx *httprouter.HTTPContext,
+	token string,
+	req *FaucetRequestData,
+	resp *FaucetResponse,
+	err error,
+) {
+	if a.audit == nil {
+		return
+	}
+	to := common.BytesToAddress(req.From)
+	entry := &faucet.AuditEntry{
+		Kind:     faucet.AuditKindClaim,
+		Action:   "claim",
+		Faucet:   req.Faucet,
+		Network:  req.Network,
+		Address:  &to,
+		Token:    faucet.TokenFingerprint(token),
+		Decision: faucet.AuditDecisionGranted,
+		Details:  req.Metadata,
+	}
+	if a.identities != nil {
+		if id := a.identities.identity(ctx.Request.Header.Get(IdentityHeader)); id != nil {
+			entry.Identity = id.Key()
+		}
+	}
+	if err != nil {
+		entry.Decision = faucet.AuditDecisionRefused
+		entry.Error = err.Error()
+	}
+	if resp != nil {
+		entry.Amount = resp.Amount
+		entry.Tier = resp.Tier
+		entry.TxHash = resp.TxHash
+		entry.PackageIdentifier = resp.Identifier
+	}
+	a.record(entry)
+}
+
+// auditAdmin records an admin change and its outcome
+func (a *API) auditAdmin(msg *bearerstdapi.BearerStandardAPIdata, entry *faucet.AuditEntry, err error) {
+	if a.audit == nil {
+		return
+	}
+	entry.Kind = faucet.AuditKindAdmin
+	entry.Token = faucet.TokenFingerprint(msg.AuthToken)
+	entry.Decision = faucet.AuditDecisionGranted
+	if err != nil {
+		entry.Decision = faucet.AuditDecisionRefused
+		entry.Error = err.Error()
+	}
+	a.record(entry)
+}
+
+// exports the audit log entries, optionally recorded within the from and to
+// query times (RFC3339)
+func (a *API) auditHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	var times [2]time.Time
+	for i, param := range []string{"from", "to"} {
+		value := ctx.Request.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return ErrInvalidRequest.Withf("invalid %s time: %s", param, err)
+		}
+		times[i] = t
+	}
+	entries, err := a.audit.Entries(times[0], times[1])
+	if err != nil {
+		return err
+	}
+	seq, head := a.audit.Head()
+	data, err := json.Marshal(&AuditResponse{Seq: seq, Head: head, Entries: entries})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+// APIError is an error returned to the API callers with a stable code
+type APIError struct {
+	// Code is the stable identifier of the error
+	Code string
+	// HTTPstatus is the HTTP status code of the response
+	HTTPstatus int
+	// Message is the human-readable description of the error
+	Message string
+	// RetryAfter is the time after which the request can be retried, 0 if
+	// retrying is not expected to succeed
+	RetryAfter time.Duration
+	// err is the underlying error, it is never exposed to the callers
+	err error
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the target is an APIError with the same code
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code == e.Code
+}
+
+// Withf returns a copy of the error with the formatted details appended to the message
+func (e *APIError) Withf(format string, args ...interface{}) *APIError {
+	err := *e
+	err.Message = fmt.Sprintf("%s: %s", e.Message, fmt.Sprintf(format, args...))
+	return &err
+}
+
+// WithErr returns a copy of the error wrapping the given underlying error
+func (e *APIError) WithErr(werr error) *APIError {
+	err := *e
+	err.err = werr
+	return &err
+}
+
+var (
+	ErrInvalidToken = &APIError{
+		Code: "INVALID_TOKEN", HTTPstatus: http.StatusUnauthorized, Message: "invalid token",
+	}
+	ErrInvalidFromAddress = &APIError{
+		Code: "INVALID_ADDRESS", HTTPstatus: http.StatusBadRequest, Message: "invalid from address",
+	}
+	ErrInvalidRequest = &APIError{
+		Code: "INVALID_REQUEST", HTTPstatus: http.StatusBadRequest, Message: "invalid request",
+	}
+	ErrUnsupportedNetwork = &APIError{
+		Code: "UNSUPPORTED_NETWORK", HTTPstatus: http.StatusBadRequest, Message: "unsupported network",
+	}
+	ErrCooldownActive = &APIError{
+		Code: "COOLDOWN_ACTIVE", HTTPstatus: http.StatusTooManyRequests, Message: "cooldown active",
+	}
+	ErrBalanceAboveThreshold = &APIError{
+		Code: "BALANCE_ABOVE_THRESHOLD", HTTPstatus: http.StatusForbidden, Message: "balance above threshold",
+	}
+	ErrPendingPackage = &APIError{
+		Code: "PENDING_PACKAGE", HTTPstatus: http.StatusConflict, Message: "address has an unredeemed faucet package",
+	}
+	ErrPackageNotFound = &APIError{
+		Code: "PACKAGE_NOT_FOUND", HTTPstatus: http.StatusNotFound, Message: "faucet package not found",
+	}
+	ErrContractRecipient = &APIError{
+		Code: "CONTRACT_RECIPIENT", HTTPstatus: http.StatusForbidden, Message: "recipient is a contract",
+	}
+	ErrSuspiciousRecipient = &APIError{
+		Code: "SUSPICIOUS_RECIPIENT", HTTPstatus: http.StatusForbidden, Message: "recipient refused by the sybil rules",
+	}
+	ErrGasLimitTooHigh = &APIError{
+		Code: "GAS_LIMIT_TOO_HIGH", HTTPstatus: http.StatusForbidden, Message: "gas limit above the configured maximum",
+	}
+	ErrGasPriceTooHigh = &APIError{
+		Code: "GAS_PRICE_TOO_HIGH", HTTPstatus: http.StatusServiceUnavailable,
+		Message: "gas price above the configured cap",
+	}
+	ErrFaucetEmpty = &APIError{
+		Code: "FAUCET_EMPTY", HTTPstatus: http.StatusServiceUnavailable, Message: "faucet has not enough funds",
+	}
+	ErrSignerUnavailable = &APIError{
+		Code: "SIGNER_UNAVAILABLE", HTTPstatus: http.StatusServiceUnavailable,
+		Message: "no faucet signer available", RetryAfter: time.Minute,
+	}
+	ErrNotAvailable = &APIError{
+		Code: "NOT_AVAILABLE", HTTPstatus: http.StatusNotImplemented, Message: "feature not available",
+	}
+	ErrKeyNotFound = &APIError{
+		Code: "KEY_NOT_FOUND", HTTPstatus: http.StatusNotFound, Message: "signer key not found",
+	}
+	ErrInvalidKeyState = &APIError{
+		Code: "INVALID_KEY_STATE", HTTPstatus: http.StatusConflict, Message: "invalid signer key state",
+	}
+	ErrInvalidSignature = &APIError{
+		Code: "INVALID_SIGNATURE", HTTPstatus: http.StatusUnauthorized,
+		Message: "signature does not match the address",
+	}
+	ErrSignatureRequired = &APIError{
+		Code: "SIGNATURE_REQUIRED", HTTPstatus: http.StatusUnauthorized,
+		Message: "the claims must be signed by the from address",
+	}
+	ErrInvalidNonce = &APIError{
+		Code: "INVALID_NONCE", HTTPstatus: http.StatusUnauthorized,
+		Message: "nonce unknown, expired or already used",
+	}
+	ErrTooManySessions = &APIError{
+		Code: "TOO_MANY_SESSIONS", HTTPstatus: http.StatusServiceUnavailable,
+		Message: "too many sign in sessions", RetryAfter: time.Minute,
+	}
+	ErrSessionAddress = &APIError{
+		Code: "SESSION_ADDRESS", HTTPstatus: http.StatusForbidden,
+		Message: "the session can only claim for its signed in address",
+	}
+	ErrIdentityRequired = &APIError{
+		Code: "IDENTITY_REQUIRED", HTTPstatus: http.StatusUnauthorized,
+		Message: "a valid identity token is required",
+	}
+	ErrIdentityRejected = &APIError{
+		Code: "IDENTITY_REJECTED", HTTPstatus: http.StatusForbidden, Message: "identity not allowed to claim",
+	}
+	ErrIdentityProvider = &APIError{
+		Code: "IDENTITY_PROVIDER", HTTPstatus: http.StatusBadGateway, Message: "identity provider error",
+	}
+	ErrAddressDenied = &APIError{
+		Code: "ADDRESS_DENIED", HTTPstatus: http.StatusForbidden, Message: "address denied",
+	}
+	ErrAddressNotAllowed = &APIError{
+		Code: "ADDRESS_NOT_ALLOWED", HTTPstatus: http.StatusForbidden, Message: "address not allowed on this network",
+	}
+	ErrListEntryNotFound = &APIError{
+		Code: "LIST_ENTRY_NOT_FOUND", HTTPstatus: http.StatusNotFound, Message: "list entry not found",
+	}
+	ErrAuditChainBroken = &APIError{
+		Code: "AUDIT_CHAIN_BROKEN", HTTPstatus: http.StatusInternalServerError, Message: "audit log hash chain broken",
+	}
+	ErrInternal = &APIError{
+		Code: "INTERNAL_ERROR", HTTPstatus: http.StatusInternalServerError, Message: "internal error",
+	}
+)
+
+// faucetErrors maps the faucet errors that can be exposed to the API errors
+var faucetErrors = []struct {
+	err    error
+	apiErr *APIError
+}{
+	{faucet.ErrInvalidNetwork, ErrUnsupportedNetwork},
+	{faucet.ErrCooldownActive, ErrCooldownActive},
+	{faucet.ErrBalanceAboveThreshold, ErrBalanceAboveThreshold},
+	{faucet.ErrPendingPackage, ErrPendingPackage},
+	{faucet.ErrPackageNotFound, ErrPackageNotFound},
+	{faucet.ErrPackagesNotTracked, ErrNotAvailable},
+	{faucet.ErrContractRecipient, ErrContractRecipient},
+	{faucet.ErrSuspiciousRecipient, ErrSuspiciousRecipient},
+	{faucet.ErrGasLimitTooHigh, ErrGasLimitTooHigh},
+	{faucet.ErrGasPriceTooHigh, ErrGasPriceTooHigh},
+	{faucet.ErrFaucetEmpty, ErrFaucetEmpty},
+	{faucet.ErrSignerUnavailable, ErrSignerUnavailable},
+	{faucet.ErrTopUpUnavailable, ErrNotAvailable},
+	{faucet.ErrKeyNotFound, ErrKeyNotFound},
+	{faucet.ErrKeyExists, ErrInvalidKeyState},
+	{faucet.ErrInvalidKeyState, ErrInvalidKeyState},
+	{faucet.ErrLastActiveKey, ErrInvalidKeyState},
+	{faucet.ErrKeyPending, ErrInvalidKeyState},
+	{faucet.ErrAddressDenied, ErrAddressDenied},
+	{faucet.ErrAddressNotAllowed, ErrAddressNotAllowed},
+	{faucet.ErrListEntryNotFound, ErrListEntryNotFound},
+	{faucet.ErrInvalidListEntry, ErrInvalidRequest},
+	{faucet.ErrAuditChainBroken, ErrAuditChainBroken},
+}
+
+// toAPIError returns the API error to expose for the given handler error,
+// the errors not known by the API are exposed as internal errors
+func toAPIError(err error) *APIError {
+	// faucet errors first, as the API errors may wrap them
+	for _, fe := range faucetErrors {
+		if !errors.Is(err, fe.err) {
+			continue
+		}
+		apiErr := *fe.apiErr
+		apiErr.err = err
+		// the client errors are descriptive, expose them
+		if apiErr.HTTPstatus < http.StatusInternalServerError {
+			apiErr.Message = err.Error()
+		}
+		cooldownErr := &faucet.CooldownError{}
+		if errors.As(err, &cooldownErr) {
+			apiErr.RetryAfter = time.Until(cooldownErr.NextAt)
+		}
+		return &apiErr
+	}
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return ErrInternal.WithErr(err)
+}
+
+// ErrorResponse represents the response of a failed request
+type ErrorResponse struct {
+	// Error is the human-readable description of the error
+	Error string `json:"error"`
+	// Code is the stable identifier of the error
+	Code string `json:"code"`
+	// RetryAfter is the number of seconds after which the request can be retried
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+}
+
+// registerMethod registers a handler whose errors are replied as API errors
+func (a *API) registerMethod(pattern, HTTPmethod, accessType string, handler bearerstdapi.BearerStdAPIhandler) error {
+	if err := a.api.RegisterMethod(pattern, HTTPmethod, accessType,
+		func(msg *bearerstdapi.BearerStandardAPIdata, ctx *httprouter.HTTPContext) error {
+			if err := handler(msg, ctx); err != nil {
+				sendError(ctx, err)
+			}
+			return nil
+		}); err != nil {
+		return err
+	}
+	a.routes = append(a.routes, Route{Method: HTTPmethod, Pattern: pattern})
+	return nil
+}
+
+// sendError replies the request with the API error for the given handler
+// error, the internal errors are logged but their details are not exposed
+func sendError(ctx *httprouter.HTTPContext, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.HTTPstatus >= http.StatusInternalServerError {
+		log.Warnw("api request failed",
+			"path", ctx.Request.URL.Path,
+			"code", apiErr.Code,
+			"error", err.Error(),
+		)
+	}
+	resp := &ErrorResponse{Error: apiErr.Message, Code: apiErr.Code}
+	if apiErr.RetryAfter > 0 {
+		// round up, so the request is not retried before time
+		resp.RetryAfter = int64((apiErr.RetryAfter + time.Second - 1) / time.Second)
+		ctx.Writer.Header().Set("Retry-After", fmt.Sprint(resp.RetryAfter))
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if err := ctx.Send(data, apiErr.HTTPstatus); err != nil {
+		log.Warn(err)
+	}
+}
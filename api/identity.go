@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/identity"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+const (
+	// IdentityHeader is the header carrying the identity token of the claims
+	IdentityHeader = "X-Faucet-Identity"
+	// DefaultIdentityCooldown is the default minimum time between claims of an identity
+	DefaultIdentityCooldown = 24 * time.Hour
+	// identityTokenTTL is the validity of the identity tokens
+	identityTokenTTL = time.Hour
+)
+
+// IdentityLoginResponse represents the start of an identity authentication
+type IdentityLoginResponse struct {
+	// URL is the provider URL to redirect the user to
+	URL string `json:"url"`
+	// State is the state the provider redirects back with
+	State string `json:"state"`
+}
+
+// IdentityVerifyRequest represents the end of an identity authentication
+type IdentityVerifyRequest struct {
+	// Code is the code the provider redirected back with
+	Code string `json:"code"`
+	// State is the state the provider redirected back with
+	State string `json:"state"`
+}
+
+// IdentityResponse represents an authenticated identity
+type IdentityResponse struct {
+	*identity.Identity
+	// Token is the identity token to send in the X-Faucet-Identity header of the claims
+	Token string `json:"token"`
+	// ExpiresAt is the time after which the token is no longer accepted
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// identityToken represents an issued identity token
+type identityToken struct {
+	identity  *identity.Identity
+	expiresAt time.Time
+}
+
+// identityGate requires an authenticated identity for claiming, with one
+// claim per identity and network during the cooldown. The claims are kept in
+// the API storage, so they are shared by the replicas
+type identityGate struct {
+	provider      identity.Provider
+	minAccountAge time.Duration
+	cooldown      time.Duration
+	states        *signedNonces
+	tokens        map[string]*identityToken
+	lock          sync.Mutex
+}
+
+// SetIdentityGate requires the claims to include the token of an identity
+// authenticated by the given provider, with an account older than the minimum
+// age. Each identity can claim once per network during the cooldown, 0 means
+// DefaultIdentityCooldown. It must be called before Init.
+func (a *API) SetIdentityGate(provider identity.Provider, minAccountAge, cooldown time.Duration) error {
+	if provider == nil {
+		a.identities = nil
+		return nil
+	}
+	if cooldown == 0 {
+		cooldown = DefaultIdentityCooldown
+	}
+	states, err := newSignedNonces("identity-states")
+	if err != nil {
+		return err
+	}
+	a.identities = &identityGate{
+		provider:      provider,
+		minAccountAge: minAccountAge,
+		cooldown:      cooldown,
+		states:        states,
+		tokens:        make(map[string]*identityToken),
+	}
+	return nil
+}
+
+func (a *API) enableIdentityHandlers() error {
+	if a.identities == nil {
+		return nil
+	}
+	if err := a.registerMethod(
+		"/identity/"+a.identities.provider.Name()+"/login",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		a.identityLoginHandler,
+	); err != nil {
+		return err
+	}
+	return a.registerMethod(
+		"/identity/"+a.identities.provider.Name()+"/verify",
+		"POST",
+		bearerstdapi.MethodAccessTypePublic,
+		a.identityVerifyHandler,
+	)
+}
+
+// newToken returns a new token for the given identity
+func (ig *identityGate) newToken(id *identity.Identity) (string, time.Time, error) {
+	ig.lock.Lock()
+	defer ig.lock.Unlock()
+	now := time.Now()
+	if len(ig.tokens) >= maxSessions {
+		for token, it := range ig.tokens {
+			if now.After(it.expiresAt) {
+				delete(ig.tokens, token)
+			}
+		}
+		if len(ig.tokens) >= maxSessions {
+			return "", time.Time{}, ErrTooManySessions
+		}
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(data)
+	ig.tokens[token] = &identityToken{identity: id, expiresAt: now.Add(identityTokenTTL)}
+	return token, ig.tokens[token].expiresAt, nil
+}
+
+// identity returns the identity of the given token, nil if the token does not
+// exist or it is expired
+func (ig *identityGate) identity(token string) *identity.Identity {
+	ig.lock.Lock()
+	defer ig.lock.Unlock()
+	it, ok := ig.tokens[token]
+	if !ok || time.Now().After(it.expiresAt) {
+		return nil
+	}
+	return it.identity
+}
+
+// reserve records in the storage a claim of the identity of the given token
+// on the network, the returned function must be called if the claim fails
+func (ig *identityGate) reserve(store storage.Storage, token, faucet, network string) (func(), error) {
+	id := ig.identity(token)
+	if id == nil {
+		return nil, ErrIdentityRequired
+	}
+	key := "identity/" + id.Key() + "/" + faucet + "/" + network
+	previous, err := store.ReserveClaim(key, ig.cooldown)
+	cooldownErr := &storage.CooldownError{}
+	if errors.As(err, &cooldownErr) {
+		apiErr := ErrCooldownActive.Withf("identity %s already claimed", id.Login)
+		apiErr.RetryAfter = time.Until(cooldownErr.NextAt)
+		return nil, apiErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot reserve identity claim: %w", err)
+	}
+	return func() {
+		if err := store.ReleaseClaim(key, previous); err != nil {
+			log.Warnf("cannot release claim of identity %s: %s", id.Key(), err)
+		}
+	}, nil
+}
+
+// returns the provider URL for authenticating an identity
+func (a *API) identityLoginHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	state, _, err := a.identities.states.issue(a.identities.provider.Name())
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&IdentityLoginResponse{
+		URL:   a.identities.provider.AuthURL(state),
+		State: state,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// authenticates an identity with the provider redirect code and returns its token
+func (a *API) identityVerifyHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	req := &IdentityVerifyRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ErrInvalidRequest.Withf("cannot decode identity request: %s", err)
+	}
+	if _, ok := a.identities.states.check(req.State, a.identities.provider.Name()); !ok {
+		return ErrInvalidNonce
+	}
+	reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), 30*time.Second)
+	defer cancel()
+	id, err := a.identities.provider.Identity(reqCtx, req.Code)
+	if err != nil {
+		if errors.Is(err, identity.ErrProvider) {
+			return ErrIdentityProvider.WithErr(err)
+		}
+		return err
+	}
+	if time.Since(id.CreatedAt) < a.identities.minAccountAge {
+		return ErrIdentityRejected.Withf("account %s created at %s is too new",
+			id.Login, id.CreatedAt.Format(time.RFC3339))
+	}
+	used, err := a.identities.states.use(req.State, a.identities.provider.Name())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidNonce
+	}
+	token, expiresAt, err := a.identities.newToken(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&IdentityResponse{Identity: id, Token: token, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
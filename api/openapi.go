@@ -0,0 +1,34 @@
+package api
+
+import (
+	_ "embed"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+)
+
+// OpenAPI is the OpenAPI 3 document describing the faucet API, it must be
+// updated when a route is added or its request or response changes
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Route represents a method registered in the API
+type Route struct {
+	// Method is the HTTP method
+	Method string
+	// Pattern is the URL pattern relative to the API base route
+	Pattern string
+}
+
+// Routes returns the methods registered in the API
+func (a *API) Routes() []Route {
+	return a.routes
+}
+
+// returns the OpenAPI document of the faucet API
+func (a *API) openAPIHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	return ctx.Send(OpenAPI, bearerstdapi.HTTPstatusCodeOK)
+}
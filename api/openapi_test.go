@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/httprouter"
+	faucetapi "go.vocdoni.io/vocdoni-faucet/api"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/identity"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	api := faucetapi.NewAPI()
+	qt.Assert(t, api.SetSignedClaims(true, true), qt.IsNil)
+	qt.Assert(t, api.SetSIWE("faucet.test", 0), qt.IsNil)
+	qt.Assert(t, api.SetIdentityGate(identity.NewGitHub("", "", ""), 0, 0), qt.IsNil)
+	api.SetAddressLists(faucet.NewAddressLists(nil))
+	audit, err := faucet.OpenAuditLog(path.Join(t.TempDir(), faucet.AuditLogFile))
+	qt.Assert(t, err, qt.IsNil)
+	defer audit.Close()
+	api.SetAuditLog(audit)
+	qt.Assert(t, api.Init(&router, "/faucet", "", "admin", true, true, faucet.NewVocdoni(), faucet.NewEVM()),
+		qt.IsNil)
+
+	// should be served
+	resp, code := newTestHTTPclient(t, addr, nil).request("GET", nil, "openapi.json")
+	qt.Assert(t, code, qt.Equals, 200)
+	doc := &openAPIDocument{}
+	qt.Assert(t, json.Unmarshal(resp, doc), qt.IsNil)
+
+	// should describe every registered route and only them
+	routes := make(map[string]bool)
+	for _, route := range api.Routes() {
+		methods, ok := doc.Paths[route.Pattern]
+		qt.Assert(t, ok, qt.IsTrue, qt.Commentf("route %s missing in the spec", route.Pattern))
+		_, ok = methods[strings.ToLower(route.Method)]
+		qt.Assert(t, ok, qt.IsTrue, qt.Commentf("route %s %s missing in the spec", route.Method, route.Pattern))
+		routes[route.Pattern] = true
+	}
+	for pattern := range doc.Paths {
+		qt.Assert(t, routes[pattern], qt.IsTrue, qt.Commentf("route %s not registered", pattern))
+	}
+
+	// should describe the fields of the request and response types
+	schemas := map[string]interface{}{
+		"ErrorResponse":         faucetapi.ErrorResponse{},
+		"FaucetRequestData":     faucetapi.FaucetRequestData{},
+		"ClaimNonceResponse":    faucetapi.ClaimNonceResponse{},
+		"AuthNonceResponse":     faucetapi.AuthNonceResponse{},
+		"AuthVerifyRequest":     faucetapi.AuthVerifyRequest{},
+		"AuthSessionResponse":   faucetapi.AuthSessionResponse{},
+		"IdentityLoginResponse": faucetapi.IdentityLoginResponse{},
+		"IdentityVerifyRequest": faucetapi.IdentityVerifyRequest{},
+		"IdentityResponse":      faucetapi.IdentityResponse{},
+		"FaucetResponse":        faucetapi.FaucetResponse{},
+		"FaucetPackage":         faucetapi.FaucetPackage{},
+		"InfoResponse":          faucetapi.InfoResponse{},
+		"NetworkInfo":           faucetapi.NetworkInfo{},
+		"StatusResponse":        faucetapi.StatusResponse{},
+		"EVMStatus":             faucet.EVMStatus{},
+		"GasStatus":             faucet.GasStatus{},
+		"KeyStatus":             faucet.KeyStatus{},
+		"VocdoniInfoResponse":   faucetapi.VocdoniInfoResponse{},
+		"VocdoniNetworkInfo":    faucetapi.VocdoniNetworkInfo{},
+		"PackageStatusResponse": faucetapi.PackageStatusResponse{},
+		"KeysResponse":          faucetapi.KeysResponse{},
+		"KeyRotationRequest":    faucetapi.KeyRotationRequest{},
+		"ListsResponse":         faucetapi.ListsResponse{},
+		"ListEntry":             faucet.ListEntry{},
+		"AuditResponse":         faucetapi.AuditResponse{},
+		"AuditEntry":            faucet.AuditEntry{},
+	}
+	for name, value := range schemas {
+		schema, ok := doc.Components.Schemas[name]
+		qt.Assert(t, ok, qt.IsTrue, qt.Commentf("schema %s missing in the spec", name))
+		properties := []string{}
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		qt.Assert(t, properties, qt.DeepEquals, jsonFields(reflect.TypeOf(value)), qt.Commentf("schema %s", name))
+	}
+}
+
+// jsonFields returns the sorted JSON field names of the given struct type
+func jsonFields(typ reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			fields = append(fields, jsonFields(embedded)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
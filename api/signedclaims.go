@@ -0,0 +1,210 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// ClaimNonceTTL is the validity of the nonces issued for signing claims or
+// signing in
+const ClaimNonceTTL = 5 * time.Minute
+
+// ClaimNonceResponse represents a nonce issued for signing a claim
+type ClaimNonceResponse struct {
+	// Nonce is the single-use nonce to include in the claim request
+	Nonce string `json:"nonce"`
+	// Message is the message to sign with the EIP-191 (personal_sign) scheme
+	Message string `json:"message"`
+	// ExpiresAt is the time after which the nonce is no longer accepted
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ClaimMessage returns the message that the given address must sign for
+// claiming with the given nonce
+func ClaimMessage(address common.Address, nonce string) string {
+	return fmt.Sprintf("Vocdoni faucet claim\naddress: %s\nnonce: %s", address.Hex(), nonce)
+}
+
+// signedNonces issues single-use nonces authenticated with a secret: a nonce
+// carries its expiration and a MAC over its scope, so issuing nonces keeps no
+// state and cannot exhaust nor replace the nonces of others. Only the used
+// nonces are kept in the storage, until they expire
+type signedNonces struct {
+	// name of the secret in the storage
+	name    string
+	secret  []byte
+	storage storage.Storage
+	// pruned time of the last deletion of the expired used nonces
+	pruned time.Time
+	lock   sync.Mutex
+}
+
+// newSignedNonces returns a nonce issuer with a random secret, keeping the
+// used nonces in memory until another storage is set
+func newSignedNonces(name string) (*signedNonces, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce secret: %w", err)
+	}
+	return &signedNonces{name: name, secret: secret, storage: storage.NewMemory()}, nil
+}
+
+// setStorage keeps the used nonces in the given storage, with the secret
+// stored first in it, so the replicas sharing the storage accept the nonces
+// issued by each other
+func (sn *signedNonces) setStorage(s storage.Storage) error {
+	secret, err := s.Secret(sn.name, sn.secret)
+	if err != nil {
+		return fmt.Errorf("cannot get %s secret: %w", sn.name, err)
+	}
+	sn.secret = secret
+	sn.storage = s
+	return nil
+}
+
+// mac returns the MAC of the nonce data for the given scope
+func (sn *signedNonces) mac(scope string, data []byte) []byte {
+	mac := hmac.New(sha256.New, sn.secret)
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// issue returns a new nonce for the given scope (i.e the address of a claim),
+// the nonces are the expiration time, a random value and their MAC
+func (sn *signedNonces) issue(scope string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ClaimNonceTTL).Truncate(time.Second)
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(expiresAt.Unix()))
+	if _, err := rand.Read(data[8:]); err != nil {
+		return "", time.Time{}, err
+	}
+	return hex.EncodeToString(append(data, sn.mac(scope, data)...)), expiresAt, nil
+}
+
+// check returns the expiration of the given nonce, false if it was not issued
+// for the scope or it is expired. It does not check the nonce was not used
+func (sn *signedNonces) check(nonce, scope string) (time.Time, bool) {
+	data, err := hex.DecodeString(nonce)
+	if err != nil || len(data) != 32 || !hmac.Equal(data[16:], sn.mac(scope, data[:16])) {
+		return time.Time{}, false
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	return expiresAt, time.Now().Before(expiresAt)
+}
+
+// use records the given nonce as used, returns false if it was not issued for
+// the scope, it is expired or it was already used
+func (sn *signedNonces) use(nonce, scope string) (bool, error) {
+	expiresAt, ok := sn.check(nonce, scope)
+	if !ok {
+		return false, nil
+	}
+	sn.lock.Lock()
+	now := time.Now()
+	prune := now.Sub(sn.pruned) > ClaimNonceTTL
+	if prune {
+		sn.pruned = now
+	}
+	sn.lock.Unlock()
+	if prune {
+		if err := sn.storage.DeleteNonces(now); err != nil {
+			log.Warnf("cannot delete the expired nonces: %s", err)
+		}
+	}
+	err := sn.storage.UseNonce(nonce, expiresAt)
+	if errors.Is(err, storage.ErrConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot use nonce: %w", err)
+	}
+	return true, nil
+}
+
+// SetSignedClaims requires the claims to be signed by the from address over
+// a faucet nonce. If public is true the signed claims do not need a bearer
+// token either, otherwise they need both. It must be called before Init.
+func (a *API) SetSignedClaims(enabled, public bool) error {
+	if !enabled {
+		if public {
+			return fmt.Errorf("public claims require the signed claims")
+		}
+		a.claimNonces = nil
+		a.publicClaims = false
+		return nil
+	}
+	nonces, err := newSignedNonces("claim-nonces")
+	if err != nil {
+		return err
+	}
+	a.claimNonces = nonces
+	a.publicClaims = public
+	return nil
+}
+
+// verifySignedClaim checks the claim is signed by the from address over an
+// unused nonce issued for it, the nonce is only used by a valid signature
+func (a *API) verifySignedClaim(req *FaucetRequestData) error {
+	if len(req.Signature) == 0 {
+		return ErrSignatureRequired
+	}
+	from := common.BytesToAddress(req.From)
+	if _, ok := a.claimNonces.check(req.Nonce, from.Hex()); !ok {
+		return ErrInvalidNonce
+	}
+	signer, err := ethereum.AddrFromSignature([]byte(ClaimMessage(from, req.Nonce)), req.Signature)
+	if err != nil {
+		return ErrInvalidSignature.WithErr(err)
+	}
+	if signer != from {
+		return ErrInvalidSignature
+	}
+	used, err := a.claimNonces.use(req.Nonce, from.Hex())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidNonce
+	}
+	return nil
+}
+
+// returns a nonce for signing a claim of the from address
+func (a *API) claimNonceHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	from, err := a.fromParse(ctx.URLParam("from"))
+	if err != nil {
+		return err
+	}
+	nonce, expiresAt, err := a.claimNonces.issue(from.Hex())
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&ClaimNonceResponse{
+		Nonce:     nonce,
+		Message:   ClaimMessage(*from, nonce),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/bearerstdapi"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+const (
+	// DefaultSessionTTL is the default validity of the sign in sessions
+	DefaultSessionTTL = time.Hour
+	// maxSessions is the maximum number of identity tokens kept
+	maxSessions = 100000
+	// siwePreamble ends the first line of a Sign-In with Ethereum message
+	siwePreamble = " wants you to sign in with your Ethereum account:"
+)
+
+// AuthNonceResponse represents a nonce issued for signing in
+type AuthNonceResponse struct {
+	// Nonce is the single-use nonce to include in the sign in message
+	Nonce string `json:"nonce"`
+	// ExpiresAt is the time after which the nonce is no longer accepted
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AuthVerifyRequest represents a sign in request
+type AuthVerifyRequest struct {
+	// Message is the EIP-4361 message
+	Message string `json:"message"`
+	// Signature is the EIP-191 signature of the message
+	Signature types.HexBytes `json:"signature"`
+}
+
+// AuthSessionResponse represents a session of a signed in address
+type AuthSessionResponse struct {
+	// Token is the session token to use as bearer token
+	Token string `json:"token"`
+	// Address is the signed in address, the only one the session can claim for
+	Address common.Address `json:"address"`
+	// ExpiresAt is the time after which the session is no longer accepted
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// siweMessage represents the fields of an EIP-4361 message used by the faucet
+type siweMessage struct {
+	Domain         string
+	Address        common.Address
+	URI            string
+	Version        string
+	ChainID        string
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+}
+
+// parseSIWEMessage parses an EIP-4361 message, the statement and resources
+// are not used by the faucet so they are not returned
+func parseSIWEMessage(message string) (*siweMessage, error) {
+	lines := strings.Split(message, "\n")
+	if len(lines) < 3 || !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, fmt.Errorf("missing preamble")
+	}
+	msg := &siweMessage{Domain: strings.TrimSuffix(lines[0], siwePreamble)}
+	if i := strings.Index(msg.Domain, "://"); i >= 0 {
+		msg.Domain = msg.Domain[i+3:]
+	}
+	if !common.IsHexAddress(lines[1]) || !strings.HasPrefix(lines[1], "0x") {
+		return nil, fmt.Errorf("invalid address %q", lines[1])
+	}
+	msg.Address = common.HexToAddress(lines[1])
+	parseTime := func(value string) (*time.Time, error) {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q", value)
+		}
+		return &t, nil
+	}
+	for _, line := range lines[2:] {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			// blank lines, the statement and the resources
+			continue
+		}
+		var err error
+		switch key {
+		case "URI":
+			msg.URI = value
+		case "Version":
+			msg.Version = value
+		case "Chain ID":
+			msg.ChainID = value
+		case "Nonce":
+			msg.Nonce = value
+		case "Issued At":
+			var t *time.Time
+			if t, err = parseTime(value); err == nil {
+				msg.IssuedAt = *t
+			}
+		case "Expiration Time":
+			msg.ExpirationTime, err = parseTime(value)
+		case "Not Before":
+			msg.NotBefore, err = parseTime(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case msg.URI == "":
+		return nil, fmt.Errorf("missing URI")
+	case msg.Version != "1":
+		return nil, fmt.Errorf("unsupported version %q", msg.Version)
+	case msg.ChainID == "":
+		return nil, fmt.Errorf("missing chain ID")
+	case msg.Nonce == "":
+		return nil, fmt.Errorf("missing nonce")
+	case msg.IssuedAt.IsZero():
+		return nil, fmt.Errorf("missing issued at")
+	}
+	return msg, nil
+}
+
+// sessionStore keeps the sign in sessions in the storage until expired, so
+// the replicas sharing it accept the sessions of each other
+type sessionStore struct {
+	domain  string
+	ttl     time.Duration
+	nonces  *signedNonces
+	storage storage.Storage
+	// pruned time of the last deletion of the expired sessions
+	pruned time.Time
+	lock   sync.Mutex
+}
+
+// create returns a new session for the given address
+func (ss *sessionStore) create(address common.Address) (string, time.Time, error) {
+	ss.lock.Lock()
+	now := time.Now()
+	prune := now.Sub(ss.pruned) > ss.ttl
+	if prune {
+		ss.pruned = now
+	}
+	ss.lock.Unlock()
+	if prune {
+		if err := ss.storage.DeleteSessions(now); err != nil {
+			log.Warnf("cannot delete the expired sessions: %s", err)
+		}
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", time.Time{}, err
+	}
+	session := &storage.Session{
+		Token:     hex.EncodeToString(data),
+		Address:   address,
+		ExpiresAt: now.Add(ss.ttl),
+	}
+	if err := ss.storage.SetSession(session); err != nil {
+		return "", time.Time{}, fmt.Errorf("cannot store session: %w", err)
+	}
+	return session.Token, session.ExpiresAt, nil
+}
+
+// address returns the address of the given session token, false if the
+// session does not exist or it is expired
+func (ss *sessionStore) address(token string) (common.Address, bool, error) {
+	session, err := ss.storage.Session(token)
+	if errors.Is(err, storage.ErrNotFound) {
+		return common.Address{}, false, nil
+	}
+	if err != nil {
+		return common.Address{}, false, fmt.Errorf("cannot get session: %w", err)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return common.Address{}, false, nil
+	}
+	return session.Address, true, nil
+}
+
+// SetSIWE enables the Sign-In with Ethereum sessions for the given domain,
+// the faucet requests with a session token can only claim for the signed in
+// address. A 0 ttl means DefaultSessionTTL. It must be called before Init.
+func (a *API) SetSIWE(domain string, ttl time.Duration) error {
+	if domain == "" {
+		a.sessions = nil
+		return nil
+	}
+	if ttl == 0 {
+		ttl = DefaultSessionTTL
+	}
+	nonces, err := newSignedNonces("sign-in-nonces")
+	if err != nil {
+		return err
+	}
+	a.sessions = &sessionStore{
+		domain:  domain,
+		ttl:     ttl,
+		nonces:  nonces,
+		storage: storage.NewMemory(),
+	}
+	return nil
+}
+
+func (a *API) enableAuthHandlers() error {
+	if a.sessions == nil {
+		return nil
+	}
+	if err := a.registerMethod(
+		"/auth/nonce",
+		"GET",
+		bearerstdapi.MethodAccessTypePublic,
+		a.authNonceHandler,
+	); err != nil {
+		return err
+	}
+	return a.registerMethod(
+		"/auth/verify",
+		"POST",
+		bearerstdapi.MethodAccessTypePublic,
+		a.authVerifyHandler,
+	)
+}
+
+// returns a nonce for a sign in message
+func (a *API) authNonceHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	nonce, expiresAt, err := a.sessions.nonces.issue("")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&AuthNonceResponse{Nonce: nonce, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
+
+// verifies a sign in message and returns a session for its address
+func (a *API) authVerifyHandler(msg *bearerstdapi.BearerStandardAPIdata,
+	ctx *httprouter.HTTPContext,
+) error {
+	req := &AuthVerifyRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return ErrInvalidRequest.Withf("cannot decode sign in request: %s", err)
+	}
+	siwe, err := parseSIWEMessage(req.Message)
+	if err != nil {
+		return ErrInvalidRequest.Withf("invalid sign in message: %s", err)
+	}
+	now := time.Now()
+	switch {
+	case siwe.Domain != a.sessions.domain:
+		return ErrInvalidRequest.Withf("invalid sign in domain %s", siwe.Domain)
+	case siwe.ExpirationTime != nil && now.After(*siwe.ExpirationTime):
+		return ErrInvalidRequest.Withf("sign in message expired")
+	case siwe.NotBefore != nil && now.Before(*siwe.NotBefore):
+		return ErrInvalidRequest.Withf("sign in message not yet valid")
+	}
+	if _, ok := a.sessions.nonces.check(siwe.Nonce, ""); !ok {
+		return ErrInvalidNonce
+	}
+	signer, err := ethereum.AddrFromSignature([]byte(req.Message), req.Signature)
+	if err != nil {
+		return ErrInvalidSignature.WithErr(err)
+	}
+	if signer != siwe.Address {
+		return ErrInvalidSignature
+	}
+	used, err := a.sessions.nonces.use(siwe.Nonce, "")
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidNonce
+	}
+	token, expiresAt, err := a.sessions.create(signer)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&AuthSessionResponse{Token: token, Address: signer, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, bearerstdapi.HTTPstatusCodeOK)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+// Tier represents a class of recipients with their own grant settings, such
+// as the partners, attached to bearer tokens or verified identities
+type Tier struct {
+	// Tokens are the bearer tokens of the tier, accepted for claiming
+	Tokens []string `json:"tokens,omitempty"`
+	// Identities are the keys (provider:id) of the verified identities of the tier
+	Identities []string `json:"identities,omitempty"`
+	// Networks are the grant settings per network, the other networks use
+	// the faucet settings
+	Networks map[string]*TierNetwork `json:"networks"`
+}
+
+// TierNetwork represents the grant settings of a tier on a network, the zero
+// values use the faucet network settings
+type TierNetwork struct {
+	// Amount is the amount granted
+	Amount uint64 `json:"amount,omitempty"`
+	// Threshold is the balance from which an address cannot get tokens
+	Threshold uint64 `json:"threshold,omitempty"`
+	// Cooldown is the minimum time between grants for the same address (i.e 1h)
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// tierGrant represents the tier of a claim and its grant settings
+type tierGrant struct {
+	name  string
+	grant *faucet.GrantSettings
+}
+
+// tierIndex resolves the tier of the claims
+type tierIndex struct {
+	// tokens and identities tier name per bearer token and identity key
+	tokens     map[string]string
+	identities map[string]string
+	// grants grant settings per tier and network
+	grants map[string]map[string]*faucet.GrantSettings
+}
+
+// LoadTiers reads the tiers of the given JSON file, an object with the tiers
+// by name
+func LoadTiers(path string) (map[string]*Tier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tiers := make(map[string]*Tier)
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", path, err)
+	}
+	return tiers, nil
+}
+
+// newTierIndex validates the given tiers and returns their index
+func newTierIndex(tiers map[string]*Tier) (*tierIndex, error) {
+	ti := &tierIndex{
+		tokens:     make(map[string]string),
+		identities: make(map[string]string),
+		grants:     make(map[string]map[string]*faucet.GrantSettings),
+	}
+	for name, tier := range tiers {
+		for _, token := range tier.Tokens {
+			// the bearer tokens are UUIDs, indexed in their canonical form
+			parsed, err := uuid.Parse(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid token of tier %s: %w", name, err)
+			}
+			token = parsed.String()
+			if other, ok := ti.tokens[token]; ok {
+				return nil, fmt.Errorf("token of tier %s already in tier %s", name, other)
+			}
+			ti.tokens[token] = name
+		}
+		for _, key := range tier.Identities {
+			if other, ok := ti.identities[key]; ok {
+				return nil, fmt.Errorf("identity %s of tier %s already in tier %s", key, name, other)
+			}
+			ti.identities[key] = name
+		}
+		ti.grants[name] = make(map[string]*faucet.GrantSettings)
+		for network, settings := range tier.Networks {
+			grant := &faucet.GrantSettings{Amount: settings.Amount, Threshold: settings.Threshold}
+			if settings.Cooldown != "" {
+				cooldown, err := time.ParseDuration(settings.Cooldown)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cooldown of tier %s on %s: %w", name, network, err)
+				}
+				grant.Cooldown = cooldown
+			}
+			ti.grants[name][network] = grant
+		}
+	}
+	return ti, nil
+}
+
+// SetTiers grants the claims of the given tiers with their settings, the
+// tier tokens are added to the whitelist. It must be called before Init.
+func (a *API) SetTiers(tiers map[string]*Tier) error {
+	if len(tiers) == 0 {
+		a.tiers = nil
+		return nil
+	}
+	ti, err := newTierIndex(tiers)
+	if err != nil {
+		return err
+	}
+	a.tiers = ti
+	return nil
+}
+
+// tier returns the tier of a claim on the network by its bearer token, or
+// else by its verified identity, nil if the claim has no tier
+func (a *API) tier(token, identityToken, network string) *tierGrant {
+	if a.tiers == nil {
+		return nil
+	}
+	if parsed, err := uuid.Parse(token); err == nil {
+		token = parsed.String()
+	}
+	name, ok := a.tiers.tokens[token]
+	if !ok && a.identities != nil {
+		if id := a.identities.identity(identityToken); id != nil {
+			name, ok = a.tiers.identities[id.Key()]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	grant, ok := a.tiers.grants[name][network]
+	if !ok {
+		grant = &faucet.GrantSettings{}
+	}
+	return &tierGrant{name: name, grant: grant}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// SetStorage keeps the request quotas of the bearer tokens, the used nonces
+// and the sign in sessions in the given storage, so they survive the
+// restarts and are shared by the replicas using the same storage. It must be
+// called before Init.
+func (a *API) SetStorage(s storage.Storage) {
+	a.storage = s
+}
+
+// shareState keeps the used nonces and the sign in sessions of the enabled
+// features in the API storage
+func (a *API) shareState() error {
+	nonces := []*signedNonces{}
+	if a.claimNonces != nil {
+		nonces = append(nonces, a.claimNonces)
+	}
+	if a.sessions != nil {
+		nonces = append(nonces, a.sessions.nonces)
+		a.sessions.storage = a.storage
+	}
+	if a.identities != nil {
+		nonces = append(nonces, a.identities.states)
+	}
+	for _, sn := range nonces {
+		if err := sn.setStorage(a.storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addToken adds a bearer token of the given tier, if any, with MaxRequest
+// requests. A token already stored keeps its remaining requests
+func (a *API) addToken(token, tier string) error {
+	a.api.AddAuthToken(token, int64(MaxRequest))
+	stored, err := a.storage.Token(token)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		stored = &storage.Token{Token: token, Remaining: MaxRequest, CreatedAt: time.Now()}
+	case err != nil:
+		return fmt.Errorf("cannot get bearer token: %w", err)
+	case stored.Tier == tier:
+		return nil
+	}
+	stored.Tier = tier
+	if err := a.storage.SetToken(stored); err != nil {
+		return fmt.Errorf("cannot store bearer token: %w", err)
+	}
+	return nil
+}
+
+// useToken consumes a request of the given bearer token
+func (a *API) useToken(token string) error {
+	_, err := a.storage.UseToken(token)
+	switch {
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return ErrInvalidToken.Withf("no requests left")
+	case errors.Is(err, storage.ErrNotFound):
+		return ErrInvalidToken
+	}
+	return err
+}
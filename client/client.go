@@ -0,0 +1,362 @@
+// Package client implements a client of the faucet API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/proto/build/go/models"
+	"go.vocdoni.io/vocdoni-faucet/api"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultRetries number of retries of a failed request when not configured
+	DefaultRetries = 3
+	// DefaultBackoff wait before the first retry when not configured, it is
+	// doubled on each retry
+	DefaultBackoff = time.Second
+	// DefaultMaxWait maximum wait before a retry, the requests whose server
+	// retry hint is longer are not retried
+	DefaultMaxWait = time.Minute
+	// DefaultTimeout timeout of a single HTTP request
+	DefaultTimeout = time.Second * 30
+)
+
+var (
+	// ErrInvalidPackage error returned if a Vocdoni faucet package cannot be decoded
+	ErrInvalidPackage = errors.New("invalid faucet package")
+	// ErrUnknownSigner error returned if a Vocdoni faucet package is not signed by the faucet
+	ErrUnknownSigner = errors.New("faucet package not signed by the faucet")
+)
+
+// Client is a client of the faucet API
+type Client struct {
+	// addr is the faucet API base URL
+	addr *url.URL
+	// token is the bearer token of the private methods
+	token string
+	// retries number of retries of a failed request
+	retries int
+	// backoff wait before the first retry
+	backoff time.Duration
+	// maxWait maximum wait before a retry
+	maxWait time.Duration
+	http    *http.Client
+}
+
+// New returns a client of the faucet API available at the given base URL
+// (i.e https://foo.bar/faucet) using the given bearer token
+func New(addr, token string) (*Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid faucet address: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid faucet address: %s", addr)
+	}
+	return &Client{
+		addr:    u,
+		token:   token,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+		maxWait: DefaultMaxWait,
+		http:    &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// SetRetries sets the number of retries of a failed request, the wait
+// before the first retry and the maximum wait before a retry
+func (c *Client) SetRetries(retries int, backoff, maxWait time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
+	c.maxWait = maxWait
+}
+
+// EVMClaim represents the funds sent by the EVM faucet
+type EVMClaim struct {
+	// TxHash is the hash of the transfer tx
+	TxHash common.Hash
+	// Amount is the amount transferred in wei
+	Amount *big.Int
+}
+
+// VocdoniClaim represents a package issued by the Vocdoni faucet
+type VocdoniClaim struct {
+	// Package is the faucet package to include in the Vocdoni tx
+	Package *models.FaucetPackage
+	// Payload is the decoded payload of the package
+	Payload *models.FaucetPayload
+	// Signer is the faucet address that signed the package
+	Signer common.Address
+}
+
+// Info returns the public information of the faucet
+func (c *Client) Info(ctx context.Context) (*api.InfoResponse, error) {
+	info := &api.InfoResponse{}
+	if err := c.request(ctx, http.MethodGet, nil, info, "info"); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Status returns the current status of the faucet
+func (c *Client) Status(ctx context.Context) (*api.StatusResponse, error) {
+	status := &api.StatusResponse{}
+	if err := c.request(ctx, http.MethodGet, nil, status, "status"); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Package returns the status of an issued Vocdoni faucet package
+func (c *Client) Package(ctx context.Context, identifier uint64) (*api.PackageStatusResponse, error) {
+	pkg := &api.PackageStatusResponse{}
+	if err := c.request(ctx, http.MethodGet, nil, pkg,
+		"vocdoni", "package", strconv.FormatUint(identifier, 10)); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Claim claims faucet funds with the given request
+func (c *Client) Claim(ctx context.Context, req *api.FaucetRequestData) (*api.FaucetResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &api.FaucetResponse{}
+	if err := c.request(ctx, http.MethodPost, body, resp, "claim"); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ClaimSigned sends the given claim request signed by the from address with a
+// faucet nonce, for the faucets with signed claims enabled. The request is not
+// retried, as its nonce is used by the faucet
+func (c *Client) ClaimSigned(ctx context.Context,
+	req *api.FaucetRequestData,
+	signer *ethereum.SignKeys,
+) (*api.FaucetResponse, error) {
+	from := signer.Address()
+	nonce := &api.ClaimNonceResponse{}
+	if err := c.request(ctx, http.MethodGet, nil, nonce, "claim", "nonce", from.Hex()); err != nil {
+		return nil, err
+	}
+	signature, err := signer.SignEthereum([]byte(api.ClaimMessage(from, nonce.Nonce)))
+	if err != nil {
+		return nil, err
+	}
+	signed := *req
+	signed.From = from.Bytes()
+	signed.Nonce = nonce.Nonce
+	signed.Signature = signature
+	body, err := json.Marshal(&signed)
+	if err != nil {
+		return nil, err
+	}
+	resp := &api.FaucetResponse{}
+	if _, err := c.do(ctx, http.MethodPost, body, resp, "claim"); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ClaimEVM claims EVM funds for the given address on the given network
+func (c *Client) ClaimEVM(ctx context.Context, network string, to common.Address) (*EVMClaim, error) {
+	resp, err := c.Claim(ctx, &api.FaucetRequestData{
+		Faucet:  api.EVM,
+		Network: network,
+		From:    to.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := new(big.Int).SetString(resp.Amount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", resp.Amount)
+	}
+	return &EVMClaim{TxHash: common.BytesToHash(resp.TxHash), Amount: amount}, nil
+}
+
+// ClaimVocdoni claims a Vocdoni faucet package for the given address on the
+// given network, the package is verified against the faucet addresses
+func (c *Client) ClaimVocdoni(ctx context.Context, network string, to common.Address) (*VocdoniClaim, error) {
+	resp, err := c.Claim(ctx, &api.FaucetRequestData{
+		Faucet:  api.Vocdoni,
+		Network: network,
+		From:    to.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := DecodePackage(resp.FaucetPackage)
+	if err != nil {
+		return nil, err
+	}
+	return c.VerifyPackage(ctx, network, pkg)
+}
+
+// VerifyPackage verifies that the given package is signed by one of the
+// faucet addresses of the given Vocdoni network
+func (c *Client) VerifyPackage(ctx context.Context,
+	network string,
+	pkg *models.FaucetPackage,
+) (*VocdoniClaim, error) {
+	info, err := c.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the faucet addresses: %w", err)
+	}
+	for _, networkInfo := range info.Networks {
+		if networkInfo.Faucet == api.Vocdoni && networkInfo.Network == network {
+			return VerifyPackage(pkg, networkInfo.Addresses)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", api.ErrUnsupportedNetwork, network)
+}
+
+// DecodePackage decodes the faucet package included in a faucet response
+func DecodePackage(data []byte) (*models.FaucetPackage, error) {
+	fpackage := &api.FaucetPackage{}
+	if err := json.Unmarshal(data, fpackage); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPackage, err)
+	}
+	return &models.FaucetPackage{
+		Payload:   fpackage.FaucetPayload,
+		Signature: fpackage.Signature,
+	}, nil
+}
+
+// VerifyPackage decodes the payload of the given package and verifies that
+// it is signed by one of the given faucet addresses
+func VerifyPackage(pkg *models.FaucetPackage, addresses []common.Address) (*VocdoniClaim, error) {
+	payload := &models.FaucetPayload{}
+	if err := proto.Unmarshal(pkg.Payload, payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPackage, err)
+	}
+	signer, err := ethereum.AddrFromSignature(pkg.Payload, pkg.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPackage, err)
+	}
+	for _, address := range addresses {
+		if address == signer {
+			return &VocdoniClaim{Package: pkg, Payload: payload, Signer: signer}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSigner, signer.Hex())
+}
+
+// request executes a request on the given path, retrying it on server
+// errors, and decodes the response on the given value. The failed requests
+// return an *api.APIError
+func (c *Client) request(ctx context.Context,
+	method string,
+	body []byte,
+	value interface{},
+	urlPath ...string,
+) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.do(ctx, method, body, value, urlPath...)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= c.retries {
+			return err
+		}
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > c.maxWait {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// do executes a single request, if it fails it returns the time to wait
+// before retrying it or -1 if it must not be retried
+func (c *Client) do(ctx context.Context,
+	method string,
+	body []byte,
+	value interface{},
+	urlPath ...string,
+) (time.Duration, error) {
+	u := *c.addr
+	u.Path = path.Join(append([]string{u.Path}, urlPath...)...)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// claims are not retried if the request may have been processed
+		if method != http.MethodGet {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(data, value); err != nil {
+			return -1, fmt.Errorf("cannot decode response: %w", err)
+		}
+		return 0, nil
+	}
+	errResp := &api.ErrorResponse{}
+	if err := json.Unmarshal(data, errResp); err != nil || errResp.Code == "" {
+		// not an API error, i.e a proxy or router error
+		errResp.Error = fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	apiErr := &api.APIError{
+		Code:       errResp.Code,
+		HTTPstatus: resp.StatusCode,
+		Message:    errResp.Error,
+		RetryAfter: time.Duration(errResp.RetryAfter) * time.Second,
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// claims are only retried if the faucet refused them, not a proxy
+		if method != http.MethodGet && apiErr.Code == "" {
+			return -1, apiErr
+		}
+		return apiErr.RetryAfter, apiErr
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		// the gateway may have forwarded the claim, only the reads are retried
+		if method != http.MethodGet {
+			return -1, apiErr
+		}
+		return apiErr.RetryAfter, apiErr
+	default:
+		return -1, apiErr
+	}
+}
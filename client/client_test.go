@@ -0,0 +1,207 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/vocdoni-faucet/api"
+	"go.vocdoni.io/vocdoni-faucet/client"
+	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+var (
+	eConfig = &config.FaucetConfig{
+		EVMAmount:    100,
+		EVMNetwork:   "evmtest",
+		EVMPrivKeys:  []string{"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		EVMEndpoints: []string{"localhost:8545"},
+		EVMTimeout:   10,
+		EVMSendConditions: config.SendConditionsConfig{
+			Balance: 100,
+		},
+	}
+	vConfig = &config.FaucetConfig{
+		VocdoniAmount:   100,
+		VocdoniNetworks: []string{"dev"},
+		VocdoniPrivKey:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		VocdoniSendConditions: config.SendConditionsConfig{
+			Balance: 100,
+		},
+	}
+)
+
+func TestClient(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	v.TrackPackages(storage.NewMemory())
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), eConfig), qt.IsNil)
+
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	token, err := uuid.NewUUID()
+	qt.Assert(t, err, qt.IsNil)
+	faucetAPI := api.NewAPI()
+	qt.Assert(t, faucetAPI.Init(&router, "/faucet", token.String(), "", true, true, v, e), qt.IsNil)
+	c, err := client.New(addr.String(), token.String())
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	to := &ethereum.SignKeys{}
+	qt.Assert(t, to.Generate(), qt.IsNil)
+
+	// should get the faucet info and status
+	info, err := c.Info(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, info.Faucets, qt.DeepEquals, []string{api.EVM, api.Vocdoni})
+	status, err := c.Status(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, status.EVM.Network, qt.Equals, "evmtest")
+
+	// should claim and verify a vocdoni package
+	claim, err := c.ClaimVocdoni(ctx, "dev", to.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, claim.Signer, qt.Equals, v.Signer().Address())
+	qt.Assert(t, claim.Payload.Amount, qt.Equals, uint64(100))
+	qt.Assert(t, evmcommon.BytesToAddress(claim.Payload.To), qt.Equals, to.Address())
+	pkg, err := c.Package(ctx, claim.Payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pkg.Status, qt.Equals, faucet.PackageStatusIssued)
+	_, err = client.VerifyPackage(claim.Package, []evmcommon.Address{to.Address()})
+	qt.Assert(t, err, qt.ErrorIs, client.ErrUnknownSigner)
+
+	// should return typed errors
+	_, err = c.ClaimVocdoni(ctx, "dev", to.Address())
+	qt.Assert(t, err, qt.ErrorIs, api.ErrPendingPackage)
+	apiErr := &api.APIError{}
+	qt.Assert(t, errors.As(err, &apiErr), qt.IsTrue)
+	qt.Assert(t, apiErr.HTTPstatus, qt.Equals, http.StatusConflict)
+	_, err = c.ClaimVocdoni(ctx, "invalid", to.Address())
+	qt.Assert(t, err, qt.ErrorIs, api.ErrUnsupportedNetwork)
+	c2, err := client.New(addr.String(), "invalid")
+	qt.Assert(t, err, qt.IsNil)
+	_, err = c2.ClaimVocdoni(ctx, "dev", to.Address())
+	qt.Assert(t, err, qt.IsNotNil)
+
+	// should claim evm funds
+	evmClaim, err := c.ClaimEVM(ctx, "evmtest", to.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, evmClaim.Amount.Int64(), qt.Equals, int64(100))
+	e.TestBackend().Commit()
+	receipt, err := e.TestBackend().Backend.TransactionReceipt(ctx, evmClaim.TxHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, receipt.Status, qt.Equals, uint64(1))
+}
+
+func TestClientSignedClaims(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	v.TrackPackages(storage.NewMemory())
+	router := httprouter.HTTProuter{}
+	qt.Assert(t, router.Init("127.0.0.1", 0), qt.IsNil)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "/faucet"))
+	qt.Assert(t, err, qt.IsNil)
+	faucetAPI := api.NewAPI()
+	qt.Assert(t, faucetAPI.SetSignedClaims(true, true), qt.IsNil)
+	qt.Assert(t, faucetAPI.Init(&router, "/faucet", "", "", false, true, v, faucet.NewEVM()), qt.IsNil)
+	ctx := context.Background()
+
+	// should claim signed by the recipient without a token
+	c, err := client.New(addr.String(), "")
+	qt.Assert(t, err, qt.IsNil)
+	signer := &ethereum.SignKeys{}
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	resp, err := c.ClaimSigned(ctx, &api.FaucetRequestData{Faucet: api.Vocdoni, Network: "dev"}, signer)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Amount, qt.Equals, "100")
+
+	// should refuse the unsigned claims
+	_, err = c.ClaimVocdoni(ctx, "dev", signer.Address())
+	qt.Assert(t, err, qt.ErrorIs, api.ErrSignatureRequired)
+}
+
+func TestClientRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"gas price above the configured cap","code":"GAS_PRICE_TOO_HIGH"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"evm":{"network":"evmtest"}}`))
+	}))
+	defer server.Close()
+	c, err := client.New(server.URL, "")
+	qt.Assert(t, err, qt.IsNil)
+
+	// should retry the server errors
+	c.SetRetries(3, time.Millisecond, time.Second)
+	status, err := c.Status(context.Background())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, status.EVM.Network, qt.Equals, "evmtest")
+	qt.Assert(t, requests, qt.Equals, 3)
+
+	// should fail once the retries are exhausted
+	requests = 0
+	c.SetRetries(1, time.Millisecond, time.Second)
+	_, err = c.Status(context.Background())
+	qt.Assert(t, err, qt.ErrorIs, api.ErrGasPriceTooHigh)
+	qt.Assert(t, requests, qt.Equals, 2)
+}
+
+func TestClientClaimRetries(t *testing.T) {
+	requests := 0
+	status, body := 0, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	c, err := client.New(server.URL, "")
+	qt.Assert(t, err, qt.IsNil)
+	c.SetRetries(3, time.Millisecond, time.Second)
+	claim := func() error {
+		requests = 0
+		_, err := c.Claim(context.Background(), &api.FaucetRequestData{Faucet: "evm", Network: "evmtest"})
+		return err
+	}
+
+	// should retry the claims refused by the faucet
+	status, body = http.StatusServiceUnavailable,
+		`{"error":"gas price above the configured cap","code":"GAS_PRICE_TOO_HIGH"}`
+	qt.Assert(t, claim(), qt.ErrorIs, api.ErrGasPriceTooHigh)
+	qt.Assert(t, requests, qt.Equals, 4)
+
+	// should not retry the claims failed without an API error
+	for _, status = range []int{
+		http.StatusServiceUnavailable,
+		http.StatusBadGateway,
+		http.StatusGatewayTimeout,
+	} {
+		body = "upstream unavailable"
+		qt.Assert(t, claim(), qt.IsNotNil)
+		qt.Assert(t, requests, qt.Equals, 1)
+	}
+
+	// should not retry the claims failed on a gateway error
+	status, body = http.StatusBadGateway,
+		`{"error":"gas price above the configured cap","code":"GAS_PRICE_TOO_HIGH"}`
+	qt.Assert(t, claim(), qt.ErrorIs, api.ErrGasPriceTooHigh)
+	qt.Assert(t, requests, qt.Equals, 1)
+}
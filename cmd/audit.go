@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+// auditExportCommand verifies the hash chain of the faucet audit log and
+// exports its entries as JSON lines or CSV for the compliance reviews
+func auditExportCommand(args []string) error {
+	from := pflag.String("from", "", "export the entries recorded from this time (RFC3339)")
+	to := pflag.String("to", "", "export the entries recorded until this time (RFC3339)")
+	format := pflag.String("format", "json", "export format (json or csv)")
+	output := pflag.String("output", "", "file where the entries are written (default stdout)")
+	cfg := config.NewConfig()
+	if err := cfg.InitConfigArgs(args); err != nil {
+		return err
+	}
+	var times [2]time.Time
+	for i, value := range []string{*from, *to} {
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid time %q: %w", value, err)
+		}
+		times[i] = t
+	}
+	entries, err := readAuditEntries(cfg, times[0], times[1])
+	if err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		out, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeAuditCSV(w, entries)
+	}
+	return fmt.Errorf("unsupported format %q", *format)
+}
+
+// readAuditEntries reads the audit log entries recorded within the given
+// times, from the shared storage if configured or else from the data directory
+func readAuditEntries(cfg *config.Config, from, to time.Time) ([]*faucet.AuditEntry, error) {
+	if cfg.StoragePostgres != "" {
+		store, err := openStorage(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open storage: %w", err)
+		}
+		defer store.Close()
+		audit, err := faucet.NewStorageAuditLog(store)
+		if err != nil {
+			return nil, err
+		}
+		return audit.Entries(from, to)
+	}
+	f, err := os.Open(filepath.Join(cfg.DataDir, faucet.AuditLogFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer f.Close()
+	return faucet.ReadAuditLog(f, from, to)
+}
+
+// writeAuditCSV writes the audit log entries as CSV, with the details as
+// key=value pairs separated by semicolons
+func writeAuditCSV(w io.Writer, entries []*faucet.AuditEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{
+		"seq", "time", "kind", "action", "faucet", "network", "address", "token", "identity", "tier",
+		"decision", "error", "amount", "txHash", "packageIdentifier", "details", "prevHash", "hash",
+	}); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		address := ""
+		if entry.Address != nil {
+			address = entry.Address.Hex()
+		}
+		details := []string{}
+		for key, value := range entry.Details {
+			details = append(details, key+"="+value)
+		}
+		sort.Strings(details)
+		if err := cw.Write([]string{
+			fmt.Sprint(entry.Seq),
+			entry.Time.Format(time.RFC3339Nano),
+			string(entry.Kind),
+			entry.Action,
+			entry.Faucet,
+			entry.Network,
+			address,
+			entry.Token,
+			entry.Identity,
+			entry.Tier,
+			string(entry.Decision),
+			entry.Error,
+			entry.Amount,
+			entry.TxHash.String(),
+			entry.PackageIdentifier,
+			strings.Join(details, ";"),
+			entry.PrevHash.String(),
+			entry.Hash.String(),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
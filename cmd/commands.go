@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	evmClient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/spf13/pflag"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/api"
+	"go.vocdoni.io/vocdoni-faucet/client"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+)
+
+const commandsUsage = `usage: vocdoni-faucet [flags]             run the faucet server
+       vocdoni-faucet claim [flags]       claim faucet funds
+       vocdoni-faucet package verify [flags] <package>
+                                          verify a vocdoni faucet package
+       vocdoni-faucet packages generate [flags]
+                                          generate vocdoni faucet packages from a CSV
+       vocdoni-faucet signers balances [flags]
+                                          show the balance of the faucet signers
+       vocdoni-faucet keys generate [flags]
+                                          generate new signer keys
+       vocdoni-faucet audit export [flags]
+                                          verify and export the audit log
+
+run a command with --help for its flags`
+
+// commands are the command-line client commands, the first argument
+// not matching a command runs the server
+var commands = map[string]func(args []string) error{
+	"claim":             claimCommand,
+	"package verify":    packageVerifyCommand,
+	"packages generate": packagesGenerateCommand,
+	"signers balances":  signersBalancesCommand,
+	"keys generate":     keysGenerateCommand,
+	"audit export":      auditExportCommand,
+}
+
+// runCommand runs the command given in the arguments, returns false if
+// the arguments do not start with a command
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if args[0] == "help" {
+		fmt.Println(commandsUsage)
+		return true, nil
+	}
+	for name, command := range commands {
+		words := strings.Fields(name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != name {
+			continue
+		}
+		// the commands only report errors
+		log.Init("error", "stderr")
+		return true, command(args[len(words):])
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		return true, fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), commandsUsage)
+	}
+	return false, nil
+}
+
+// printJSON prints the given value as indented JSON
+func printJSON(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// clientFlags adds the flags for connecting with a faucet API
+func clientFlags(flags *pflag.FlagSet) (faucetURL, token *string, timeout *time.Duration) {
+	faucetURL = flags.String("url", "http://127.0.0.1:8000/faucet", "faucet API base URL")
+	token = flags.String("token", os.Getenv("VOCDONIFAUCET_TOKEN"),
+		"faucet API bearer token (default $VOCDONIFAUCET_TOKEN)")
+	timeout = flags.Duration("timeout", time.Minute, "command timeout")
+	return
+}
+
+func claimCommand(args []string) error {
+	flags := pflag.NewFlagSet("claim", pflag.ContinueOnError)
+	faucetURL, token, timeout := clientFlags(flags)
+	network := flags.String("network", "", "network to claim funds on (i.e sepolia or dev)")
+	to := flags.String("to", "", "recipient address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !common.IsHexAddress(*to) {
+		return fmt.Errorf("invalid recipient address %q", *to)
+	}
+	c, err := client.New(*faucetURL, *token)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if _, ok := faucet.EVMSupportedFaucetNetworksMap[*network]; ok {
+		claim, err := c.ClaimEVM(ctx, *network, common.HexToAddress(*to))
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]string{
+			"txHash": claim.TxHash.Hex(),
+			"amount": claim.Amount.String(),
+		})
+	}
+	if _, ok := faucet.VocdoniSupportedFaucetNetworksMap[*network]; ok {
+		resp, err := c.Claim(ctx, &api.FaucetRequestData{
+			Faucet:  api.Vocdoni,
+			Network: *network,
+			From:    common.HexToAddress(*to).Bytes(),
+		})
+		if err != nil {
+			return err
+		}
+		pkg, err := client.DecodePackage(resp.FaucetPackage)
+		if err != nil {
+			return err
+		}
+		claim, err := c.VerifyPackage(ctx, *network, pkg)
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]string{
+			"identifier":    resp.Identifier,
+			"amount":        resp.Amount,
+			"signer":        claim.Signer.Hex(),
+			"faucetPackage": base64.StdEncoding.EncodeToString(resp.FaucetPackage),
+		})
+	}
+	return fmt.Errorf("unsupported network %q", *network)
+}
+
+func packageVerifyCommand(args []string) error {
+	flags := pflag.NewFlagSet("package verify", pflag.ContinueOnError)
+	faucetURL, token, timeout := clientFlags(flags)
+	network := flags.String("network", "", "vocdoni network of the package, its faucet addresses are fetched")
+	addresses := flags.StringSlice("addresses", []string{},
+		"faucet addresses allowed to sign the package, instead of fetching them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the package, as in the faucetPackage field of the faucet response")
+	}
+	// the package can be given as in the JSON response (base64) or decoded
+	data := []byte(flags.Arg(0))
+	if decoded, err := base64.StdEncoding.DecodeString(flags.Arg(0)); err == nil {
+		data = decoded
+	}
+	pkg, err := client.DecodePackage(data)
+	if err != nil {
+		return err
+	}
+	var claim *client.VocdoniClaim
+	if len(*addresses) > 0 {
+		signers := []common.Address{}
+		for _, address := range *addresses {
+			if !common.IsHexAddress(address) {
+				return fmt.Errorf("invalid address %q", address)
+			}
+			signers = append(signers, common.HexToAddress(address))
+		}
+		claim, err = client.VerifyPackage(pkg, signers)
+	} else {
+		var c *client.Client
+		if c, err = client.New(*faucetURL, *token); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		claim, err = c.VerifyPackage(ctx, *network, pkg)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]string{
+		"identifier": fmt.Sprint(claim.Payload.Identifier),
+		"to":         common.BytesToAddress(claim.Payload.To).Hex(),
+		"amount":     fmt.Sprint(claim.Payload.Amount),
+		"signer":     claim.Signer.Hex(),
+	})
+}
+
+// signerBalance represents the balance of a faucet signer
+type signerBalance struct {
+	Faucet  string         `json:"faucet"`
+	Network string         `json:"network"`
+	Address common.Address `json:"address"`
+	Balance string         `json:"balance,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func signersBalancesCommand(args []string) error {
+	flags := pflag.NewFlagSet("signers balances", pflag.ContinueOnError)
+	faucetURL, token, timeout := clientFlags(flags)
+	evmEndpoint := flags.String("evmEndpoint", "", "evm endpoint for getting the evm signers balance")
+	vocdoniEndpoints := flags.StringToString("vocdoniEndpoints", map[string]string{},
+		"vocdoni API endpoints per network for getting the vocdoni signers balance")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	c, err := client.New(*faucetURL, *token)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	info, err := c.Info(ctx)
+	if err != nil {
+		return err
+	}
+	var evm *evmClient.Client
+	if *evmEndpoint != "" {
+		if evm, err = evmClient.DialContext(ctx, *evmEndpoint); err != nil {
+			return fmt.Errorf("cannot connect to %s: %w", *evmEndpoint, err)
+		}
+		defer evm.Close()
+	}
+	balances := []*signerBalance{}
+	for _, network := range info.Networks {
+		for _, address := range network.Addresses {
+			balance := &signerBalance{Faucet: network.Faucet, Network: network.Network, Address: address}
+			balances = append(balances, balance)
+			switch {
+			case network.Faucet == api.EVM && evm != nil:
+				amount, err := evm.BalanceAt(ctx, address, nil) // nil means latest block
+				if err != nil {
+					balance.Error = err.Error()
+					continue
+				}
+				balance.Balance = amount.String()
+			case network.Faucet == api.Vocdoni && (*vocdoniEndpoints)[network.Network] != "":
+				reader := faucet.NewVocdoniAPIAccountReader((*vocdoniEndpoints)[network.Network])
+				amount, err := reader.Balance(ctx, address)
+				if err != nil {
+					balance.Error = err.Error()
+					continue
+				}
+				balance.Balance = fmt.Sprint(amount)
+			default:
+				balance.Error = "no endpoint for the network"
+			}
+		}
+	}
+	return printJSON(balances)
+}
+
+func keysGenerateCommand(args []string) error {
+	flags := pflag.NewFlagSet("keys generate", pflag.ContinueOnError)
+	count := flags.Int("count", 1, "number of keys to generate")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	keys := []map[string]string{}
+	for i := 0; i < *count; i++ {
+		signer := ethereum.NewSignKeys()
+		if err := signer.Generate(); err != nil {
+			return err
+		}
+		_, privKey := signer.HexString()
+		keys = append(keys, map[string]string{
+			"address": signer.Address().Hex(),
+			"privKey": privKey,
+		})
+	}
+	return printJSON(keys)
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/metrics"
 	"go.vocdoni.io/vocdoni-faucet/api"
 	"go.vocdoni.io/vocdoni-faucet/config"
 	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/identity"
 	"go.vocdoni.io/vocdoni-faucet/internal"
+	"go.vocdoni.io/vocdoni-faucet/storage"
 )
 
 func main() {
@@ -24,6 +31,15 @@ func main() {
 	// in a log line later on.
 	fmt.Fprintf(os.Stderr, "vocdoni-faucet version %q\n", internal.Version)
 
+	// run the command-line client commands, if any
+	if ok, err := runCommand(os.Args[1:]); ok {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// setup config
 	cfg := config.NewConfig()
 	if err := cfg.InitConfig(); err != nil {
@@ -47,12 +63,28 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// init the storage of the faucet state, shared by the replicas if postgres
+	store, err := openStorage(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// init vocdoni faucet
 	v := faucet.NewVocdoni()
 	if cfg.Faucet.EnableVocdoni {
 		if err := v.Init(context.Background(), cfg.Faucet); err != nil {
 			log.Fatal(err)
 		}
+		if err := v.SetStorage(store); err != nil {
+			log.Fatal(err)
+		}
+		v.TrackPackages(store)
+		if err := importPackages(v, filepath.Join(cfg.DataDir, "packages")); err != nil {
+			log.Fatal(err)
+		}
+		if len(cfg.Faucet.VocdoniEndpoints) > 0 {
+			go v.WatchRedemptions(context.Background(), cfg.Faucet.VocdoniWatchInterval)
+		}
 	}
 
 	// init evm faucet
@@ -61,14 +93,89 @@ func main() {
 		if err := e.Init(context.Background(), cfg.Faucet); err != nil {
 			log.Fatal(err)
 		}
+		if err := e.SetStorage(context.Background(), store); err != nil {
+			log.Fatal(err)
+		}
+		// the replicas sharing the postgres storage coordinate the use of the signers
+		if cfg.StoragePostgres != "" {
+			if err := e.EnableSignerLeases(context.Background(), cfg.Faucet.EVMSignerLease); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// the sends deferred by the gas price are done once it goes down
+		if cfg.Faucet.EVMGasPolicy.DeferOnHighGas {
+			go e.ServeDeferredSends(context.Background())
+		}
+	}
+
+	// init the address lists, the entries managed with the admin API are kept
+	// in the storage
+	lists := faucet.NewAddressLists(cfg.Faucet.RestrictedNetworks)
+	lists.SetStorage(store)
+	if path := cfg.Faucet.AllowlistFile; path != "" {
+		if err := lists.LoadFile(faucet.ListAllow, path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if path := cfg.Faucet.DenylistFile; path != "" {
+		if err := lists.LoadFile(faucet.ListDeny, path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := importLists(lists, filepath.Join(cfg.DataDir, "lists")); err != nil {
+		log.Fatal(err)
+	}
+
+	// init the audit log of the claims and the admin changes, shared by the
+	// replicas if the storage is
+	var audit *faucet.AuditLog
+	if cfg.StoragePostgres != "" {
+		audit, err = faucet.NewStorageAuditLog(store)
+	} else {
+		audit, err = faucet.OpenAuditLog(filepath.Join(cfg.DataDir, faucet.AuditLogFile))
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// init api
 	a := api.NewAPI()
+	a.SetStorage(store)
+	a.SetAuditLog(audit)
+	a.SetAddressLists(lists)
+	if err := a.SetSignedClaims(cfg.APISignedClaims, cfg.APIPublicClaims); err != nil {
+		log.Fatal(err)
+	}
+	if err := a.SetSIWE(cfg.APISIWEDomain, cfg.APISessionTTL); err != nil {
+		log.Fatal(err)
+	}
+	switch cfg.Identity.Provider {
+	case "":
+	case identity.GitHubName:
+		if err := a.SetIdentityGate(
+			identity.NewGitHub(cfg.Identity.ClientID, cfg.Identity.ClientSecret, cfg.Identity.RedirectURL),
+			cfg.Identity.MinAccountAge,
+			cfg.Identity.Cooldown,
+		); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unsupported identity provider %s", cfg.Identity.Provider)
+	}
+	if cfg.APITiersFile != "" {
+		tiers, err := api.LoadTiers(cfg.APITiersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := a.SetTiers(tiers); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if err := a.Init(
 		&httpRouter,
 		cfg.API.Route,
 		cfg.API.AllowedAddrs,
+		cfg.APIAdminToken,
 		cfg.Faucet.EnableEVM,
 		cfg.Faucet.EnableVocdoni,
 		v,
@@ -78,11 +185,78 @@ func main() {
 	}
 	log.Infof("API available at %s", cfg.API.Route)
 
+	// enable metrics via proxy
+	if cfg.Metrics.Enabled {
+		metricsAgent := metrics.NewAgent("/metrics",
+			time.Duration(cfg.Metrics.RefreshInterval)*time.Second, &httpRouter)
+		for _, collector := range faucet.MetricsCollectors() {
+			metricsAgent.Register(collector)
+		}
+	}
+
 	log.Info("startup complete")
 	// close if interrupt received
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 	log.Warnf("received SIGTERM, exiting at %s", time.Now().Format(time.RFC850))
+	if err := audit.Close(); err != nil {
+		log.Warnf("cannot close audit log: %s", err)
+	}
+	e.ReleaseSignerLeases()
+	if err := store.Close(); err != nil {
+		log.Warnf("cannot close storage: %s", err)
+	}
 	os.Exit(0)
 }
+
+// openStorage opens the storage of the faucet state, in the postgres database
+// shared by the replicas if configured or else in the data directory
+func openStorage(cfg *config.Config) (storage.Storage, error) {
+	if cfg.StoragePostgres != "" {
+		return storage.OpenSQL("postgres", cfg.StoragePostgres)
+	}
+	return storage.OpenKV(filepath.Join(cfg.DataDir, "storage"))
+}
+
+// importPackages imports the Vocdoni packages of the database at the given
+// path, where they were kept by the previous faucet versions, if it exists
+func importPackages(v *faucet.Vocdoni, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	database, err := pebbledb.New(db.Options{Path: path})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	imported, err := v.ImportPackages(database)
+	if err != nil {
+		return fmt.Errorf("cannot import faucet packages: %w", err)
+	}
+	if imported > 0 {
+		log.Infof("imported %d faucet packages from %s, it can be removed", imported, path)
+	}
+	return nil
+}
+
+// importLists imports the address list entries of the database at the given
+// path, where they were kept by the previous faucet versions, if it exists
+func importLists(lists *faucet.AddressLists, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	database, err := pebbledb.New(db.Options{Path: path})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	imported, err := lists.ImportLists(database)
+	if err != nil {
+		return fmt.Errorf("cannot import address lists: %w", err)
+	}
+	if imported > 0 {
+		log.Infof("imported %d address list entries from %s, it can be removed", imported, path)
+	}
+	return nil
+}
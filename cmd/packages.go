@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/pflag"
+	"go.vocdoni.io/proto/build/go/models"
+	"go.vocdoni.io/vocdoni-faucet/api"
+	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"google.golang.org/protobuf/proto"
+)
+
+// bulkPackage represents a faucet package generated for a bulk distribution
+type bulkPackage struct {
+	Address    common.Address `json:"address"`
+	Amount     uint64         `json:"amount,omitempty"`
+	Identifier uint64         `json:"identifier,string,omitempty"`
+	// FaucetPackage is the package encoded as in the faucetPackage field of the API
+	FaucetPackage string `json:"faucetPackage,omitempty"`
+	// QRCode is the path of the QR code image of the package
+	QRCode string `json:"qrCode,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readBulkAddresses reads the addresses and the optional amounts of a CSV
+// file, with the address in the first column and the amount in the second
+func readBulkAddresses(r io.Reader) ([]*bulkPackage, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	packages := []*bulkPackage{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return packages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 || record[0] == "" {
+			continue
+		}
+		if !common.IsHexAddress(record[0]) {
+			// skip the header
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid address %q", line, record[0])
+		}
+		pkg := &bulkPackage{Address: common.HexToAddress(record[0])}
+		if len(record) > 1 && record[1] != "" {
+			if pkg.Amount, err = strconv.ParseUint(record[1], 10, 64); err != nil || pkg.Amount == 0 {
+				return nil, fmt.Errorf("line %d: invalid amount %q", line, record[1])
+			}
+		}
+		packages = append(packages, pkg)
+	}
+}
+
+// writeBulkPackages writes the generated packages as JSON and CSV in the given directory
+func writeBulkPackages(dir string, packages []*bulkPackage) error {
+	data, err := json.MarshalIndent(packages, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "packages.json"), data, 0o600); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "packages.csv"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"address", "amount", "identifier", "faucetPackage", "qrCode", "error"}); err != nil {
+		return err
+	}
+	for _, pkg := range packages {
+		if err := w.Write([]string{
+			pkg.Address.Hex(),
+			fmt.Sprint(pkg.Amount),
+			fmt.Sprint(pkg.Identifier),
+			pkg.FaucetPackage,
+			pkg.QRCode,
+			pkg.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// packagesGenerateCommand generates the faucet packages of a CSV of addresses
+// with the Vocdoni faucet configuration, as the server would do, and records
+// them in the faucet storage
+func packagesGenerateCommand(args []string) error {
+	input := pflag.String("input", "", "CSV file with the addresses and optional amounts of the packages")
+	output := pflag.String("output", "packages", "directory where the packages and QR codes are written")
+	network := pflag.String("network", "", "vocdoni network of the packages")
+	qrSize := pflag.Int("qrSize", 256, "size in pixels of the QR code images")
+	cfg := config.NewConfig()
+	if err := cfg.InitConfigArgs(args); err != nil {
+		return err
+	}
+	f, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("cannot open input: %w", err)
+	}
+	packages, err := readBulkAddresses(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("cannot read input: %w", err)
+	}
+
+	v := faucet.NewVocdoni()
+	if err := v.Init(context.Background(), cfg.Faucet); err != nil {
+		return err
+	}
+	store, err := openStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("cannot open storage, is the faucet running?: %w", err)
+	}
+	defer store.Close()
+	v.TrackPackages(store)
+
+	if err := os.MkdirAll(filepath.Join(*output, "qr"), 0o700); err != nil {
+		return err
+	}
+	failed := 0
+	for _, pkg := range packages {
+		if err := generateBulkPackage(v, *network, *output, *qrSize, pkg); err != nil {
+			pkg.Error = err.Error()
+			failed++
+		}
+	}
+	if err := writeBulkPackages(*output, packages); err != nil {
+		return fmt.Errorf("cannot write packages: %w", err)
+	}
+	fmt.Printf("%d packages generated in %s\n", len(packages)-failed, *output)
+	if failed > 0 {
+		return fmt.Errorf("%d packages could not be generated, see the error field", failed)
+	}
+	return nil
+}
+
+// generateBulkPackage generates and records the given package and writes its QR code
+func generateBulkPackage(v *faucet.Vocdoni, network, dir string, qrSize int, pkg *bulkPackage) error {
+	fpackage, err := v.GenerateFaucetPackageAmount(network, pkg.Address, pkg.Amount)
+	if err != nil {
+		return err
+	}
+	payload := &models.FaucetPayload{}
+	if err := proto.Unmarshal(fpackage.Payload, payload); err != nil {
+		return err
+	}
+	pkg.Amount = payload.Amount
+	pkg.Identifier = payload.Identifier
+	data, err := json.Marshal(api.FaucetPackage{
+		FaucetPayload: fpackage.Payload,
+		Signature:     fpackage.Signature,
+	})
+	if err != nil {
+		return err
+	}
+	pkg.FaucetPackage = base64.StdEncoding.EncodeToString(data)
+	pkg.QRCode = filepath.Join("qr", strings.ToLower(pkg.Address.Hex())+".png")
+	return qrcode.WriteFile(pkg.FaucetPackage, qrcode.Medium, qrSize, filepath.Join(dir, pkg.QRCode))
+}
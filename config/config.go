@@ -29,7 +29,15 @@ type FaucetConfig struct {
 	// EVMAmount evm amount to send by the faucet
 	EVMAmount,
 	// VocdoniAmount vocdoni amount to send by the faucet
-	VocdoniAmount uint64
+	VocdoniAmount,
+	// EVMTopUpTarget and VocdoniTopUpTarget balance the grants top the
+	// addresses up to instead of sending the amount, 0 means disabled
+	EVMTopUpTarget,
+	VocdoniTopUpTarget,
+	// EVMTopUpMaxGrant and VocdoniTopUpMaxGrant maximum amount of a
+	// top up grant, 0 means no maximum
+	EVMTopUpMaxGrant,
+	VocdoniTopUpMaxGrant uint64
 	// EVM network name to connect with.
 	// Accepted one of SupportedFaucetNetworksMap
 	EVMNetwork,
@@ -42,11 +50,57 @@ type FaucetConfig struct {
 	// Vocdoni network name to connect with.
 	// Accepted one of SupportedFaucetNetworksMap
 	VocdoniNetworks []string
+	// VocdoniNetworkPrivKeys Vocdoni faucet signer keys per network, several
+	// keys for a network (separated by ":") are used in rotation. The networks
+	// without specific keys use VocdoniPrivKey
+	VocdoniNetworkPrivKeys map[string]string
+	// VocdoniNetworkAmounts, VocdoniNetworkThresholds and VocdoniNetworkCooldowns
+	// Vocdoni amount, balance threshold and cooldown per network, the networks
+	// not set use VocdoniAmount, VocdoniSendConditions.Balance and VocdoniCooldown
+	VocdoniNetworkAmounts,
+	VocdoniNetworkThresholds,
+	VocdoniNetworkCooldowns map[string]string
+	// VocdoniCooldown minimum time between packages for the same address
+	VocdoniCooldown,
+	// EVMCooldown minimum time between EVM grants for the same address
+	// without a tier cooldown
+	EVMCooldown time.Duration
+	// VocdoniEndpoints Vocdoni API endpoints per network, used for
+	// checking the redemption of the issued packages
+	VocdoniEndpoints map[string]string
+	// VocdoniPackageTTL time after which the unredeemed Vocdoni packages are
+	// reported as expired, they can still be redeemed. 0 means no expiration
+	VocdoniPackageTTL,
+	// VocdoniWatchInterval interval for checking the redemption of the issued packages
+	VocdoniWatchInterval time.Duration
 	// EVMTimeout faucet global timeout for EVM operations in seconds
 	EVMTimeout time.Duration
+	// EVMSignerLease validity of the EVM signer leases in the Postgres
+	// storage, only the replica holding the lease of a key signs with it
+	EVMSignerLease time.Duration
+	// EVMLegacyTx if true legacy (type-0) transactions are always used,
+	// otherwise the fee market support is detected from the network
+	EVMLegacyTx bool
+	// EVMGasPolicy gas pricing policy for the EVM network
+	EVMGasPolicy GasPolicyConfig
+	// EVMGasLimitMargin safety margin in percent added to the estimated gas
+	// of transfers that require more gas than a plain transfer
+	EVMGasLimitMargin,
+	// EVMMaxGasLimit maximum gas limit of a transfer
+	EVMMaxGasLimit uint64
+	// EVMRefuseContracts if true contract addresses are not funded
+	EVMRefuseContracts bool
+	// EVMSybil sybil rules applied to the EVM recipients
+	EVMSybil SybilConfig
 	// SendConditions config for sendConditions
 	EVMSendConditions     SendConditionsConfig
 	VocdoniSendConditions SendConditionsConfig
+	// AllowlistFile file with the addresses allowed on the restricted networks
+	AllowlistFile string
+	// DenylistFile file with the addresses that cannot claim
+	DenylistFile string
+	// RestrictedNetworks networks only accepting the addresses of the allowlist
+	RestrictedNetworks []string
 }
 
 // SendConditionsConfig represents the send conditions of the faucet configuration
@@ -55,32 +109,112 @@ type SendConditionsConfig struct {
 	Challenge bool
 }
 
+// GasPolicyConfig represents the gas pricing policy of the EVM faucet
+type GasPolicyConfig struct {
+	// MaxFeePerGas maximum fee per gas in wei, 0 means no cap
+	MaxFeePerGas,
+	// MaxTipPerGas maximum priority fee per gas in wei, 0 means no cap
+	MaxTipPerGas uint64
+	// BaseFeeMultiplier multiplier applied to the base fee for computing the max fee
+	BaseFeeMultiplier float64
+	// DeferOnHighGas if true the sends refused while the gas price is above
+	// the cap are queued and done once the price goes down
+	DeferOnHighGas bool
+	// RetryAfter interval the queued sends are retried at
+	RetryAfter time.Duration
+}
+
+// SybilConfig represents the sybil rules applied to the EVM recipients, the
+// actions are refuse or reduce
+type SybilConfig struct {
+	// MinNonce minimum nonce of the recipients, 0 disables the fresh rule
+	MinNonce uint64
+	// FreshAction action for the recipients below the minimum nonce
+	FreshAction string
+	// ContractAction action for the contract recipients, the rule is disabled if empty
+	ContractAction string
+	// Sinks known sink addresses, the sink rule is disabled if empty
+	Sinks []string
+	// SinkAction action for the recipients that forwarded a previous grant to a sink
+	SinkAction string
+	// SinkScanBlocks maximum number of blocks after a grant scanned looking for forwards
+	SinkScanBlocks uint64
+	// ReducePercent percent of the amount sent to the reduced recipients
+	ReducePercent uint64
+}
+
+// IdentityConfig represents the identity gate of the claims
+type IdentityConfig struct {
+	// Provider identity provider required for claiming (github), disabled if empty
+	Provider string
+	// ClientID OAuth client ID of the provider app
+	ClientID string
+	// ClientSecret OAuth client secret of the provider app
+	ClientSecret string
+	// RedirectURL URL the provider redirects the users to after authenticating
+	RedirectURL string
+	// MinAccountAge minimum age of the accounts allowed to claim
+	MinAccountAge time.Duration
+	// Cooldown minimum time between claims of an identity on a network
+	Cooldown time.Duration
+}
+
 // Config the global configuration of the faucet
 type Config struct {
 	// DataDir base directory to store data
-	DataDir string
-	Log     *LogConfig
-	Faucet  *FaucetConfig
-	API     *vocdoniConfig.API
+	DataDir  string
+	Log      *LogConfig
+	Faucet   *FaucetConfig
+	API      *vocdoniConfig.API
+	Identity *IdentityConfig
+	// APIAdminToken bearer token for the admin API methods,
+	// the admin methods are disabled if empty
+	APIAdminToken string
+	// APISignedClaims if true the claims must be signed by the from address
+	// over a faucet nonce
+	APISignedClaims bool
+	// APIPublicClaims if true the signed claims do not need a bearer token,
+	// it requires APISignedClaims
+	APIPublicClaims bool
+	// APISIWEDomain domain of the Sign-In with Ethereum messages,
+	// the sign in sessions are disabled if empty
+	APISIWEDomain string
+	// APISessionTTL validity of the sign in sessions
+	APISessionTTL time.Duration
+	// APITiersFile JSON file with the tiers of the claims, tiers disabled if empty
+	APITiersFile string
+	Metrics      *vocdoniConfig.MetricsCfg
+	// StoragePostgres connection string of the Postgres database storing the
+	// faucet state shared by the replicas, stored in DataDir if empty
+	StoragePostgres string
 }
 
 // NewConfig returns a pointer to an initialized Config
 func NewConfig() *Config {
 	return &Config{
-		Log:    new(LogConfig),
-		Faucet: new(FaucetConfig),
-		API:    new(vocdoniConfig.API),
+		Log:      new(LogConfig),
+		Faucet:   new(FaucetConfig),
+		API:      new(vocdoniConfig.API),
+		Identity: new(IdentityConfig),
+		Metrics:  new(vocdoniConfig.MetricsCfg),
 	}
 }
 
 // Strings returns the configuration as a string
 func (cfg *Config) String() string {
-	return fmt.Sprintf("DataDir: %s, Log: %+v, Faucet: %+v, API: %+v",
-		cfg.DataDir, cfg.Log, cfg.Faucet, cfg.API)
+	// the identity config is not included as it has the client secret
+	return fmt.Sprintf("DataDir: %s, Log: %+v, Faucet: %+v, API: %+v, Metrics: %+v",
+		cfg.DataDir, cfg.Log, cfg.Faucet, cfg.API, cfg.Metrics)
 }
 
 // InitConfig initializes the Config with user provided args
 func (cfg *Config) InitConfig() error {
+	return cfg.InitConfigArgs(os.Args[1:])
+}
+
+// InitConfigArgs initializes the Config with the given args, the flags
+// registered in the pflag command line before calling it are also parsed
+func (cfg *Config) InitConfigArgs(args []string) error {
 	// get $HOME
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -94,6 +228,8 @@ func (cfg *Config) InitConfig() error {
 	cfg.Log.ErrorFile = *pflag.String("logErrorFile", "", "log errors and warnings to a file")
 	// common
 	pflag.StringVar(&cfg.DataDir, "dataDir", home+"/.faucet", "directory where data is stored")
+	cfg.StoragePostgres = *pflag.String("storagePostgres", "",
+		"postgres connection string of the faucet state shared by the replicas (stored in the data directory if empty)")
 	// faucet
 	cfg.Faucet.EnableEVM = *pflag.Bool("enableEVM", true, "enable evm faucet")
 	cfg.Faucet.EnableVocdoni = *pflag.Bool("enableVocdoni", true, "enable vocdoni faucet")
@@ -101,6 +237,8 @@ func (cfg *Config) InitConfig() error {
 		"hexString privKeys for EVM faucet accounts")
 	cfg.Faucet.VocdoniPrivKey = *pflag.String("vocdoniPrivKey",
 		"", "hexString privKeys for vocdoni faucet accounts")
+	cfg.Faucet.VocdoniNetworkPrivKeys = *pflag.StringToString("vocdoniNetworkPrivKeys", map[string]string{},
+		"hexString privKeys per vocdoni network, several keys separated by : are used in rotation (i.e dev=key1:key2)")
 	cfg.Faucet.EVMEndpoints = *pflag.StringSlice("evmEndpoints", []string{},
 		"evm endpoints to connect with (requied for the evm faucet)")
 	cfg.Faucet.EVMNetwork = *pflag.String("evmNetwork",
@@ -112,7 +250,71 @@ func (cfg *Config) InitConfig() error {
 		1,
 		"evm faucet amount in wei (1000000000000000000 == 1 ETH)",
 	)
+	cfg.Faucet.EVMCooldown = *pflag.Duration("faucetEVMCooldown", time.Hour,
+		"minimum time between evm grants for the same address (0 means no cooldown)")
+	cfg.Faucet.EVMTopUpTarget = *pflag.Uint64("faucetEVMTopUpTarget", 0,
+		"evm balance in wei the grants top the addresses up to instead of sending the amount (0 means disabled)")
+	cfg.Faucet.EVMTopUpMaxGrant = *pflag.Uint64("faucetEVMTopUpMaxGrant", 0,
+		"maximum evm amount in wei of a top up grant (0 means no maximum)")
+	cfg.Faucet.EVMSignerLease = *pflag.Duration("faucetEVMSignerLease", 30*time.Second,
+		"validity of the evm signer leases in the postgres storage, only the replica holding the lease of a key signs with it")
+	cfg.Faucet.EVMLegacyTx = *pflag.Bool("evmLegacyTx", false,
+		"force legacy (type-0) evm transactions, by default fee market support is detected")
+	cfg.Faucet.EVMGasPolicy.MaxFeePerGas = *pflag.Uint64("evmMaxFeePerGas", 0,
+		"maximum evm fee per gas in wei (0 means no cap)")
+	cfg.Faucet.EVMGasPolicy.MaxTipPerGas = *pflag.Uint64("evmMaxTipPerGas", 0,
+		"maximum evm priority fee per gas in wei (0 means no cap)")
+	cfg.Faucet.EVMGasPolicy.BaseFeeMultiplier = *pflag.Float64("evmBaseFeeMultiplier", 2,
+		"multiplier applied to the evm base fee for computing the max fee per gas")
+	cfg.Faucet.EVMGasPolicy.DeferOnHighGas = *pflag.Bool("evmDeferOnHighGas", false,
+		"if true evm sends refused while the gas price is above the cap are queued until the price goes down")
+	cfg.Faucet.EVMGasPolicy.RetryAfter = *pflag.Duration("evmGasRetryAfter", time.Minute,
+		"interval the evm sends queued by the gas price are retried at")
+	cfg.Faucet.EVMGasLimitMargin = *pflag.Uint64("evmGasLimitMargin", 20,
+		"safety margin in percent added to the estimated gas of evm transfers to contracts")
+	cfg.Faucet.EVMMaxGasLimit = *pflag.Uint64("evmMaxGasLimit", 100000,
+		"maximum gas limit of an evm transfer")
+	cfg.Faucet.EVMRefuseContracts = *pflag.Bool("evmRefuseContracts", false,
+		"if true the evm faucet does not fund contract addresses")
+	cfg.Faucet.EVMSybil.MinNonce = *pflag.Uint64("evmSybilMinNonce", 0,
+		"minimum nonce of the evm recipients, the fresh addresses below it are refused or reduced (0 disables the rule)")
+	cfg.Faucet.EVMSybil.FreshAction = *pflag.String("evmSybilFreshAction", "reduce",
+		"action for the evm recipients below the minimum nonce (refuse or reduce)")
+	cfg.Faucet.EVMSybil.ContractAction = *pflag.String("evmSybilContractAction", "",
+		"action for the evm contract recipients (refuse or reduce), the rule is disabled if empty")
+	cfg.Faucet.EVMSybil.Sinks = *pflag.StringSlice("evmSybilSinks", []string{},
+		"known sink addresses, the evm recipients that forwarded a previous grant to them are refused or reduced")
+	cfg.Faucet.EVMSybil.SinkAction = *pflag.String("evmSybilSinkAction", "refuse",
+		"action for the evm recipients that forwarded a previous grant to a sink (refuse or reduce)")
+	cfg.Faucet.EVMSybil.SinkScanBlocks = *pflag.Uint64("evmSybilSinkScanBlocks", 100,
+		"maximum number of blocks after a grant scanned looking for forwards to the sinks")
+	cfg.Faucet.EVMSybil.ReducePercent = *pflag.Uint64("evmSybilReducePercent", 50,
+		"percent of the evm amount sent to the recipients reduced by the sybil rules")
 	cfg.Faucet.VocdoniAmount = *pflag.Uint64("faucetVocdoniAmount", 100, "vocdoni faucet amount")
+	cfg.Faucet.VocdoniTopUpTarget = *pflag.Uint64("faucetVocdoniTopUpTarget", 0,
+		"vocdoni balance the packages top the addresses up to instead of granting the amount (0 means disabled)")
+	cfg.Faucet.VocdoniTopUpMaxGrant = *pflag.Uint64("faucetVocdoniTopUpMaxGrant", 0,
+		"maximum vocdoni amount of a top up package (0 means no maximum)")
+	cfg.Faucet.VocdoniCooldown = *pflag.Duration("faucetVocdoniCooldown", 0,
+		"minimum time between vocdoni packages for the same address (0 means no cooldown)")
+	cfg.Faucet.VocdoniNetworkAmounts = *pflag.StringToString("faucetVocdoniNetworkAmounts", map[string]string{},
+		"vocdoni faucet amount per network (i.e dev=1000,lts=10)")
+	cfg.Faucet.VocdoniNetworkThresholds = *pflag.StringToString("faucetVocdoniNetworkAmountThresholds",
+		map[string]string{}, "minimum vocdoni amount threshold for transfer per network (i.e dev=1000,lts=10)")
+	cfg.Faucet.VocdoniNetworkCooldowns = *pflag.StringToString("faucetVocdoniNetworkCooldowns", map[string]string{},
+		"minimum time between vocdoni packages for the same address per network (i.e dev=1h,lts=24h)")
+	cfg.Faucet.VocdoniEndpoints = *pflag.StringToString("vocdoniEndpoints", map[string]string{},
+		"vocdoni API endpoints per network for checking the packages redemption (i.e dev=https://api-dev.vocdoni.net/v2)")
+	cfg.Faucet.VocdoniPackageTTL = *pflag.Duration("faucetVocdoniPackageTTL", 24*time.Hour,
+		"time after which unredeemed vocdoni faucet packages are reported as expired, they can still be redeemed (0 means no expiration)")
+	cfg.Faucet.VocdoniWatchInterval = *pflag.Duration("vocdoniWatchInterval", 30*time.Second,
+		"interval for checking the redemption of the issued vocdoni faucet packages")
+	cfg.Faucet.AllowlistFile = *pflag.String("allowlistFile", "",
+		"file with the addresses allowed on the restricted networks, one address[,network[,reason]] per line")
+	cfg.Faucet.DenylistFile = *pflag.String("denylistFile", "",
+		"file with the addresses that cannot claim, one address[,network[,reason]] per line")
+	cfg.Faucet.RestrictedNetworks = *pflag.StringSlice("restrictedNetworks", []string{},
+		"networks only accepting the addresses of the allowlist (i.e mainnet,lts)")
 	cfg.Faucet.EVMSendConditions.Balance = *pflag.Uint64(
 		"faucetEVMAmountThreshold",
 		1,
@@ -121,7 +323,7 @@ func (cfg *Config) InitConfig() error {
 	cfg.Faucet.EVMSendConditions.Challenge = *pflag.Bool(
 		"faucetEVMEnableChallenge",
 		false,
-		"if true a EVM faucet challenge must be solved",
+		"if true a EVM faucet challenge must be solved, not enforced yet",
 	)
 	cfg.Faucet.VocdoniSendConditions.Balance = *pflag.Uint64(
 		"faucetVocdoniAmountThreshold",
@@ -131,7 +333,7 @@ func (cfg *Config) InitConfig() error {
 	cfg.Faucet.VocdoniSendConditions.Challenge = *pflag.Bool(
 		"faucetVocdoniEnableChallenge",
 		false,
-		"if true a vocdoni faucet challenge must be solved",
+		"if true a vocdoni faucet challenge must be solved, not enforced yet",
 	)
 	// api
 	cfg.API.Route = *pflag.String("apiRoute", "/", "dvote API route")
@@ -144,8 +346,37 @@ func (cfg *Config) InitConfig() error {
 		"",
 		"bearer token whitelist for accepting requests (comma separated string)",
 	)
+	cfg.APIAdminToken = *pflag.String("apiAdminToken", "",
+		"bearer token for the admin API methods (admin methods disabled if empty)")
+	cfg.APISignedClaims = *pflag.Bool("apiSignedClaims", false,
+		"if true the claims must be signed by the from address over a faucet nonce")
+	cfg.APIPublicClaims = *pflag.Bool("apiPublicClaims", false,
+		"if true the signed claims do not need a bearer token (requires apiSignedClaims)")
+	cfg.APISIWEDomain = *pflag.String("apiSIWEDomain", "",
+		"domain of the Sign-In with Ethereum messages (sign in sessions disabled if empty)")
+	cfg.APISessionTTL = *pflag.Duration("apiSessionTTL", time.Hour, "validity of the sign in sessions")
+	cfg.APITiersFile = *pflag.String("apiTiersFile", "",
+		"JSON file with the tiers of the bearer tokens and identities and their grants per network (tiers disabled if empty)")
+	// identity
+	cfg.Identity.Provider = *pflag.String("identityProvider", "",
+		"identity provider required for claiming (github), identity gate disabled if empty")
+	cfg.Identity.ClientID = *pflag.String("identityClientID", "", "OAuth client ID of the identity provider app")
+	cfg.Identity.ClientSecret = *pflag.String("identityClientSecret", "",
+		"OAuth client secret of the identity provider app")
+	cfg.Identity.RedirectURL = *pflag.String("identityRedirectURL", "",
+		"URL the identity provider redirects the users to after authenticating")
+	cfg.Identity.MinAccountAge = *pflag.Duration("identityMinAccountAge", 30*24*time.Hour,
+		"minimum age of the identity accounts allowed to claim")
+	cfg.Identity.Cooldown = *pflag.Duration("identityCooldown", 24*time.Hour,
+		"minimum time between claims of an identity on a network")
+	// metrics
+	cfg.Metrics.Enabled = *pflag.Bool("metricsEnabled", false, "enable prometheus metrics")
+	cfg.Metrics.RefreshInterval = *pflag.Int("metricsRefreshInterval", 5,
+		"metrics refresh interval in seconds")
 	// parse flags
-	pflag.Parse()
+	if err := pflag.CommandLine.Parse(args); err != nil {
+		return err
+	}
 
 	// setting up viper
 	viper := viper.New()
@@ -168,6 +399,9 @@ func (cfg *Config) InitConfig() error {
 	if err := viper.BindPFlag("dataDir", pflag.Lookup("dataDir")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("storagePostgres", pflag.Lookup("storagePostgres")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	// faucet
 	if err := viper.BindPFlag("faucet.EnableEVM", pflag.Lookup("enableEVM")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
@@ -181,6 +415,9 @@ func (cfg *Config) InitConfig() error {
 	if err := viper.BindPFlag("faucet.VocdoniPrivKey", pflag.Lookup("vocdoniPrivKey")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("faucet.VocdoniNetworkPrivKeys", pflag.Lookup("vocdoniNetworkPrivKeys")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	if err := viper.BindPFlag("faucet.EVMEndpoints", pflag.Lookup("evmEndpoints")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
@@ -190,15 +427,123 @@ func (cfg *Config) InitConfig() error {
 	if err := viper.BindPFlag("faucet.VocdoniNetworks", pflag.Lookup("vocdoniNetworks")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("faucet.EVMLegacyTx", pflag.Lookup("evmLegacyTx")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMGasPolicy.MaxFeePerGas", pflag.Lookup("evmMaxFeePerGas")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMGasPolicy.MaxTipPerGas", pflag.Lookup("evmMaxTipPerGas")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag(
+		"faucet.EVMGasPolicy.BaseFeeMultiplier",
+		pflag.Lookup("evmBaseFeeMultiplier"),
+	); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMGasPolicy.DeferOnHighGas", pflag.Lookup("evmDeferOnHighGas")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMGasPolicy.RetryAfter", pflag.Lookup("evmGasRetryAfter")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMGasLimitMargin", pflag.Lookup("evmGasLimitMargin")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMMaxGasLimit", pflag.Lookup("evmMaxGasLimit")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMRefuseContracts", pflag.Lookup("evmRefuseContracts")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.MinNonce", pflag.Lookup("evmSybilMinNonce")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.FreshAction", pflag.Lookup("evmSybilFreshAction")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.ContractAction", pflag.Lookup("evmSybilContractAction")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.Sinks", pflag.Lookup("evmSybilSinks")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.SinkAction", pflag.Lookup("evmSybilSinkAction")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.SinkScanBlocks", pflag.Lookup("evmSybilSinkScanBlocks")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSybil.ReducePercent", pflag.Lookup("evmSybilReducePercent")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	if err := viper.BindPFlag("faucet.EVMAmount", pflag.Lookup("faucetEVMAmount")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("faucet.EVMCooldown", pflag.Lookup("faucetEVMCooldown")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMTopUpTarget", pflag.Lookup("faucetEVMTopUpTarget")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMTopUpMaxGrant", pflag.Lookup("faucetEVMTopUpMaxGrant")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.EVMSignerLease", pflag.Lookup("faucetEVMSignerLease")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.VocdoniTopUpTarget", pflag.Lookup("faucetVocdoniTopUpTarget")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.VocdoniTopUpMaxGrant", pflag.Lookup("faucetVocdoniTopUpMaxGrant")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	if err := viper.BindPFlag(
 		"faucet.VocdoniAmount",
 		pflag.Lookup("faucetVocdoniAmount"),
 	); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("faucet.VocdoniCooldown", pflag.Lookup("faucetVocdoniCooldown")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag(
+		"faucet.VocdoniNetworkAmounts",
+		pflag.Lookup("faucetVocdoniNetworkAmounts"),
+	); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag(
+		"faucet.VocdoniNetworkThresholds",
+		pflag.Lookup("faucetVocdoniNetworkAmountThresholds"),
+	); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag(
+		"faucet.VocdoniNetworkCooldowns",
+		pflag.Lookup("faucetVocdoniNetworkCooldowns"),
+	); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.VocdoniEndpoints", pflag.Lookup("vocdoniEndpoints")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.VocdoniPackageTTL", pflag.Lookup("faucetVocdoniPackageTTL")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.VocdoniWatchInterval", pflag.Lookup("vocdoniWatchInterval")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.AllowlistFile", pflag.Lookup("allowlistFile")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.DenylistFile", pflag.Lookup("denylistFile")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("faucet.RestrictedNetworks", pflag.Lookup("restrictedNetworks")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	if err := viper.BindPFlag(
 		"faucet.EVMSendConditions.Balance",
 		pflag.Lookup("faucetEVMAmountThreshold"),
@@ -236,10 +581,53 @@ func (cfg *Config) InitConfig() error {
 	if err := viper.BindPFlag("api.AllowedAddrs", pflag.Lookup("apiWhitelist")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	if err := viper.BindPFlag("apiAdminToken", pflag.Lookup("apiAdminToken")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("apiSignedClaims", pflag.Lookup("apiSignedClaims")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("apiPublicClaims", pflag.Lookup("apiPublicClaims")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("apiSIWEDomain", pflag.Lookup("apiSIWEDomain")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("apiSessionTTL", pflag.Lookup("apiSessionTTL")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("apiTiersFile", pflag.Lookup("apiTiersFile")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.Provider", pflag.Lookup("identityProvider")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.ClientID", pflag.Lookup("identityClientID")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.ClientSecret", pflag.Lookup("identityClientSecret")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.RedirectURL", pflag.Lookup("identityRedirectURL")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.MinAccountAge", pflag.Lookup("identityMinAccountAge")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("identity.Cooldown", pflag.Lookup("identityCooldown")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	viper.Set("api.Ssl.DirCert", cfg.DataDir+"/tls")
 	if err := viper.BindPFlag("api.Ssl.Domain", pflag.Lookup("apiTLSDomain")); err != nil {
 		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
 	}
+	// metrics
+	if err := viper.BindPFlag("metrics.Enabled", pflag.Lookup("metricsEnabled")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
+	if err := viper.BindPFlag("metrics.RefreshInterval", pflag.Lookup("metricsRefreshInterval")); err != nil {
+		return fmt.Errorf("%s: %s", ErrBindPFlag, err)
+	}
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return err
 	}
@@ -0,0 +1,377 @@
+package faucet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// AuditKind is the kind of an audit log entry
+type AuditKind string
+
+// AuditDecision is the outcome of an audited request
+type AuditDecision string
+
+const (
+	// AuditKindClaim a faucet claim request
+	AuditKindClaim AuditKind = "claim"
+	// AuditKindAdmin a change done with the admin API
+	AuditKindAdmin AuditKind = "admin"
+
+	// AuditDecisionGranted the claim is granted or the change applied
+	AuditDecisionGranted AuditDecision = "granted"
+	// AuditDecisionRefused the claim or the change is refused
+	AuditDecisionRefused AuditDecision = "refused"
+
+	// AuditLogFile is the name of the audit log file in the data directory
+	AuditLogFile = "audit.log"
+)
+
+// ErrAuditChainBroken error returned if the audit log entries do not match
+// their hash or the hash of the previous entry, as if they were tampered with
+var ErrAuditChainBroken error = errors.New("audit log hash chain broken")
+
+// AuditEntry represents a record of the audit log, chained to the previous
+// one by its hash
+type AuditEntry struct {
+	// Seq is the position of the entry in the log, starting from 1
+	Seq uint64 `json:"seq"`
+	// Time is the time the entry was recorded
+	Time time.Time `json:"time"`
+	// Kind is the kind of the entry (claim or admin)
+	Kind AuditKind `json:"kind"`
+	// Action is the audited action, such as claim or keys.add
+	Action string `json:"action"`
+	// Faucet is the faucet of the action (evm or vocdoni)
+	Faucet string `json:"faucet,omitempty"`
+	// Network is the network of the action
+	Network string `json:"network,omitempty"`
+	// Address is the recipient of the claim or the address changed
+	Address *evmcommon.Address `json:"address,omitempty"`
+	// Token is the fingerprint of the bearer token used, see TokenFingerprint
+	Token string `json:"token,omitempty"`
+	// Identity is the key of the verified identity of the claim
+	Identity string `json:"identity,omitempty"`
+	// Tier is the tier the claim was granted with
+	Tier string `json:"tier,omitempty"`
+	// Decision is the outcome (granted or refused)
+	Decision AuditDecision `json:"decision"`
+	// Error is the reason of the refusal
+	Error string `json:"error,omitempty"`
+	// Amount is the amount granted
+	Amount string `json:"amount,omitempty"`
+	// TxHash is the hash of the EVM tx sent
+	TxHash types.HexBytes `json:"txHash,omitempty"`
+	// PackageIdentifier is the identifier of the Vocdoni faucet package issued
+	PackageIdentifier string `json:"packageIdentifier,omitempty"`
+	// Details are additional details, such as the request metadata
+	Details map[string]string `json:"details,omitempty"`
+	// PrevHash is the hash of the previous entry, empty for the first one
+	PrevHash types.HexBytes `json:"prevHash,omitempty"`
+	// Hash is the sha256 hash of the entry encoded without it
+	Hash types.HexBytes `json:"hash"`
+}
+
+// hash returns the hash of the entry, computed over its JSON encoding without the hash
+func (ae *AuditEntry) hash() ([]byte, error) {
+	entry := *ae
+	entry.Hash = nil
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+// TokenFingerprint returns the fingerprint of a bearer token recorded in the
+// audit log instead of the token itself, empty for an empty token
+func TokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:8])
+}
+
+// AuditLog represents an append-only audit log file or storage, where each
+// entry includes the hash of the previous one so any change can be detected.
+// The methods of a nil AuditLog do nothing
+type AuditLog struct {
+	file *os.File
+	// store keeps the entries instead of the file, nil if a file is used
+	store storage.Storage
+	seq   uint64
+	head  []byte
+	lock  sync.Mutex
+}
+
+// OpenAuditLog opens or creates the audit log at the given path, verifying
+// its hash chain. An incomplete last entry, as left by a crash while writing
+// it, is discarded
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	al := &AuditLog{file: f}
+	size, err := al.load()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot load audit log %s: %w", path, err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return al, nil
+}
+
+// NewStorageAuditLog returns an audit log kept in the given storage, verifying
+// its hash chain. The replicas sharing the storage append their entries to
+// the same chain
+func NewStorageAuditLog(s storage.Storage) (*AuditLog, error) {
+	al := &AuditLog{store: s}
+	if err := al.catchUp(); err != nil {
+		return nil, fmt.Errorf("cannot load audit log: %w", err)
+	}
+	return al, nil
+}
+
+// catchUp verifies and follows the stored entries appended after the last
+// known one, such as the ones of other replicas. The lock must be held
+func (al *AuditLog) catchUp() error {
+	records, err := al.store.AuditRecords(al.seq + 1)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		entry, err := verifyAuditEntry(record.Data, al.seq, al.head)
+		if err != nil {
+			return err
+		}
+		if entry.Seq != record.Seq {
+			return fmt.Errorf("%w: entry %d stored as %d", ErrAuditChainBroken, entry.Seq, record.Seq)
+		}
+		al.seq, al.head = entry.Seq, entry.Hash
+	}
+	return nil
+}
+
+// load verifies the entries of the log file and returns the size of its
+// complete entries
+func (al *AuditLog) load() (int64, error) {
+	reader := bufio.NewReader(al.file)
+	size := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Warnf("discarding incomplete audit log entry after %d", al.seq)
+			}
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		entry, err := verifyAuditEntry(line, al.seq, al.head)
+		if err != nil {
+			return 0, err
+		}
+		al.seq, al.head = entry.Seq, entry.Hash
+		size += int64(len(line))
+	}
+}
+
+// verifyAuditEntry decodes an entry and checks it follows the given previous
+// entry sequence and hash
+func verifyAuditEntry(line []byte, prevSeq uint64, prevHash []byte) (*AuditEntry, error) {
+	entry := &AuditEntry{}
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, fmt.Errorf("%w: cannot decode entry after %d: %s", ErrAuditChainBroken, prevSeq, err)
+	}
+	if entry.Seq != prevSeq+1 || !bytes.Equal(entry.PrevHash, prevHash) {
+		return nil, fmt.Errorf("%w: entry %d does not follow entry %d", ErrAuditChainBroken, entry.Seq, prevSeq)
+	}
+	hash, err := entry.hash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hash, entry.Hash) {
+		return nil, fmt.Errorf("%w: entry %d hash mismatch", ErrAuditChainBroken, entry.Seq)
+	}
+	return entry, nil
+}
+
+// Record appends the given entry to the log, setting its sequence, time and
+// hashes. The entry is synced to disk or stored before returning
+func (al *AuditLog) Record(entry *AuditEntry) error {
+	if al == nil {
+		return nil
+	}
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	for {
+		data, err := al.chain(entry)
+		if err != nil {
+			return err
+		}
+		if al.store == nil {
+			return al.write(entry, data)
+		}
+		err = al.store.AppendAudit(&storage.AuditRecord{Seq: entry.Seq, Data: data})
+		if errors.Is(err, storage.ErrConflict) {
+			// another replica appended an entry, chain the entry after it
+			if err := al.catchUp(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot store audit log entry: %w", err)
+		}
+		al.seq, al.head = entry.Seq, entry.Hash
+		return nil
+	}
+}
+
+// chain sets the sequence, time and hashes of the entry following the last
+// one and returns its encoding
+func (al *AuditLog) chain(entry *AuditEntry) ([]byte, error) {
+	entry.Seq = al.seq + 1
+	entry.Time = time.Now().UTC()
+	entry.PrevHash = al.head
+	entry.Hash = nil
+	hash, err := entry.hash()
+	if err != nil {
+		return nil, err
+	}
+	entry.Hash = hash
+	return json.Marshal(entry)
+}
+
+// write appends the encoded entry to the log file
+func (al *AuditLog) write(entry *AuditEntry, data []byte) error {
+	if _, err := al.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("cannot write audit log entry: %w", err)
+	}
+	if err := al.file.Sync(); err != nil {
+		return fmt.Errorf("cannot sync audit log: %w", err)
+	}
+	al.seq, al.head = entry.Seq, entry.Hash
+	return nil
+}
+
+// Head returns the sequence and the hash of the last entry
+func (al *AuditLog) Head() (uint64, []byte) {
+	if al == nil {
+		return 0, nil
+	}
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.store != nil {
+		if err := al.catchUp(); err != nil {
+			log.Warnf("cannot follow the stored audit log: %s", err)
+		}
+	}
+	return al.seq, al.head
+}
+
+// Entries returns the entries of the log recorded within the given times,
+// the zero times mean no limit. The whole hash chain is verified
+func (al *AuditLog) Entries(from, to time.Time) ([]*AuditEntry, error) {
+	if al == nil {
+		return []*AuditEntry{}, nil
+	}
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.store != nil {
+		records, err := al.store.AuditRecords(1)
+		if err != nil {
+			return nil, err
+		}
+		return readAuditRecords(records, from, to)
+	}
+	f, err := os.Open(al.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAuditLog(f, from, to)
+}
+
+// ReadAuditLog reads and verifies the audit log entries of the given reader,
+// returning the ones recorded within the given times, the zero times mean no
+// limit. ErrAuditChainBroken is returned if the chain is not valid
+func ReadAuditLog(r io.Reader, from, to time.Time) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	reader := bufio.NewReader(r)
+	seq := uint64(0)
+	var head []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		// the incomplete last entry is being written or will be discarded
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry, err := verifyAuditEntry(line, seq, head)
+		if err != nil {
+			return nil, err
+		}
+		seq, head = entry.Seq, entry.Hash
+		if (!from.IsZero() && entry.Time.Before(from)) || (!to.IsZero() && entry.Time.After(to)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// readAuditRecords verifies the stored audit log records, returning the
+// entries recorded within the given times, the zero times mean no limit
+func readAuditRecords(records []*storage.AuditRecord, from, to time.Time) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	seq := uint64(0)
+	var head []byte
+	for _, record := range records {
+		entry, err := verifyAuditEntry(record.Data, seq, head)
+		if err != nil {
+			return nil, err
+		}
+		seq, head = entry.Seq, entry.Hash
+		if (!from.IsZero() && entry.Time.Before(from)) || (!to.IsZero() && entry.Time.After(to)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Close closes the audit log file, the storage of a stored log is not closed
+func (al *AuditLog) Close() error {
+	if al == nil || al.file == nil {
+		return nil
+	}
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	return al.file.Close()
+}
@@ -2,6 +2,8 @@ package faucet
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"go.vocdoni.io/dvote/crypto/ethereum"
 )
@@ -49,6 +51,18 @@ var (
 	ErrInvalidTimeout error = errors.New("invalid timeout")
 	// ErrInvalidSigner error wrapping invalid signer errors
 	ErrInvalidSigner error = errors.New("invalid signer")
+	// ErrCooldownActive error wrapping requests done before the cooldown expiration errors
+	ErrCooldownActive error = errors.New("cooldown active")
+	// ErrBalanceAboveThreshold error wrapping recipients with enough balance errors
+	ErrBalanceAboveThreshold error = errors.New("balance above threshold")
+	// ErrContractRecipient error wrapping refused contract recipients errors
+	ErrContractRecipient error = errors.New("recipient is a contract")
+	// ErrGasLimitTooHigh error wrapping transfers requiring too much gas errors
+	ErrGasLimitTooHigh error = errors.New("gas limit above the configured maximum")
+	// ErrFaucetEmpty error wrapping signers without funds errors
+	ErrFaucetEmpty error = errors.New("faucet has not enough funds")
+	// ErrTopUpUnavailable error wrapping top up grants without a known recipient balance errors
+	ErrTopUpUnavailable error = errors.New("top up unavailable")
 
 	// EVMSupportedFaucetNetworksMap have all the networks the faucet supports
 	EVMSupportedFaucetNetworksMap = map[string]FaucetNetworks{
@@ -73,12 +87,50 @@ var (
 	}
 )
 
+// CooldownError is returned if an address requests funds before the end of
+// the cooldown, it wraps ErrCooldownActive
+type CooldownError struct {
+	// NextAt is the time from which the address can request funds again
+	NextAt time.Time
+}
+
+func (ce *CooldownError) Error() string {
+	return fmt.Sprintf("%s: next package available at %s", ErrCooldownActive, ce.NextAt.Format(time.RFC3339))
+}
+
+func (ce *CooldownError) Unwrap() error {
+	return ErrCooldownActive
+}
+
 // Signer represents a signer
 type Signer struct {
 	// SignKeys ECDSA keypair
 	SignKeys *ethereum.SignKeys
 	// Taken semaphore
 	Taken chan bool
+	// State rotation state, only active signers are used for new sends
+	State KeyState
+}
+
+// GrantSettings overrides the settings of a grant, such as the ones of the
+// recipients with a tier. The zero values use the faucet network settings
+type GrantSettings struct {
+	// Amount of tokens to grant
+	Amount uint64
+	// Threshold balance from which an address cannot get tokens
+	Threshold uint64
+	// Cooldown minimum time between grants for the same address
+	Cooldown time.Duration
+}
+
+// topUpAmount returns the amount topping the balance up to the target, clamped
+// by the maximum grant if not 0. The balance must be below the target
+func topUpAmount(target, balance, maxGrant uint64) uint64 {
+	amount := target - balance
+	if maxGrant > 0 && amount > maxGrant {
+		return maxGrant
+	}
+	return amount
 }
 
 type sendConditions struct {
@@ -87,7 +139,3 @@ type sendConditions struct {
 	// Challenge true if challenge enabled
 	Challenge bool
 }
-
-func (sc *sendConditions) balanceCheck(balance uint64) bool {
-	return balance < sc.Balance
-}
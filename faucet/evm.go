@@ -2,20 +2,40 @@ package faucet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 	"sync"
 	"time"
 
 	goethereum "github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	evmcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	evmtypes "github.com/ethereum/go-ethereum/core/types"
 	evmClient "github.com/ethereum/go-ethereum/ethclient"
+	evmparams "github.com/ethereum/go-ethereum/params"
+	"github.com/google/uuid"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+const (
+	// transferGas gas used by a plain transfer
+	transferGas = uint64(21000)
+	// DefaultMaxGasLimit maximum gas limit of a transfer when no
+	// maximum is configured
+	DefaultMaxGasLimit = uint64(100000)
+	// txMiningTimeout maximum time waiting for a sent tx to be mined, then
+	// it is no longer tracked and its signer is released
+	txMiningTimeout = 30 * time.Minute
+	// txStatusInterval default interval for checking the status of a sent tx
+	txStatusInterval = 10 * time.Second
+	// signerWaitInterval interval for checking again the signers while all
+	// of them have a pending tx
+	signerWaitInterval = 5 * time.Second
 )
 
 // EVM contains all components required for the EVM faucet
@@ -36,7 +56,36 @@ type EVM struct {
 	timeout time.Duration
 	// sendConditions conditions to meet before sending faucet tokens
 	sendConditions *sendConditions
-	lock           sync.RWMutex
+	// legacyTx if true legacy (type-0) transactions are always used
+	legacyTx bool
+	// gasPolicy gas pricing policy
+	gasPolicy *gasPolicy
+	// gasLimitMargin safety margin in percent for estimated gas limits
+	gasLimitMargin uint64
+	// maxGasLimit maximum gas limit of a transfer
+	maxGasLimit uint64
+	// refuseContracts if true contract addresses are not funded
+	refuseContracts bool
+	// cooldown minimum time between grants for the same address without a
+	// grant cooldown
+	cooldown time.Duration
+	// topUpTarget balance the grants top the addresses up to, 0 means disabled
+	topUpTarget uint64
+	// topUpMaxGrant maximum amount of a top up grant, 0 means no maximum
+	topUpMaxGrant uint64
+	// sybil rules applied to the recipients, nil if no rule is enabled
+	sybil *sybilRules
+	// storage persists the grants, the pending txs and the signer key states
+	storage storage.Storage
+	// holder identifies the faucet replica in the signer leases and the send queues
+	holder string
+	// leaseTTL validity of the signer leases, 0 if the leases are disabled
+	leaseTTL time.Duration
+	// statusInterval interval for checking the status of the sent txs
+	statusInterval time.Duration
+	// leases expiration of the signer leases held by the replica
+	leases map[evmcommon.Address]time.Time
+	lock   sync.RWMutex
 
 	// for testing purposes
 	forTest     bool
@@ -45,7 +94,39 @@ type EVM struct {
 
 // NewEVM returns an EVM instance
 func NewEVM() *EVM {
-	return &EVM{}
+	return &EVM{storage: storage.NewMemory(), holder: uuid.NewString()}
+}
+
+// SetStorage persists the grants with a cooldown, the pending txs and the
+// signer key states in the given storage, instead of keeping them in memory.
+// It restores the key states and resumes tracking the txs pending since a
+// previous run. It must be called after Init
+func (e *EVM) SetStorage(ctx context.Context, s storage.Storage) error {
+	e.lock.Lock()
+	signers, err := restoreKeyStates(s, e.keysScope(), e.signers)
+	if err == nil {
+		e.signers = signers
+		e.storage = s
+	}
+	e.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	txs, err := s.PendingTxs()
+	if err != nil {
+		return fmt.Errorf("cannot get pending txs: %w", err)
+	}
+	for _, tx := range txs {
+		log.Infof("tracking tx %s pending since %s", tx.Hash.Hex(), tx.SentAt.Format(time.RFC3339))
+		hash := tx.Hash
+		go e.waitForTx(ctx, &hash, nil)
+	}
+	return nil
+}
+
+// claimKey returns the storage key of the grants to the address
+func (e *EVM) claimKey(to evmcommon.Address) string {
+	return "evm/" + e.network + "/" + strings.ToLower(to.Hex())
 }
 
 // Amount returns the amount for the faucet
@@ -69,6 +150,75 @@ func (e *EVM) Network() string {
 	return e.network
 }
 
+// ChainID returns the chain ID of the faucet EVM network
+func (e *EVM) ChainID() int {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.chainID
+}
+
+// Threshold returns the balance from which an address cannot get tokens
+func (e *EVM) Threshold() uint64 {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.sendConditions.Balance
+}
+
+// Cooldown returns the minimum time between grants for the same address
+// without a grant cooldown
+func (e *EVM) Cooldown() time.Duration {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.cooldown
+}
+
+// TopUpTarget returns the balance the grants top the addresses up to, 0 if
+// the top up mode is disabled
+func (e *EVM) TopUpTarget() uint64 {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.topUpTarget
+}
+
+// Addresses returns the addresses of the active signers
+func (e *EVM) Addresses() []evmcommon.Address {
+	addresses := []evmcommon.Address{}
+	for _, signer := range e.activeSigners() {
+		addresses = append(addresses, signer.SignKeys.Address())
+	}
+	return addresses
+}
+
+// EVMStatus represents the current status of the EVM faucet
+type EVMStatus struct {
+	Network string       `json:"network"`
+	ChainID int          `json:"chainId"`
+	Amount  string       `json:"amount"`
+	Signers []string     `json:"signers"`
+	Keys    []*KeyStatus `json:"keys"`
+	Gas     *GasStatus   `json:"gas"`
+}
+
+// Status returns the current status of the EVM faucet
+func (e *EVM) Status() *EVMStatus {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	status := &EVMStatus{
+		Network: e.network,
+		ChainID: e.chainID,
+		Amount:  fmt.Sprint(e.amount),
+		Signers: []string{},
+		Keys:    keysStatus(e.signers),
+	}
+	for _, signer := range e.signers {
+		status.Signers = append(status.Signers, signer.SignKeys.Address().Hex())
+	}
+	if e.gasPolicy != nil {
+		status.Gas = e.gasPolicy.status()
+	}
+	return status
+}
+
 func (e *EVM) setSendConditions(balance uint64, challenge bool) {
 	e.lock.Lock()
 	defer e.lock.Unlock()
@@ -115,11 +265,11 @@ func (e *EVM) SetSigners(signersPrivKeys []string) error {
 	defer e.lock.Unlock()
 	signers := make([]*Signer, 0)
 	for _, key := range signersPrivKeys {
-		s := new(ethereum.SignKeys)
-		if err := s.AddHexKey(key); err != nil {
-			return fmt.Errorf("cannot import key: %w", err)
+		signer, err := newSigner(key, KeyStateActive)
+		if err != nil {
+			return err
 		}
-		signers = append(signers, &Signer{SignKeys: s, Taken: make(chan bool, 1)})
+		signers = append(signers, signer)
 	}
 	e.signers = signers
 	return nil
@@ -156,13 +306,87 @@ func (e *EVM) Init(ctx context.Context, evmConfig *config.FaucetConfig) error {
 		evmConfig.EVMTimeout = time.Minute
 	}
 	e.timeout = evmConfig.EVMTimeout
+	e.statusInterval = txStatusInterval
 
 	// set send conditions
 	e.setSendConditions(evmConfig.EVMSendConditions.Balance, evmConfig.EVMSendConditions.Challenge)
+	if evmConfig.EVMSendConditions.Challenge {
+		log.Warn("the EVM faucet challenge is not enforced yet, the claims do not solve it")
+	}
+
+	// set transaction type and gas policy
+	e.legacyTx = evmConfig.EVMLegacyTx
+	e.gasPolicy = newGasPolicy(
+		evmConfig.EVMGasPolicy.MaxFeePerGas,
+		evmConfig.EVMGasPolicy.MaxTipPerGas,
+		evmConfig.EVMGasPolicy.BaseFeeMultiplier,
+		evmConfig.EVMGasPolicy.DeferOnHighGas,
+		evmConfig.EVMGasPolicy.RetryAfter,
+	)
+
+	// set gas limit constraints
+	e.gasLimitMargin = evmConfig.EVMGasLimitMargin
+	e.maxGasLimit = evmConfig.EVMMaxGasLimit
+	if e.maxGasLimit == 0 {
+		e.maxGasLimit = DefaultMaxGasLimit
+	}
+	e.refuseContracts = evmConfig.EVMRefuseContracts
+
+	e.cooldown = evmConfig.EVMCooldown
+
+	// set top up mode
+	e.topUpTarget = evmConfig.EVMTopUpTarget
+	e.topUpMaxGrant = evmConfig.EVMTopUpMaxGrant
+
+	// set sybil rules
+	if e.sybil, err = newSybilRules(&evmConfig.EVMSybil); err != nil {
+		return fmt.Errorf("cannot set sybil rules: %w", err)
+	}
 
 	return nil
 }
 
+// evmBackend is the set of EVM node methods used by the faucet, it is
+// implemented by both the ethclient and the simulated backend
+type evmBackend interface {
+	goethereum.ChainStateReader
+	goethereum.TransactionReader
+	HeaderByNumber(ctx context.Context, number *big.Int) (*evmtypes.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*evmtypes.Block, error)
+	PendingNonceAt(ctx context.Context, account evmcommon.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call goethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *evmtypes.Transaction) error
+}
+
+// backend returns the backend the faucet must use, connecting
+// the client if required
+func (e *EVM) backend(ctx context.Context) (evmBackend, error) {
+	if e.forTest {
+		return e.testBackend.Backend, nil
+	}
+	if e.client == nil {
+		if err := e.NewClient(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return e.client, nil
+}
+
+// feeMarketSupported returns true if the network supports EIP-1559
+// transactions, it is detected using the base fee of the latest block
+func (e *EVM) feeMarketSupported(ctx context.Context, backend evmBackend) (bool, error) {
+	if e.legacyTx {
+		return false, nil
+	}
+	header, err := backend.HeaderByNumber(ctx, nil) // nil means latest block
+	if err != nil {
+		return false, fmt.Errorf("cannot get latest block header: %w", err)
+	}
+	return header.BaseFee != nil, nil
+}
+
 // NewClient returns a working ethereum client connected to one of the faucet provided endpoints,
 // returns error any endpoint works as expected
 func (e *EVM) NewClient(ctx context.Context) error {
@@ -211,7 +435,7 @@ func (e *EVM) ClientBalanceAt(ctx context.Context,
 // from the connected node
 func (e *EVM) ClientChainID(ctx context.Context) (*big.Int, error) {
 	if e.forTest {
-		return e.testBackend.Backend.Blockchain().Config().ChainID, nil
+		return e.testBackend.Backend.ChainID(), nil
 	}
 	if e.client == nil {
 		if err := e.NewClient(ctx); err != nil {
@@ -247,99 +471,188 @@ func (e *EVM) checkTxStatus(ctx context.Context, txHash *evmcommon.Hash) (uint64
 	return receipt.Status, nil
 }
 
+// gasLimit estimates the gas required for transferring the amount from the
+// signer to the given address, if more gas than a plain transfer is required
+// the configured margin is applied
+func (e *EVM) gasLimit(ctx context.Context,
+	backend evmBackend,
+	from,
+	to evmcommon.Address,
+	amount uint64,
+) (uint64, error) {
+	gas, err := backend.EstimateGas(ctx, goethereum.CallMsg{
+		From:  from,
+		To:    &to,
+		Value: big.NewInt(int64(amount)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot estimate gas: %w", err)
+	}
+	if gas > transferGas {
+		gas += gas * e.gasLimitMargin / 100
+	}
+	if gas > e.maxGasLimit {
+		return 0, fmt.Errorf("%w: %d > %d", ErrGasLimitTooHigh, gas, e.maxGasLimit)
+	}
+	return gas, nil
+}
+
+// isContract returns true if the given address has code deployed
+func (e *EVM) isContract(ctx context.Context, backend evmBackend, address evmcommon.Address) (bool, error) {
+	code, err := backend.CodeAt(ctx, address, nil) // nil means latest block
+	if err != nil {
+		return false, fmt.Errorf("cannot get code at %s: %w", address.Hex(), err)
+	}
+	return len(code) > 0, nil
+}
+
+// fundsError wraps with ErrFaucetEmpty the errors caused by the
+// signer not having enough funds, the node only reports them as text
+func fundsError(err error, signer *Signer) error {
+	if strings.Contains(err.Error(), "insufficient funds") {
+		return fmt.Errorf("%w: signer %s: %s", ErrFaucetEmpty, signer.SignKeys.Address().Hex(), err)
+	}
+	return err
+}
+
 // sendTokens send tokens and returns the hash of the tx
 func (e *EVM) sendTokens(ctx context.Context,
 	to evmcommon.Address,
-	signerIndex int,
+	amount uint64,
+	from *Signer,
+	prices *gasPrices,
 ) (*evmcommon.Hash, error) {
+	backend, err := e.backend(ctx)
+	if err != nil {
+		return nil, err
+	}
 	// get nonce for the signer
 	tctx, cancel := context.WithTimeout(ctx, e.timeout)
 	defer cancel()
-	var nonce uint64
-	var err error
-	var gasPrice, maxPriorityFeePerGas *big.Int
-	if e.forTest {
-		nonce, err = e.testBackend.Backend.PendingNonceAt(tctx, e.signers[signerIndex].SignKeys.Address())
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-		gasPrice, err = e.testBackend.Backend.SuggestGasPrice(tctx)
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-		maxPriorityFeePerGas, err = e.testBackend.Backend.SuggestGasTipCap(tctx)
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-	} else {
-		if e.client == nil {
-			if err := e.NewClient(ctx); err != nil {
-				return nil, err
-			}
-		}
-		nonce, err = e.client.PendingNonceAt(tctx, e.signers[signerIndex].SignKeys.Address())
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-		gasPrice, err = e.client.SuggestGasPrice(tctx)
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-		maxPriorityFeePerGas, err = e.client.SuggestGasTipCap(tctx)
-		if err != nil {
-			return nil, fmt.Errorf("error creating tx: %s", err)
-		}
-	}
+	nonce, err := backend.PendingNonceAt(tctx, from.SignKeys.Address())
 	if err != nil {
 		return nil, fmt.Errorf("cannot get signer account nonce: %s", err)
 	}
+	gas, err := e.gasLimit(tctx, backend, from.SignKeys.Address(), to, amount)
+	if err != nil {
+		return nil, fundsError(err, from)
+	}
 	// create tx
-	tx := evmtypes.NewTx(&evmtypes.DynamicFeeTx{
-		ChainID:   big.NewInt(int64(e.chainID)),
-		Nonce:     nonce,
-		GasFeeCap: gasPrice,
-		GasTipCap: maxPriorityFeePerGas,
-		Gas:       uint64(21000), // enough for standard eth transfers
-		To:        &to,
-		Value:     big.NewInt(int64(e.amount)), // in wei
-	})
+	chainID := big.NewInt(int64(e.chainID))
+	var tx *evmtypes.Transaction
+	var signer evmtypes.Signer
+	if prices.gasFeeCap != nil {
+		tx = evmtypes.NewTx(&evmtypes.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasFeeCap: prices.gasFeeCap,
+			GasTipCap: prices.gasTipCap,
+			Gas:       gas,
+			To:        &to,
+			Value:     big.NewInt(int64(amount)), // in wei
+		})
+		signer = evmtypes.NewLondonSigner(chainID)
+	} else {
+		tx = evmtypes.NewTx(&evmtypes.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: prices.gasPrice,
+			Gas:      gas,
+			To:       &to,
+			Value:    big.NewInt(int64(amount)), // in wei
+		})
+		signer = evmtypes.NewEIP155Signer(chainID)
+	}
 	// sign tx
-	signedTx, err := evmtypes.SignTx(tx,
-		evmtypes.NewLondonSigner(big.NewInt(int64(e.chainID))),
-		&e.signers[signerIndex].SignKeys.Private,
-	)
+	signedTx, err := evmtypes.SignTx(tx, signer, &from.SignKeys.Private)
 	if err != nil {
 		return nil, fmt.Errorf("cannot sign transaction: %s", err)
 	}
 	// send tx
 	tctx2, cancel2 := context.WithTimeout(ctx, e.timeout)
 	defer cancel2()
-	if e.forTest {
-		err = e.testBackend.Backend.SendTransaction(tctx2, signedTx)
-	} else {
-		err = e.client.SendTransaction(tctx2, signedTx)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("cannot send signed tx: %s", err)
+	if err := backend.SendTransaction(tctx2, signedTx); err != nil {
+		return nil, fundsError(fmt.Errorf("cannot send signed tx: %w", err), from)
 	}
-	log.Infof("sending %d tokens to newly created entity %s from signer: %s. TxHash: %s and Nonce: %d",
-		e.amount,
+	log.Infof("sending %d tokens to newly created entity %s from signer: %s. TxHash: %s, Nonce: %d and Type: %d",
+		amount,
 		to.String(),
-		e.signers[signerIndex].SignKeys.AddressString(),
+		from.SignKeys.AddressString(),
 		signedTx.Hash().Hex(),
 		signedTx.Nonce(),
+		signedTx.Type(),
 	)
 	nHash := new(evmcommon.Hash)
 	*nHash = signedTx.Hash()
 	return nHash, nil
 }
 
-// SendTokens sends an amount to an address if the address meets the send conditions
+// SendTokens sends the faucet amount to an address if the address meets the send conditions
 func (e *EVM) SendTokens(ctx context.Context, to evmcommon.Address) (*evmcommon.Hash, error) {
+	return e.SendTokensAmount(ctx, to, 0)
+}
+
+// SendTokensAmount sends the given amount to an address if the address meets
+// the send conditions, 0 means the faucet amount. The amount can be reduced
+// by the sybil rules
+func (e *EVM) SendTokensAmount(ctx context.Context,
+	to evmcommon.Address,
+	amount uint64,
+) (*evmcommon.Hash, error) {
+	txHash, _, err := e.SendTokensWith(ctx, to, &GrantSettings{Amount: amount})
+	return txHash, err
+}
+
+// SendTokensWith sends tokens as SendTokens but with the given grant settings,
+// it returns the hash of the tx and the amount sent. The grants are refused
+// if the address got one before the end of the grant cooldown, or of the
+// faucet cooldown if the grant has none. In top up mode the amount tops the
+// address balance up to the target, unless an amount is given. If the gas
+// price is above the cap and the gas policy defers the sends, the send is
+// queued and a DeferredSendError is returned with the amount granted
+func (e *EVM) SendTokensWith(ctx context.Context,
+	to evmcommon.Address,
+	grant *GrantSettings,
+) (*evmcommon.Hash, uint64, error) {
+	granted := false
+	amount := grant.Amount
+	if amount == 0 {
+		amount = e.amount
+	}
+	threshold := grant.Threshold
+	if threshold == 0 {
+		threshold = e.sendConditions.Balance
+	}
+	// in top up mode the target is the threshold
+	topUp := grant.Amount == 0 && e.topUpTarget > 0
+	if topUp {
+		threshold = e.topUpTarget
+	}
+	// the grant is reserved while sending, so the address cannot get a
+	// concurrent one during the cooldown, and released if it fails
+	cooldown := grant.Cooldown
+	if cooldown == 0 {
+		cooldown = e.cooldown
+	}
+	key := e.claimKey(to)
+	previous, err := e.storage.ReserveClaim(key, cooldown)
+	cooldownErr := &storage.CooldownError{}
+	if errors.As(err, &cooldownErr) {
+		return nil, 0, &CooldownError{NextAt: cooldownErr.NextAt}
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot reserve grant: %w", err)
+	}
+	defer func() {
+		if granted {
+			return
+		}
+		if err := e.storage.ReleaseClaim(key, previous); err != nil {
+			log.Warnf("cannot release grant to %s: %s", to.Hex(), err)
+		}
+	}()
 	if e.client == nil && !e.forTest {
 		if err := e.NewClient(ctx); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
@@ -348,21 +661,105 @@ func (e *EVM) SendTokens(ctx context.Context, to evmcommon.Address) (*evmcommon.
 	defer cancel()
 	toBalance, err := e.balanceAt(tctx, to, nil) // nil means latest block
 	if err != nil {
-		return nil, fmt.Errorf("cannot check entity balance")
+		return nil, 0, fmt.Errorf("cannot check entity balance")
 	}
-	if !e.sendConditions.balanceCheck(toBalance.Uint64()) {
-		return nil, fmt.Errorf("%s has already a balance of: %d, greater than the sendConditions",
+	if !toBalance.IsUint64() || toBalance.Uint64() >= threshold {
+		return nil, 0, fmt.Errorf("%w: %s has already a balance of: %s, greater than the sendConditions",
+			ErrBalanceAboveThreshold,
 			to.String(),
-			toBalance.Int64(),
+			toBalance.String(),
 		)
 	}
+	if topUp {
+		amount = topUpAmount(e.topUpTarget, toBalance.Uint64(), e.topUpMaxGrant)
+	}
+
+	backend, err := e.backend(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	// check to address is not a contract if contracts are refused
+	if e.refuseContracts {
+		isContract, err := e.isContract(tctx, backend, to)
+		if err != nil {
+			return nil, 0, err
+		}
+		if isContract {
+			return nil, 0, fmt.Errorf("%w: %s", ErrContractRecipient, to.String())
+		}
+	}
+	// apply the sybil rules to the recipient
+	if e.sybil != nil {
+		if amount, err = e.sybil.check(tctx, backend, e.storage, e.claimKey(to), e.chainID, to, amount); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var txHash *evmcommon.Hash
+	if e.forwards() {
+		var pending bool
+		txHash, pending, err = e.forward(ctx, to, amount)
+		// a forwarded send taken and not finished can still be done, so
+		// its grant is kept even if it fails
+		granted = pending
+	} else {
+		txHash, err = e.send(ctx, to, amount)
+	}
+	if errors.Is(err, ErrGasPriceTooHigh) && e.gasPolicy.deferOnHighGas {
+		id, derr := e.deferSend(to, amount, err)
+		if derr != nil {
+			return nil, 0, derr
+		}
+		granted = true
+		return nil, amount, &DeferredSendError{
+			ID:         id,
+			RetryAfter: e.gasPolicy.retryAfter,
+			err:        fmt.Errorf("%w: %s", ErrSendDeferred, err),
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if e.sybil != nil {
+		rctx, cancel := context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+		e.sybil.recordGrant(rctx, backend, e.storage, e.claimKey(to), to)
+	}
+	granted = true
+	return txHash, amount, nil
+}
+
+// send sends the amount to the address with the first signer available,
+// waiting for one if all of them have a pending tx, and tracks the tx
+func (e *EVM) send(ctx context.Context, to evmcommon.Address, amount uint64) (*evmcommon.Hash, error) {
+	backend, err := e.backend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// price the tx according to the gas policy
+	tctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	feeMarket, err := e.feeMarketSupported(tctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	prices, err := e.gasPolicy.prices(tctx, backend, feeMarket)
+	if err != nil {
+		return nil, err
+	}
 
 	var finished bool
 	var txHash *evmcommon.Hash
 	var nonce uint64
 	for {
-		// run until signer available
-		for signerIndex, signer := range e.signers {
+		// run until signer available, the signers are checked again on each
+		// attempt as their leases can be lost meanwhile
+		signers := e.sendingSigners()
+		if len(signers) == 0 {
+			return nil, fmt.Errorf("%w: no signer to send with", ErrSignerUnavailable)
+		}
+		lost := false
+		for _, signer := range signers {
 			select {
 			case signer.Taken <- true:
 			default:
@@ -371,16 +768,26 @@ func (e *EVM) SendTokens(ctx context.Context, to evmcommon.Address) (*evmcommon.
 					signer.SignKeys.AddressString())
 				continue
 			}
+			// check the lease is still held before using the signer nonces
+			held, err := e.fence(signer.SignKeys.Address())
+			if err != nil {
+				<-signer.Taken
+				return nil, err
+			}
+			if !held {
+				<-signer.Taken
+				lost = true
+				continue
+			}
 			// send tokens
 			log.Debugf("using signer %s", signer.SignKeys.AddressString())
 			tctx2, cancel2 := context.WithTimeout(ctx, e.timeout)
 			defer cancel2()
-			txHash, err = e.sendTokens(tctx2, to, signerIndex)
+			txHash, err = e.sendTokens(tctx2, to, amount, signer, prices)
 			if err != nil {
-				log.Warnf("cannot send tx %s", txHash.Hex())
+				log.Warnf("cannot send tx: %s", err)
 				<-signer.Taken
-				finished = true
-				break
+				return nil, err
 			}
 			// add pending tx
 			log.Infof("signer %s tx: %s with nonce: %d successfully sent",
@@ -388,45 +795,85 @@ func (e *EVM) SendTokens(ctx context.Context, to evmcommon.Address) (*evmcommon.
 				txHash.String(),
 				nonce,
 			)
-			tctx3, cancel3 := context.WithTimeout(ctx, e.timeout)
-			defer cancel3()
-			go e.waitForTx(tctx3, txHash, signerIndex)
+			if err := e.storage.AddPendingTx(&storage.PendingTx{
+				Hash:   *txHash,
+				Signer: signer.SignKeys.Address(),
+				To:     to,
+				Amount: amount,
+				SentAt: time.Now(),
+			}); err != nil {
+				log.Warnf("cannot record pending tx %s: %s", txHash.Hex(), err)
+			}
+			// the tx is tracked beyond the request that sent it
+			go e.waitForTx(context.Background(), txHash, signer)
 			finished = true
 			break
 		}
-		// wait for signers
+		// wait for signers, unless the signers are checked again as some
+		// lease was lost
 		if finished {
 			break
 		}
-		time.Sleep(time.Second * 5)
+		if lost {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %s", ErrSignerUnavailable, ctx.Err())
+		case <-time.After(signerWaitInterval):
+		}
 	}
 	return txHash, nil
 }
 
-func (e *EVM) waitForTx(ctx context.Context, txHash *evmcommon.Hash, signerIndex int) {
+// waitForTx waits until the tx is mined or failed, up to txMiningTimeout,
+// and then removes it from the pending txs and releases its signer, if any
+func (e *EVM) waitForTx(ctx context.Context, txHash *evmcommon.Hash, signer *Signer) {
+	ctx, cancel := context.WithTimeout(ctx, txMiningTimeout)
+	defer cancel()
 	// wait until tx status is available, means tx is already mined
 	for {
 		status, err := e.checkTxStatus(ctx, txHash)
-		if err != nil {
-			if err == goethereum.NotFound {
-				// wait and check again
-				time.Sleep(time.Second * 10)
+		if errors.Is(err, goethereum.NotFound) {
+			// wait and check again
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(e.statusInterval):
 				continue
 			}
-			log.Warnf("cannot checktx hash %s status with err: %s", txHash.Hex(), err)
-			break
 		}
-		log.Debugf("tx %s status is: %d", txHash.Hex(), status)
-		if status == 0 {
+		if err != nil {
+			log.Warnf("cannot check tx %s status, no longer tracked: %s", txHash.Hex(), err)
+		} else if status == 0 {
 			log.Warnf("tx %s failed", txHash.Hex())
-			break
+		} else {
+			log.Infof("tx %s mined", txHash.Hex())
 		}
-		log.Infof("tx %s mined", txHash.Hex())
 		break
 	}
+	if err := e.storage.RemovePendingTx(*txHash); err != nil {
+		log.Warnf("cannot remove pending tx %s: %s", txHash.Hex(), err)
+	}
+	if signer == nil {
+		return
+	}
 	e.lock.Lock()
 	defer e.lock.Unlock()
-	<-e.signers[signerIndex].Taken
+	<-signer.Taken
+}
+
+// activeSigners returns the signers that can be used for new sends
+func (e *EVM) activeSigners() []*Signer {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	signers := []*Signer{}
+	for _, signer := range e.signers {
+		if signer.State == KeyStateActive {
+			signers = append(signers, signer)
+		}
+	}
+	return signers
 }
 
 func (e *EVM) balanceAt(ctx context.Context,
@@ -445,16 +892,29 @@ func (e *EVM) balanceAt(ctx context.Context,
 
 // InitForTest inits an EVM instance with a simulated evm backend
 func (e *EVM) InitForTest(ctx context.Context, evmConfig *config.FaucetConfig) error {
+	return e.initForTest(ctx, evmConfig, false)
+}
+
+// InitForTestPreLondon inits an EVM instance with a simulated evm backend
+// without the London fork (and hence without EIP-1559 support)
+func (e *EVM) InitForTestPreLondon(ctx context.Context, evmConfig *config.FaucetConfig) error {
+	return e.initForTest(ctx, evmConfig, true)
+}
+
+func (e *EVM) initForTest(ctx context.Context, evmConfig *config.FaucetConfig, preLondon bool) error {
 	if err := e.Init(ctx, evmConfig); err != nil {
 		return err
 	}
 	e.testBackend = &evmTestBackend{
-		PrivKey: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		PrivKey:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		PreLondon: preLondon,
 	}
 	if err := e.testBackend.new(); err != nil {
 		return err
 	}
 	e.forTest = true
+	// the simulated txs are mined on commit
+	e.statusInterval = 100 * time.Millisecond
 	return nil
 }
 
@@ -464,8 +924,9 @@ func (e *EVM) TestBackend() *evmTestBackend {
 }
 
 type evmTestBackend struct {
-	PrivKey string
-	Backend *backends.SimulatedBackend
+	PrivKey   string
+	PreLondon bool
+	Backend   *SimulatedBackend
 }
 
 func (eb *evmTestBackend) new() error {
@@ -484,8 +945,19 @@ func (eb *evmTestBackend) new() error {
 			Balance: balance,
 		},
 	}
-	blockGasLimit := uint64(4712388)
-	eb.Backend = backends.NewSimulatedBackend(genesisAlloc, blockGasLimit)
+	// copy the config so the global one is never modified
+	chainConfig := *evmparams.AllEthashProtocolChanges
+	if eb.PreLondon {
+		chainConfig.LondonBlock = nil
+		chainConfig.ArrowGlacierBlock = nil
+		chainConfig.GrayGlacierBlock = nil
+		chainConfig.MergeNetsplitBlock = nil
+	}
+	backend, err := newSimulatedBackend(&chainConfig, genesisAlloc, uint64(4712388))
+	if err != nil {
+		return err
+	}
+	eb.Backend = backend
 	eb.Commit()
 	return nil
 }
@@ -1,13 +1,32 @@
 package faucet_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	evmtypes "github.com/ethereum/go-ethereum/core/types"
+	evmcrypto "github.com/ethereum/go-ethereum/crypto"
 	qt "github.com/frankban/quicktest"
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
+	"go.vocdoni.io/proto/build/go/models"
 	"go.vocdoni.io/vocdoni-faucet/config"
 	"go.vocdoni.io/vocdoni-faucet/faucet"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -82,7 +101,7 @@ func TestSendTokens(t *testing.T) {
 
 	// should not work if sendConditions are not met
 	_, err = e.SendTokens(context.Background(), toAddr.Address())
-	qt.Assert(t, err, qt.IsNotNil)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
 	e.TestBackend().Backend.Commit() // save ethereum state
 	newBalance, err = e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
 	qt.Assert(t, err, qt.IsNil)
@@ -172,3 +191,1240 @@ func TestNewVocdoni(t *testing.T) {
 	vConfig1.VocdoniPrivKey = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
 }
+
+func TestSendTokensLegacy(t *testing.T) {
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+
+	// should fall back to legacy txs on a pre-London network
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTestPreLondon(context.Background(), &eConfig1), qt.IsNil)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	txHash, err := e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err := e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.Type(), qt.Equals, uint8(evmtypes.LegacyTxType))
+	newBalance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, newBalance.Int64(), qt.DeepEquals, int64(100))
+
+	// should use dynamic fee txs on a London network
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	txHash, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err = e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.Type(), qt.Equals, uint8(evmtypes.DynamicFeeTxType))
+
+	// should use legacy txs on a London network if forced
+	eConfig1.EVMLegacyTx = true
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	txHash, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err = e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.Type(), qt.Equals, uint8(evmtypes.LegacyTxType))
+}
+
+func TestGasPolicy(t *testing.T) {
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+
+	// should fail if the base fee is above the cap
+	eConfig1.EVMGasPolicy = config.GasPolicyConfig{MaxFeePerGas: 1}
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	_, err := e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrGasPriceTooHigh)
+	qt.Assert(t, e.Status().Gas.LastBaseFee, qt.Not(qt.Equals), "")
+
+	// should queue the send while the gas price is above the cap if deferring
+	// is enabled, keeping the grant, and send it once the price goes down
+	header, err := e.TestBackend().Backend.HeaderByNumber(context.Background(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	eConfig1.EVMGasPolicy.MaxFeePerGas = header.BaseFee.Uint64() * 3 / 4
+	eConfig1.EVMGasPolicy.DeferOnHighGas = true
+	eConfig1.EVMGasPolicy.RetryAfter = 100 * time.Millisecond
+	eConfig1.EVMCooldown = time.Hour
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	store := storage.NewMemory()
+	qt.Assert(t, e.SetStorage(context.Background(), store), qt.IsNil)
+	txHash, amount, err := e.SendTokensWith(context.Background(), toAddr.Address(), &faucet.GrantSettings{})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSendDeferred)
+	qt.Assert(t, txHash, qt.IsNil)
+	qt.Assert(t, amount, qt.Equals, uint64(100))
+	deferredErr := &faucet.DeferredSendError{}
+	qt.Assert(t, errors.As(err, &deferredErr), qt.IsTrue)
+	qt.Assert(t, deferredErr.RetryAfter, qt.Equals, 100*time.Millisecond)
+	qt.Assert(t, e.Status().Gas.DeferredSends, qt.Equals, int64(1))
+	_, err = store.QueuedSend(deferredErr.ID)
+	qt.Assert(t, err, qt.IsNil)
+	_, _, err = e.SendTokensWith(context.Background(), toAddr.Address(), &faucet.GrantSettings{})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+	// the base fee goes down with the empty blocks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.ServeDeferredSends(ctx)
+	for i := 0; i < 100; i++ {
+		e.TestBackend().Commit()
+		balance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+		qt.Assert(t, err, qt.IsNil)
+		if balance.Uint64() == 100 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	balance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Uint64(), qt.Equals, uint64(100))
+	_, err = store.QueuedSend(deferredErr.ID)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+
+	// should cap the fee and the tip, the base fee of the new chains is the
+	// one of the first block
+	maxFee := header.BaseFee.Uint64() + 1
+	eConfig1.EVMGasPolicy = config.GasPolicyConfig{MaxFeePerGas: maxFee, MaxTipPerGas: 1}
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	txHash, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err := e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.GasFeeCap().Uint64(), qt.Equals, maxFee)
+	qt.Assert(t, tx.GasTipCap().Uint64(), qt.Equals, uint64(1))
+
+	// should accept legacy txs priced at the cap, the pre-London gas price is 1
+	eConfig1.EVMGasPolicy = config.GasPolicyConfig{MaxFeePerGas: 1}
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTestPreLondon(context.Background(), &eConfig1), qt.IsNil)
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+
+	// should refuse legacy txs priced above the cap instead of capping them,
+	// the London gas price is the base fee
+	eConfig1.EVMLegacyTx = true
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrGasPriceTooHigh)
+	qt.Assert(t, e.Status().Gas.LastGasPrice, qt.Not(qt.Equals), "1")
+}
+
+// deployReceiver deploys a contract storing the value received on every
+// transfer, hence requiring more gas than a plain transfer
+func deployReceiver(t *testing.T, e *faucet.EVM) evmcommon.Address {
+	backend := e.TestBackend().Backend
+	deployer := &ethereum.SignKeys{}
+	qt.Assert(t, deployer.AddHexKey(e.TestBackend().PrivKey), qt.IsNil)
+	nonce, err := backend.PendingNonceAt(context.Background(), deployer.Address())
+	qt.Assert(t, err, qt.IsNil)
+	gasPrice, err := backend.SuggestGasPrice(context.Background())
+	qt.Assert(t, err, qt.IsNil)
+	// init code returning the runtime code CALLVALUE PUSH1 0 SSTORE STOP
+	code := evmcommon.FromHex("0x6005600c60003960056000f33460005500")
+	tx, err := evmtypes.SignTx(evmtypes.NewTx(&evmtypes.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: new(big.Int).Mul(gasPrice, big.NewInt(2)),
+		Gas:      100000,
+		Data:     code,
+	}), evmtypes.NewEIP155Signer(big.NewInt(1337)), &deployer.Private)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, backend.SendTransaction(context.Background(), tx), qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	return evmcrypto.CreateAddress(deployer.Address(), nonce)
+}
+
+func TestSendTokensToContract(t *testing.T) {
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+
+	// should estimate the gas required by the contract
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	contract := deployReceiver(t, e)
+	txHash, err := e.SendTokens(context.Background(), contract)
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err := e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.Gas() > 21000, qt.IsTrue)
+	receipt, err := e.TestBackend().Backend.TransactionReceipt(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, receipt.Status, qt.Equals, evmtypes.ReceiptStatusSuccessful)
+	newBalance, err := e.ClientBalanceAt(context.Background(), contract, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, newBalance.Int64(), qt.DeepEquals, int64(100))
+
+	// plain transfers should keep using the exact gas
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	txHash, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	tx, _, err = e.TestBackend().Backend.TransactionByHash(context.Background(), *txHash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tx.Gas(), qt.Equals, uint64(21000))
+
+	// should not send if the gas limit is above the maximum
+	eConfig1.EVMMaxGasLimit = 30000
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	contract = deployReceiver(t, e)
+	_, err = e.SendTokens(context.Background(), contract)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrGasLimitTooHigh)
+
+	// should refuse contracts if configured
+	eConfig1.EVMMaxGasLimit = 0
+	eConfig1.EVMRefuseContracts = true
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	contract = deployReceiver(t, e)
+	_, err = e.SendTokens(context.Background(), contract)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrContractRecipient)
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+}
+
+type testAccountReader struct {
+	balances map[evmcommon.Address]uint64
+}
+
+func (r *testAccountReader) Balance(_ context.Context, address evmcommon.Address) (uint64, error) {
+	balance, ok := r.balances[address]
+	if !ok {
+		return 0, faucet.ErrAccountNotFound
+	}
+	return balance, nil
+}
+
+func TestVocdoniPackages(t *testing.T) {
+	v := faucet.NewVocdoni()
+	vConfig1 := *vConfig
+	vConfig1.VocdoniSendConditions.Balance = 1000
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
+	// should not work if packages are not tracked
+	_, err := v.Package(1)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPackagesNotTracked)
+
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	reader := &testAccountReader{balances: make(map[evmcommon.Address]uint64)}
+	v.SetAccountReader("dev", reader)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+
+	// should track the issued package
+	fpackage, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	payload := &models.FaucetPayload{}
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	pr, err := v.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Status(), qt.Equals, faucet.PackageStatusIssued)
+	qt.Assert(t, pr.Network, qt.Equals, "dev")
+	qt.Assert(t, pr.Amount, qt.Equals, uint64(100))
+	_, err = v.Package(payload.Identifier + 1)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPackageNotFound)
+
+	// should not issue a new package while the previous is not redeemed
+	_, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	qt.Assert(t, v.CheckRedemptions(context.Background()), qt.IsNil)
+	_, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	// other networks are independent
+	_, err = v.GenerateFaucetPackage("stage", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+
+	// should issue a new package once the previous is redeemed
+	reader.balances[toAddr.Address()] = 100
+	qt.Assert(t, v.CheckRedemptions(context.Background()), qt.IsNil)
+	pr, err = v.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Status(), qt.Equals, faucet.PackageStatusRedeemed)
+	fpackage, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	pr, err = v.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.BaseBalance, qt.Equals, uint64(100))
+
+	// should refuse a new package while the previous is expired but not
+	// redeemed, as it can still be redeemed, and see its late redemption
+	vConfig1.VocdoniPackageTTL = time.Millisecond
+	v2 := faucet.NewVocdoni()
+	qt.Assert(t, v2.Init(context.Background(), &vConfig1), qt.IsNil)
+	v2.TrackPackages(store)
+	v2.SetAccountReader("dev", reader)
+	toAddr2 := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr2.Generate(), qt.IsNil)
+	fpackage, err = v2.GenerateFaucetPackage("dev", toAddr2.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	time.Sleep(time.Millisecond * 10)
+	pr, err = v2.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Status(), qt.Equals, faucet.PackageStatusExpired)
+	_, err = v2.GenerateFaucetPackage("dev", toAddr2.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	reader.balances[toAddr2.Address()] = 100
+	qt.Assert(t, v2.CheckRedemptions(context.Background()), qt.IsNil)
+	pr, err = v2.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Status(), qt.Equals, faucet.PackageStatusRedeemed)
+	reader.balances[toAddr2.Address()] = 0
+	_, err = v2.GenerateFaucetPackage("dev", toAddr2.Address())
+	qt.Assert(t, err, qt.IsNil)
+
+	// should import the packages of the previous database once
+	database, err := pebbledb.New(db.Options{Path: t.TempDir()})
+	qt.Assert(t, err, qt.IsNil)
+	defer database.Close()
+	legacy, err := json.Marshal(&faucet.PackageRecord{
+		Identifier: 7,
+		Network:    "dev",
+		To:         toAddr2.Address().Bytes(),
+		Amount:     100,
+		IssuedAt:   time.Now().Add(-time.Hour),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	tx := database.WriteTx()
+	qt.Assert(t, tx.Set(append([]byte("pkg/"), 0, 0, 0, 0, 0, 0, 0, 7), legacy), qt.IsNil)
+	qt.Assert(t, tx.Commit(), qt.IsNil)
+	imported, err := v2.ImportPackages(database)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, imported, qt.Equals, 1)
+	imported, err = v2.ImportPackages(database)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, imported, qt.Equals, 0)
+	pr, err = v2.Package(7)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Amount, qt.Equals, uint64(100))
+}
+
+// blockingAccountReader is an account reader waiting for the release of the
+// balance requests of the blocked address
+type blockingAccountReader struct {
+	blocked evmcommon.Address
+	waiting chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingAccountReader) Balance(_ context.Context, address evmcommon.Address) (uint64, error) {
+	if address == r.blocked {
+		r.waiting <- struct{}{}
+		<-r.release
+	}
+	return 0, faucet.ErrAccountNotFound
+}
+
+func TestVocdoniPackagesConcurrency(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	reader := &blockingAccountReader{
+		blocked: toAddr.Address(),
+		waiting: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	v.SetAccountReader("dev", reader)
+	done := make(chan error)
+	go func() {
+		_, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+		done <- err
+	}()
+	<-reader.waiting
+
+	// should refuse a concurrent package for the address being issued one,
+	// also from another replica sharing the storage
+	_, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	replica := faucet.NewVocdoni()
+	qt.Assert(t, replica.Init(context.Background(), vConfig), qt.IsNil)
+	replica.TrackPackages(store)
+	_, err = replica.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	// should issue packages for other addresses meanwhile
+	otherAddr := &ethereum.SignKeys{}
+	qt.Assert(t, otherAddr.Generate(), qt.IsNil)
+	_, err = v.GenerateFaucetPackage("dev", otherAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+
+	close(reader.release)
+	qt.Assert(t, <-done, qt.IsNil)
+	_, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+	_, err = replica.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrPendingPackage)
+}
+
+func TestVocdoniSigners(t *testing.T) {
+	devKey1 := "a3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	devKey2 := "b3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	devSigner1, devSigner2 := &ethereum.SignKeys{}, &ethereum.SignKeys{}
+	qt.Assert(t, devSigner1.AddHexKey(devKey1), qt.IsNil)
+	qt.Assert(t, devSigner2.AddHexKey(devKey2), qt.IsNil)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	packageSigner := func(v *faucet.Vocdoni, network string) evmcommon.Address {
+		fpackage, err := v.GenerateFaucetPackage(network, toAddr.Address())
+		qt.Assert(t, err, qt.IsNil)
+		signer, err := ethereum.AddrFromSignature(fpackage.Payload, fpackage.Signature)
+		qt.Assert(t, err, qt.IsNil)
+		return signer
+	}
+
+	// should rotate the network keys and use the default one for the other networks
+	vConfig1 := *vConfig
+	vConfig1.VocdoniNetworks = []string{"dev", "stage"}
+	vConfig1.VocdoniNetworkPrivKeys = map[string]string{"dev": devKey1 + ":" + devKey2}
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
+	qt.Assert(t, packageSigner(v, "dev"), qt.Equals, devSigner1.Address())
+	qt.Assert(t, packageSigner(v, "dev"), qt.Equals, devSigner2.Address())
+	qt.Assert(t, packageSigner(v, "dev"), qt.Equals, devSigner1.Address())
+	qt.Assert(t, packageSigner(v, "stage"), qt.Equals, v.Signer().Address())
+	addresses := v.Addresses()
+	qt.Assert(t, addresses["dev"], qt.DeepEquals, []evmcommon.Address{devSigner1.Address(), devSigner2.Address()})
+	qt.Assert(t, addresses["stage"], qt.DeepEquals, []evmcommon.Address{v.Signer().Address()})
+
+	// should not work without a key for every network
+	vConfig1.VocdoniPrivKey = ""
+	v = faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.ErrorIs, faucet.ErrInvalidSigner)
+	vConfig1.VocdoniNetworks = []string{"dev"}
+	v = faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
+	qt.Assert(t, packageSigner(v, "dev"), qt.Equals, devSigner1.Address())
+	_, err := v.GenerateFaucetPackage("stage", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrInvalidSigner)
+
+	// should not accept keys for invalid networks
+	vConfig1.VocdoniNetworkPrivKeys = map[string]string{"invalid": devKey1}
+	v = faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.ErrorIs, faucet.ErrInvalidNetwork)
+}
+
+func TestVocdoniNetworkSettings(t *testing.T) {
+	vConfig1 := *vConfig
+	vConfig1.VocdoniNetworks = []string{"dev", "lts"}
+	vConfig1.VocdoniNetworkAmounts = map[string]string{"dev": "1000"}
+	vConfig1.VocdoniNetworkThresholds = map[string]string{"dev": "2000"}
+	vConfig1.VocdoniNetworkCooldowns = map[string]string{"prod": "1h"}
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
+	qt.Assert(t, v.Settings("dev"), qt.DeepEquals, &faucet.VocdoniNetworkSettings{
+		Amount:    1000,
+		Threshold: 2000,
+	})
+	qt.Assert(t, v.Settings("lts"), qt.DeepEquals, &faucet.VocdoniNetworkSettings{
+		Amount:    100,
+		Threshold: 100,
+		Cooldown:  time.Hour,
+	})
+	qt.Assert(t, v.Settings("stage"), qt.DeepEquals, &faucet.VocdoniNetworkSettings{
+		Amount:    100,
+		Threshold: 100,
+	})
+
+	store := storage.NewMemory()
+	v.TrackPackages(store)
+	reader := &testAccountReader{balances: make(map[evmcommon.Address]uint64)}
+	v.SetAccountReader("dev", reader)
+	v.SetAccountReader("lts", reader)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	payload := &models.FaucetPayload{}
+
+	// should use the network amount
+	fpackage, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(1000))
+	fpackage, err = v.GenerateFaucetPackage("lts", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(100))
+	// should use the given amount
+	toAddr2 := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr2.Generate(), qt.IsNil)
+	fpackage, err = v.GenerateFaucetPackageAmount("dev", toAddr2.Address(), 42)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(42))
+	pr, err := v.Package(payload.Identifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pr.Amount, qt.Equals, uint64(42))
+
+	// should not issue a package during the network cooldown
+	reader.balances[toAddr.Address()] = 1000
+	qt.Assert(t, v.CheckRedemptions(context.Background()), qt.IsNil)
+	_, err = v.GenerateFaucetPackage("lts", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+	// dev has no cooldown but the balance must be below the network threshold
+	_, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	reader.balances[toAddr.Address()] = 3000
+	qt.Assert(t, v.CheckRedemptions(context.Background()), qt.IsNil)
+	_, err = v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
+
+	// should use the given grant settings instead of the network ones
+	_, err = v.GenerateFaucetPackageWith("dev", toAddr.Address(),
+		&faucet.GrantSettings{Threshold: 5000, Cooldown: time.Hour})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+	fpackage, err = v.GenerateFaucetPackageWith("dev", toAddr.Address(),
+		&faucet.GrantSettings{Amount: 7, Threshold: 5000})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(7))
+
+	// should not accept invalid settings
+	vConfig1.VocdoniNetworkAmounts = map[string]string{"dev": "0"}
+	qt.Assert(t, faucet.NewVocdoni().Init(context.Background(), &vConfig1), qt.ErrorIs, faucet.ErrInvalidAmount)
+	vConfig1.VocdoniNetworkAmounts = map[string]string{"invalid": "10"}
+	qt.Assert(t, faucet.NewVocdoni().Init(context.Background(), &vConfig1), qt.ErrorIs, faucet.ErrInvalidNetwork)
+	vConfig1.VocdoniNetworkAmounts = nil
+	vConfig1.VocdoniNetworkCooldowns = map[string]string{"dev": "invalid"}
+	qt.Assert(t, faucet.NewVocdoni().Init(context.Background(), &vConfig1), qt.IsNotNil)
+}
+
+func TestEVMKeyRotation(t *testing.T) {
+	e := faucet.NewEVM()
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	oldSigner := e.Signers()[0].SignKeys.Address()
+	newKey := "f3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	newSigner := &ethereum.SignKeys{}
+	qt.Assert(t, newSigner.AddHexKey(newKey), qt.IsNil)
+	// fund the new signer
+	qt.Assert(t, e.SetAmount(1079853350110000), qt.IsNil)
+	_, err := e.SendTokens(context.Background(), newSigner.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, e.SetAmount(100), qt.IsNil)
+	e.TestBackend().Backend.Commit() // save ethereum state
+
+	// should add the new key as incoming
+	address, err := e.AddSigner(newKey)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, address, qt.Equals, newSigner.Address())
+	_, err = e.AddSigner(newKey)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrKeyExists)
+	qt.Assert(t, e.Keys(), qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: oldSigner, State: faucet.KeyStateActive},
+		{Address: newSigner.Address(), State: faucet.KeyStateIncoming},
+	})
+	// should not retire the last active key nor skip states
+	qt.Assert(t, e.RetireSigner(oldSigner), qt.ErrorIs, faucet.ErrLastActiveKey)
+	qt.Assert(t, e.RetireSigner(newSigner.Address()), qt.ErrorIs, faucet.ErrInvalidKeyState)
+	qt.Assert(t, e.RemoveSigner(oldSigner), qt.ErrorIs, faucet.ErrInvalidKeyState)
+
+	// should only use the new key once the old one is retiring
+	qt.Assert(t, e.ActivateSigner(newSigner.Address()), qt.IsNil)
+	qt.Assert(t, e.RetireSigner(oldSigner), qt.IsNil)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	nonce, err := e.TestBackend().Backend.PendingNonceAt(context.Background(), newSigner.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, nonce, qt.Equals, uint64(1))
+	e.TestBackend().Backend.Commit() // save ethereum state
+	balance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Int64(), qt.Equals, int64(100))
+	qt.Assert(t, e.Status().Keys, qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: oldSigner, State: faucet.KeyStateRetiring},
+		{Address: newSigner.Address(), State: faucet.KeyStateActive},
+	})
+}
+
+func TestVocdoniKeyRotation(t *testing.T) {
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), vConfig), qt.IsNil)
+	v.TrackPackages(storage.NewMemory())
+	oldSigner := v.Signer().Address()
+	newKey := "f3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	newSigner := &ethereum.SignKeys{}
+	qt.Assert(t, newSigner.AddHexKey(newKey), qt.IsNil)
+	packageSigner := func() evmcommon.Address {
+		toAddr := &ethereum.SignKeys{}
+		qt.Assert(t, toAddr.Generate(), qt.IsNil)
+		fpackage, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+		qt.Assert(t, err, qt.IsNil)
+		signer, err := ethereum.AddrFromSignature(fpackage.Payload, fpackage.Signature)
+		qt.Assert(t, err, qt.IsNil)
+		return signer
+	}
+	qt.Assert(t, packageSigner(), qt.Equals, oldSigner)
+
+	// should add the new key as incoming, not used nor published
+	_, err := v.AddSigner("stage", newKey)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrInvalidNetwork)
+	address, err := v.AddSigner("dev", newKey)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, address, qt.Equals, newSigner.Address())
+	qt.Assert(t, packageSigner(), qt.Equals, oldSigner)
+	qt.Assert(t, v.Addresses()["dev"], qt.DeepEquals, []evmcommon.Address{oldSigner})
+
+	// should sign with the new key while the old one is retiring
+	qt.Assert(t, v.RetireSigner("dev", oldSigner), qt.ErrorIs, faucet.ErrLastActiveKey)
+	qt.Assert(t, v.ActivateSigner("dev", newSigner.Address()), qt.IsNil)
+	qt.Assert(t, v.RetireSigner("dev", oldSigner), qt.IsNil)
+	qt.Assert(t, packageSigner(), qt.Equals, newSigner.Address())
+	qt.Assert(t, packageSigner(), qt.Equals, newSigner.Address())
+	qt.Assert(t, v.Addresses()["dev"], qt.DeepEquals, []evmcommon.Address{oldSigner, newSigner.Address()})
+
+	// should not remove the old key while its packages can be redeemed
+	qt.Assert(t, v.RemoveSigner("dev", oldSigner), qt.ErrorIs, faucet.ErrKeyPending)
+	v2 := faucet.NewVocdoni()
+	qt.Assert(t, v2.Init(context.Background(), vConfig), qt.IsNil)
+	_, err = v2.AddSigner("dev", newKey)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, v2.ActivateSigner("dev", newSigner.Address()), qt.IsNil)
+	qt.Assert(t, v2.RetireSigner("dev", oldSigner), qt.IsNil)
+	qt.Assert(t, v2.RemoveSigner("dev", oldSigner), qt.IsNil)
+	keys, err := v2.Keys("dev")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, keys, qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: newSigner.Address(), State: faucet.KeyStateActive},
+	})
+
+	// should restore the stored key states after a restart
+	store := storage.NewMemory()
+	restart := func(cfg *config.FaucetConfig) (*faucet.Vocdoni, error) {
+		v := faucet.NewVocdoni()
+		qt.Assert(t, v.Init(context.Background(), cfg), qt.IsNil)
+		return v, v.SetStorage(store)
+	}
+	v3, err := restart(vConfig)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = v3.AddSigner("dev", newKey)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, v3.ActivateSigner("dev", newSigner.Address()), qt.IsNil)
+	qt.Assert(t, v3.RetireSigner("dev", oldSigner), qt.IsNil)
+	// the keys added at runtime must be configured to be restored
+	_, err = restart(vConfig)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrNoActiveKey)
+	vConfig2 := *vConfig
+	vConfig2.VocdoniNetworkPrivKeys = map[string]string{
+		"dev": vConfig.VocdoniPrivKey + faucet.VocdoniKeysSeparator + newKey,
+	}
+	v4, err := restart(&vConfig2)
+	qt.Assert(t, err, qt.IsNil)
+	keys, err = v4.Keys("dev")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, keys, qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: oldSigner, State: faucet.KeyStateRetiring},
+		{Address: newSigner.Address(), State: faucet.KeyStateActive},
+	})
+	// the removed keys are not restored even if they are still configured
+	qt.Assert(t, v4.RemoveSigner("dev", oldSigner), qt.IsNil)
+	v5, err := restart(&vConfig2)
+	qt.Assert(t, err, qt.IsNil)
+	keys, err = v5.Keys("dev")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, keys, qt.DeepEquals, []*faucet.KeyStatus{
+		{Address: newSigner.Address(), State: faucet.KeyStateActive},
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), faucet.AuditLogFile)
+	audit, err := faucet.OpenAuditLog(path)
+	qt.Assert(t, err, qt.IsNil)
+	to := evmcommon.HexToAddress("0x01")
+	qt.Assert(t, audit.Record(&faucet.AuditEntry{
+		Kind:     faucet.AuditKindClaim,
+		Action:   "claim",
+		Network:  "dev",
+		Address:  &to,
+		Token:    faucet.TokenFingerprint("token"),
+		Decision: faucet.AuditDecisionGranted,
+		Amount:   "100",
+		Details:  map[string]string{"source": "test"},
+	}), qt.IsNil)
+	start := time.Now()
+	qt.Assert(t, audit.Record(&faucet.AuditEntry{
+		Kind:     faucet.AuditKindAdmin,
+		Action:   "lists.deny.add",
+		Address:  &to,
+		Decision: faucet.AuditDecisionGranted,
+	}), qt.IsNil)
+	qt.Assert(t, audit.Close(), qt.IsNil)
+
+	// should continue the chain after reopening
+	audit, err = faucet.OpenAuditLog(path)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, audit.Record(&faucet.AuditEntry{
+		Kind:     faucet.AuditKindClaim,
+		Action:   "claim",
+		Decision: faucet.AuditDecisionRefused,
+		Error:    "cooldown active",
+	}), qt.IsNil)
+	entries, err := audit.Entries(time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 3)
+	qt.Assert(t, entries[0].PrevHash, qt.HasLen, 0)
+	qt.Assert(t, entries[0].Token, qt.Not(qt.Equals), "token")
+	qt.Assert(t, entries[0].Details["source"], qt.Equals, "test")
+	qt.Assert(t, entries[2].Seq, qt.Equals, uint64(3))
+	qt.Assert(t, entries[2].PrevHash, qt.DeepEquals, entries[1].Hash)
+	seq, head := audit.Head()
+	qt.Assert(t, seq, qt.Equals, uint64(3))
+	qt.Assert(t, []byte(entries[2].Hash), qt.DeepEquals, head)
+	entries, err = audit.Entries(start, time.Time{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 2)
+	qt.Assert(t, audit.Close(), qt.IsNil)
+
+	// should discard an incomplete last entry
+	data, err := os.ReadFile(path)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, os.WriteFile(path, append(data, []byte(`{"seq":4,`)...), 0o600), qt.IsNil)
+	audit, err = faucet.OpenAuditLog(path)
+	qt.Assert(t, err, qt.IsNil)
+	seq, _ = audit.Head()
+	qt.Assert(t, seq, qt.Equals, uint64(3))
+	qt.Assert(t, audit.Close(), qt.IsNil)
+
+	// should detect the changes
+	tampered := bytes.Replace(data, []byte(`"amount":"100"`), []byte(`"amount":"1"`), 1)
+	qt.Assert(t, os.WriteFile(path, tampered, 0o600), qt.IsNil)
+	_, err = faucet.OpenAuditLog(path)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAuditChainBroken)
+	_, err = faucet.ReadAuditLog(bytes.NewReader(tampered), time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAuditChainBroken)
+	removed := bytes.SplitAfter(data, []byte("\n"))
+	_, err = faucet.ReadAuditLog(bytes.NewReader(bytes.Join([][]byte{removed[0], removed[2]}, nil)),
+		time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAuditChainBroken)
+}
+
+func TestStorageAuditLog(t *testing.T) {
+	store := storage.NewMemory()
+	replica1, err := faucet.NewStorageAuditLog(store)
+	qt.Assert(t, err, qt.IsNil)
+	replica2, err := faucet.NewStorageAuditLog(store)
+	qt.Assert(t, err, qt.IsNil)
+
+	// should chain the entries of both replicas
+	for i, audit := range []*faucet.AuditLog{replica1, replica2, replica1} {
+		qt.Assert(t, audit.Record(&faucet.AuditEntry{
+			Kind:     faucet.AuditKindClaim,
+			Action:   "claim",
+			Decision: faucet.AuditDecisionGranted,
+			Amount:   strconv.Itoa(i),
+		}), qt.IsNil)
+	}
+	entries, err := replica2.Entries(time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 3)
+	qt.Assert(t, entries[1].Amount, qt.Equals, "1")
+	qt.Assert(t, entries[2].PrevHash, qt.DeepEquals, entries[1].Hash)
+	seq, head := replica2.Head()
+	qt.Assert(t, seq, qt.Equals, uint64(3))
+	qt.Assert(t, []byte(entries[2].Hash), qt.DeepEquals, head)
+	qt.Assert(t, replica1.Close(), qt.IsNil)
+
+	// should detect the entries not following the chain
+	qt.Assert(t, store.AppendAudit(&storage.AuditRecord{Seq: 4, Data: []byte(`{"seq":4,"hash":"00"}`)}), qt.IsNil)
+	_, err = faucet.NewStorageAuditLog(store)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAuditChainBroken)
+	_, err = replica2.Entries(time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAuditChainBroken)
+}
+
+func TestAddressLists(t *testing.T) {
+	partner := evmcommon.HexToAddress("0x0000000000000000000000000000000000000001")
+	abuser := evmcommon.HexToAddress("0x0000000000000000000000000000000000000002")
+	other := evmcommon.HexToAddress("0x0000000000000000000000000000000000000003")
+	dir := t.TempDir()
+	allowFile := filepath.Join(dir, "allow")
+	denyFile := filepath.Join(dir, "deny")
+	qt.Assert(t, os.WriteFile(allowFile, []byte("# partners\n"+partner.Hex()+",lts\n"), 0o600), qt.IsNil)
+	qt.Assert(t, os.WriteFile(denyFile, []byte(abuser.Hex()+",,drained the faucet\n"), 0o600), qt.IsNil)
+	lists := faucet.NewAddressLists([]string{"lts"})
+	qt.Assert(t, lists.LoadFile(faucet.ListAllow, allowFile), qt.IsNil)
+	qt.Assert(t, lists.LoadFile(faucet.ListDeny, denyFile), qt.IsNil)
+	store := storage.NewMemory()
+	lists.SetStorage(store)
+
+	// should deny on all networks with the reason and restrict lts to the allowed
+	err := lists.Check("dev", abuser)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrAddressDenied)
+	qt.Assert(t, err, qt.ErrorMatches, ".*drained the faucet")
+	qt.Assert(t, lists.Check("dev", other), qt.IsNil)
+	qt.Assert(t, lists.Check("lts", partner), qt.IsNil)
+	qt.Assert(t, lists.Check("lts", other), qt.ErrorIs, faucet.ErrAddressNotAllowed)
+
+	// should manage the entries not in the files
+	qt.Assert(t, lists.Add(faucet.ListDeny, &faucet.ListEntry{Address: other, Network: "dev", Reason: "spam"}), qt.IsNil)
+	qt.Assert(t, lists.Check("dev", other), qt.ErrorIs, faucet.ErrAddressDenied)
+	qt.Assert(t, lists.Check("stage", other), qt.IsNil)
+	qt.Assert(t, lists.Remove(faucet.ListDeny, abuser, ""), qt.ErrorIs, faucet.ErrInvalidListEntry)
+	qt.Assert(t, lists.Remove(faucet.ListDeny, other, "stage"), qt.ErrorIs, faucet.ErrListEntryNotFound)
+	qt.Assert(t, lists.Add(faucet.ListAllow, &faucet.ListEntry{Address: other}), qt.IsNil)
+	qt.Assert(t, lists.Check("lts", other), qt.IsNil)
+
+	// should share the managed entries with the replicas using the storage
+	replica := faucet.NewAddressLists([]string{"lts"})
+	replica.SetStorage(store)
+	qt.Assert(t, replica.Check("dev", other), qt.ErrorIs, faucet.ErrAddressDenied)
+	qt.Assert(t, replica.Remove(faucet.ListDeny, other, "dev"), qt.IsNil)
+	qt.Assert(t, lists.Check("dev", other), qt.IsNil)
+	entries, err := replica.Entries(faucet.ListDeny)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 0)
+	entries, err = lists.Entries(faucet.ListAllow)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 2)
+	qt.Assert(t, entries[0].Source, qt.Equals, faucet.ListSourceAdmin)
+
+	// should import the entries kept in a database by the previous versions
+	database, err := pebbledb.New(db.Options{Path: filepath.Join(dir, "db")})
+	qt.Assert(t, err, qt.IsNil)
+	defer database.Close()
+	legacy, err := json.Marshal(&faucet.ListEntry{Address: abuser, Network: "dev", Source: faucet.ListSourceAdmin})
+	qt.Assert(t, err, qt.IsNil)
+	tx := database.WriteTx()
+	qt.Assert(t, tx.Set([]byte("list/deny:dev/"+strings.ToLower(abuser.Hex())), legacy), qt.IsNil)
+	qt.Assert(t, tx.Commit(), qt.IsNil)
+	imported, err := replica.ImportLists(database)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, imported, qt.Equals, 1)
+	imported, err = replica.ImportLists(database)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, imported, qt.Equals, 0)
+	qt.Assert(t, replica.Check("dev", abuser), qt.ErrorIs, faucet.ErrAddressDenied)
+	qt.Assert(t, replica.Check("stage", abuser), qt.IsNil)
+}
+
+// sendTx sends value from the given key to an address in the simulated backend
+func sendTx(t *testing.T, e *faucet.EVM, from *ethereum.SignKeys, to evmcommon.Address, value int64) {
+	backend := e.TestBackend().Backend
+	nonce, err := backend.PendingNonceAt(context.Background(), from.Address())
+	qt.Assert(t, err, qt.IsNil)
+	gasPrice, err := backend.SuggestGasPrice(context.Background())
+	qt.Assert(t, err, qt.IsNil)
+	tx, err := evmtypes.SignTx(evmtypes.NewTx(&evmtypes.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: new(big.Int).Mul(gasPrice, big.NewInt(2)),
+		Gas:      21000,
+		To:       &to,
+		Value:    big.NewInt(value),
+	}), evmtypes.NewEIP155Signer(big.NewInt(1337)), &from.Private)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, backend.SendTransaction(context.Background(), tx), qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+}
+
+func TestSybilRules(t *testing.T) {
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	// the test accounts are funded for paying their txs
+	eConfig1.EVMSendConditions.Balance = faucet.MAXUINT64
+	newAccount := func(e *faucet.EVM, funds int64) *ethereum.SignKeys {
+		account := &ethereum.SignKeys{}
+		qt.Assert(t, account.Generate(), qt.IsNil)
+		if funds > 0 {
+			funder := &ethereum.SignKeys{}
+			qt.Assert(t, funder.AddHexKey(e.TestBackend().PrivKey), qt.IsNil)
+			sendTx(t, e, funder, account.Address(), funds)
+		}
+		return account
+	}
+	balance := func(e *faucet.EVM, address evmcommon.Address) int64 {
+		balance, err := e.ClientBalanceAt(context.Background(), address, nil)
+		qt.Assert(t, err, qt.IsNil)
+		return balance.Int64()
+	}
+	const funds = 1000000000000000 // 0.001 ETH
+
+	// should refuse the fresh addresses
+	eConfig1.EVMSybil = config.SybilConfig{MinNonce: 1, FreshAction: "refuse"}
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	fresh := newAccount(e, 0)
+	_, err := e.SendTokens(context.Background(), fresh.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSuspiciousRecipient)
+	used := newAccount(e, funds)
+	sendTx(t, e, used, fresh.Address(), 1)
+	before := balance(e, used.Address())
+	_, err = e.SendTokens(context.Background(), used.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	qt.Assert(t, balance(e, used.Address())-before, qt.Equals, int64(100))
+
+	// should reduce the grants of the contracts
+	eConfig1.EVMSybil = config.SybilConfig{ContractAction: "reduce", ReducePercent: 30}
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	contract := deployReceiver(t, e)
+	_, err = e.SendTokensAmount(context.Background(), contract, 1000)
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	qt.Assert(t, balance(e, contract), qt.Equals, int64(300))
+	// should not overflow reducing the amounts with 18 decimals
+	_, err = e.SendTokensAmount(context.Background(), contract, 1000000000000000000) // 1 ETH
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	qt.Assert(t, balance(e, contract), qt.Equals, int64(300000000000000300))
+
+	// should refuse the addresses that forwarded a previous grant to a sink
+	sink := evmcommon.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	eConfig1.EVMSybil = config.SybilConfig{Sinks: []string{sink.Hex()}}
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	forwarder := newAccount(e, funds)
+	_, err = e.SendTokens(context.Background(), forwarder.Address())
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	sendTx(t, e, forwarder, sink, 100)
+	_, err = e.SendTokens(context.Background(), forwarder.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSuspiciousRecipient)
+
+	// should scan the blocks after the grant, whatever the blocks mined since
+	eConfig1.EVMSybil = config.SybilConfig{Sinks: []string{sink.Hex()}, SinkScanBlocks: 4}
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	store := storage.NewMemory()
+	qt.Assert(t, e.SetStorage(context.Background(), store), qt.IsNil)
+	forwarder = newAccount(e, funds)
+	other := newAccount(e, funds)
+	for _, account := range []*ethereum.SignKeys{forwarder, other} {
+		_, err = e.SendTokens(context.Background(), account.Address())
+		qt.Assert(t, err, qt.IsNil)
+		e.TestBackend().Commit() // save ethereum state
+	}
+	// the grants are kept in the storage, shared by the replicas
+	grant, err := store.RecipientGrant("evm/evmtest/" + strings.ToLower(forwarder.Address().Hex()))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, grant.Nonce, qt.Equals, uint64(0))
+	sendTx(t, e, forwarder, sink, 100)
+	sendTx(t, e, other, fresh.Address(), 100)
+	for i := 0; i < 5; i++ {
+		e.TestBackend().Commit()
+	}
+	sendTx(t, e, other, sink, 100)
+	_, err = e.SendTokens(context.Background(), forwarder.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSuspiciousRecipient)
+	// the forwards after the scanned blocks are not found
+	_, err = e.SendTokens(context.Background(), other.Address())
+	qt.Assert(t, err, qt.IsNil)
+
+	// should not init with invalid rules
+	eConfig1.EVMSybil = config.SybilConfig{MinNonce: 1, FreshAction: "ban"}
+	qt.Assert(t, faucet.NewEVM().InitForTest(context.Background(), &eConfig1), qt.IsNotNil)
+	eConfig1.EVMSybil = config.SybilConfig{Sinks: []string{"0x1234"}}
+	qt.Assert(t, faucet.NewEVM().InitForTest(context.Background(), &eConfig1), qt.IsNotNil)
+}
+
+func TestSendTokensWith(t *testing.T) {
+	e := faucet.NewEVM()
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+
+	// should send the grant amount
+	grant := &faucet.GrantSettings{Amount: 500, Threshold: 1000, Cooldown: time.Hour}
+	_, amount, err := e.SendTokensWith(context.Background(), toAddr.Address(), grant)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, amount, qt.Equals, uint64(500))
+	e.TestBackend().Commit() // save ethereum state
+	balance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Int64(), qt.Equals, int64(500))
+
+	// should refuse during the grant cooldown, and above the faucet threshold without the grant
+	_, _, err = e.SendTokensWith(context.Background(), toAddr.Address(), grant)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
+
+	// should refuse during the faucet cooldown the grants without a cooldown
+	eConfig1.EVMCooldown = time.Hour
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	qt.Assert(t, e.Cooldown(), qt.Equals, time.Hour)
+	other := randomAddress(t)
+	grant = &faucet.GrantSettings{Amount: 100, Threshold: 1000}
+	_, _, err = e.SendTokensWith(context.Background(), other, grant)
+	qt.Assert(t, err, qt.IsNil)
+	e.TestBackend().Commit() // save ethereum state
+	_, _, err = e.SendTokensWith(context.Background(), other, grant)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+}
+
+func TestSendSignerUnavailable(t *testing.T) {
+	e := faucet.NewEVM()
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	_, err := e.SendTokens(context.Background(), randomAddress(t))
+	qt.Assert(t, err, qt.IsNil)
+
+	// should stop waiting for the signer with a pending tx once the request is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = e.SendTokens(ctx, randomAddress(t))
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSignerUnavailable)
+
+	// should send again once the tx is mined
+	e.TestBackend().Commit() // save ethereum state
+	time.Sleep(500 * time.Millisecond)
+	_, err = e.SendTokens(context.Background(), randomAddress(t))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestEVMStorage(t *testing.T) {
+	store, err := storage.OpenKV(t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer store.Close()
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	qt.Assert(t, e.SetStorage(context.Background(), store), qt.IsNil)
+	toAddr := randomAddress(t)
+
+	// should record the grant and its pending tx
+	grant := &faucet.GrantSettings{Amount: 500, Threshold: 1000, Cooldown: time.Hour}
+	rctx, cancel := context.WithCancel(context.Background())
+	txHash, _, err := e.SendTokensWith(rctx, toAddr, grant)
+	qt.Assert(t, err, qt.IsNil)
+	txs, err := store.PendingTxs()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 1)
+	qt.Assert(t, txs[0].Hash, qt.Equals, *txHash)
+	qt.Assert(t, txs[0].To, qt.Equals, toAddr)
+	qt.Assert(t, txs[0].Amount, qt.Equals, uint64(500))
+
+	// should stop tracking the tx once mined, even if the request is done
+	cancel()
+	e.TestBackend().Commit() // save ethereum state
+	for i := 0; ; i++ {
+		txs, err = store.PendingTxs()
+		qt.Assert(t, err, qt.IsNil)
+		if len(txs) == 0 {
+			break
+		}
+		qt.Assert(t, i < 50, qt.IsTrue, qt.Commentf("tx %s still pending", txHash.Hex()))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// should keep the cooldown for another instance with the same storage
+	e2 := faucet.NewEVM()
+	qt.Assert(t, e2.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	qt.Assert(t, e2.SetStorage(context.Background(), store), qt.IsNil)
+	_, _, err = e2.SendTokensWith(context.Background(), toAddr, grant)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrCooldownActive)
+
+	// should release the grants that fail
+	full := randomAddress(t)
+	funder := &ethereum.SignKeys{}
+	qt.Assert(t, funder.AddHexKey(e2.TestBackend().PrivKey), qt.IsNil)
+	sendTx(t, e2, funder, full, 10)
+	_, _, err = e2.SendTokensWith(context.Background(), full, &faucet.GrantSettings{Threshold: 1, Cooldown: time.Hour})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
+	_, err = store.Claim("evm/evmtest/" + strings.ToLower(full.Hex()))
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+}
+
+func TestTopUp(t *testing.T) {
+	// should top the evm balance up to the target
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	eConfig1.EVMTopUpTarget = 1000
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	funder := &ethereum.SignKeys{}
+	qt.Assert(t, funder.AddHexKey(e.TestBackend().PrivKey), qt.IsNil)
+	toAddr := &ethereum.SignKeys{}
+	qt.Assert(t, toAddr.Generate(), qt.IsNil)
+	sendTx(t, e, funder, toAddr.Address(), 700)
+	_, amount, err := e.SendTokensWith(context.Background(), toAddr.Address(), &faucet.GrantSettings{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, amount, qt.Equals, uint64(300))
+	e.TestBackend().Commit() // save ethereum state
+	balance, err := e.ClientBalanceAt(context.Background(), toAddr.Address(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Int64(), qt.Equals, int64(1000))
+	_, err = e.SendTokens(context.Background(), toAddr.Address())
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
+
+	// should clamp the evm grant
+	eConfig1.EVMTopUpMaxGrant = 600
+	e = faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	_, amount, err = e.SendTokensWith(context.Background(), toAddr.Address(), &faucet.GrantSettings{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, amount, qt.Equals, uint64(600))
+
+	// should top the vocdoni balance up to the target
+	vConfig1 := *vConfig
+	vConfig1.VocdoniNetworks = []string{"dev", "lts"}
+	vConfig1.VocdoniTopUpTarget = 1000
+	vConfig1.VocdoniTopUpMaxGrant = 600
+	v := faucet.NewVocdoni()
+	qt.Assert(t, v.Init(context.Background(), &vConfig1), qt.IsNil)
+	full := randomAddress(t)
+	reader := &testAccountReader{balances: map[evmcommon.Address]uint64{
+		toAddr.Address(): 700,
+		full:             1000,
+	}}
+	v.SetAccountReader("dev", reader)
+	payload := &models.FaucetPayload{}
+	fpackage, err := v.GenerateFaucetPackage("dev", toAddr.Address())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(300))
+	fresh := randomAddress(t)
+	fpackage, err = v.GenerateFaucetPackage("dev", fresh)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(600))
+	_, err = v.GenerateFaucetPackage("dev", full)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrBalanceAboveThreshold)
+	// the given amounts are granted as is
+	fpackage, err = v.GenerateFaucetPackageAmount("dev", randomAddress(t), 42)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proto.Unmarshal(fpackage.Payload, payload), qt.IsNil)
+	qt.Assert(t, payload.Amount, qt.Equals, uint64(42))
+	// the balance is required
+	_, err = v.GenerateFaucetPackage("lts", fresh)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrTopUpUnavailable)
+}
+
+// randomAddress returns a new random address
+func randomAddress(t *testing.T) evmcommon.Address {
+	key := &ethereum.SignKeys{}
+	qt.Assert(t, key.Generate(), qt.IsNil)
+	return key.Address()
+}
+
+func TestEVMSignerLeases(t *testing.T) {
+	store := storage.NewMemory()
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	eConfig1.EVMTimeout = 5 * time.Second
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	e1 := faucet.NewEVM()
+	qt.Assert(t, e1.InitForTest(ctx1, &eConfig1), qt.IsNil)
+	qt.Assert(t, e1.SetStorage(ctx1, store), qt.IsNil)
+	qt.Assert(t, e1.EnableSignerLeases(ctx1, 300*time.Millisecond), qt.IsNil)
+	e2 := faucet.NewEVM()
+	qt.Assert(t, e2.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	qt.Assert(t, e2.SetStorage(context.Background(), store), qt.IsNil)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	qt.Assert(t, e2.EnableSignerLeases(ctx2, 300*time.Millisecond), qt.IsNil)
+
+	// should forward the send to the replica holding the signer lease
+	toAddr := randomAddress(t)
+	_, err := e2.SendTokens(context.Background(), toAddr)
+	qt.Assert(t, err, qt.IsNil)
+	e1.TestBackend().Commit() // save ethereum state
+	balance, err := e1.ClientBalanceAt(context.Background(), toAddr, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Uint64(), qt.Equals, eConfig1.EVMAmount)
+
+	// should take over the signer once its lease is released
+	cancel1()
+	time.Sleep(500 * time.Millisecond)
+	toAddr = randomAddress(t)
+	_, err = e2.SendTokens(context.Background(), toAddr)
+	qt.Assert(t, err, qt.IsNil)
+	e2.TestBackend().Commit() // save ethereum state
+	balance, err = e2.ClientBalanceAt(context.Background(), toAddr, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Uint64(), qt.Equals, eConfig1.EVMAmount)
+
+	// should refuse and release the grant if no replica takes the send
+	eConfig1.EVMTimeout = time.Second
+	lease := "evm/evmtest/signer/" + strings.ToLower(e2.Addresses()[0].Hex())
+	_, err = store.AcquireLease(lease, "gone", time.Hour)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrLeaseHeld)
+	cancel2()
+	time.Sleep(100 * time.Millisecond)
+	_, err = store.AcquireLease(lease, "gone", time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	e3 := faucet.NewEVM()
+	qt.Assert(t, e3.InitForTest(context.Background(), &eConfig1), qt.IsNil)
+	qt.Assert(t, e3.SetStorage(context.Background(), store), qt.IsNil)
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	qt.Assert(t, e3.EnableSignerLeases(ctx3, time.Minute), qt.IsNil)
+	toAddr = randomAddress(t)
+	_, _, err = e3.SendTokensWith(context.Background(), toAddr, &faucet.GrantSettings{Cooldown: time.Hour})
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSignerUnavailable)
+	_, err = store.Claim("evm/evmtest/" + strings.ToLower(toAddr.Hex()))
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+	_, err = store.TakeSend("evm/evmtest", "replica")
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+}
+
+// takenLeases is a storage whose leases are held by another replica once
+// taken is set
+type takenLeases struct {
+	storage.Storage
+	lock  sync.Mutex
+	taken bool
+}
+
+func (s *takenLeases) take() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.taken = true
+}
+
+func (s *takenLeases) AcquireLease(name, holder string, ttl time.Duration) (*storage.Lease, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.taken {
+		return nil, fmt.Errorf("%w: held by another replica", storage.ErrLeaseHeld)
+	}
+	return s.Storage.AcquireLease(name, holder, ttl)
+}
+
+func TestEVMSignerLeaseFencing(t *testing.T) {
+	store := &takenLeases{Storage: storage.NewMemory()}
+	eConfig1 := *eConfig
+	eConfig1.EVMNetwork = "evmtest"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := faucet.NewEVM()
+	qt.Assert(t, e.InitForTest(ctx, &eConfig1), qt.IsNil)
+	qt.Assert(t, e.SetStorage(ctx, store), qt.IsNil)
+	qt.Assert(t, e.EnableSignerLeases(ctx, time.Minute), qt.IsNil)
+
+	// should not send with a signer whose lease was taken over before renewing it
+	store.take()
+	toAddr := randomAddress(t)
+	_, err := e.SendTokens(context.Background(), toAddr)
+	qt.Assert(t, err, qt.ErrorIs, faucet.ErrSignerUnavailable)
+	e.TestBackend().Commit() // save ethereum state
+	balance, err := e.ClientBalanceAt(context.Background(), toAddr, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, balance.Uint64(), qt.Equals, uint64(0))
+	_, err = store.Claim("evm/evmtest/" + strings.ToLower(toAddr.Hex()))
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+}
@@ -0,0 +1,322 @@
+package faucet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+const (
+	// DefaultBaseFeeMultiplier is the multiplier applied to the base fee
+	// when no multiplier is configured
+	DefaultBaseFeeMultiplier = 2.0
+	// DefaultGasRetryAfter is the time after which a deferred send can be
+	// retried when no time is configured
+	DefaultGasRetryAfter = time.Minute
+)
+
+// ErrGasPriceTooHigh is returned if the network gas price is above the configured cap
+var ErrGasPriceTooHigh error = errors.New("gas price above the configured cap")
+
+// ErrSendDeferred is wrapped by the errors of the sends queued until the gas
+// price goes below the cap
+var ErrSendDeferred error = errors.New("send deferred until the gas price is below the cap")
+
+// DeferredSendError is returned, along with the granted amount, if the gas
+// price is above the cap and the policy defers the sends: the send is queued
+// and done once the price goes down, so the grant is kept. It wraps
+// ErrSendDeferred
+type DeferredSendError struct {
+	// ID identifies the queued send
+	ID string
+	// RetryAfter is the interval the queued sends are retried at
+	RetryAfter time.Duration
+	err        error
+}
+
+func (de *DeferredSendError) Error() string {
+	return fmt.Sprintf("%s, send %s retried every %s", de.err, de.ID, de.RetryAfter)
+}
+
+func (de *DeferredSendError) Unwrap() error {
+	return de.err
+}
+
+var (
+	// evmBaseFeeGauge last base fee seen on the EVM network
+	evmBaseFeeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "faucet",
+		Subsystem: "evm",
+		Name:      "base_fee_wei",
+		Help:      "Last base fee per gas seen on the EVM network",
+	})
+	// evmGasPriceGauge last fee per gas used (or attempted) by the faucet
+	evmGasPriceGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "faucet",
+		Subsystem: "evm",
+		Name:      "gas_price_wei",
+		Help:      "Last fee per gas computed by the EVM faucet",
+	})
+	// evmDeferredCounter sends deferred because the gas price is above the cap
+	evmDeferredCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faucet",
+		Subsystem: "evm",
+		Name:      "deferred_sends_total",
+		Help:      "EVM sends deferred because the gas price is above the cap",
+	})
+)
+
+// MetricsCollectors returns the prometheus collectors of the faucet package
+func MetricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{evmBaseFeeGauge, evmGasPriceGauge, evmDeferredCounter}
+}
+
+// gasPolicy defines how the EVM faucet prices its transactions
+type gasPolicy struct {
+	// maxFeePerGas upper bound for the fee per gas, in wei (0 means no cap)
+	maxFeePerGas *big.Int
+	// maxTipPerGas upper bound for the priority fee per gas, in wei (0 means no cap)
+	maxTipPerGas *big.Int
+	// baseFeeMultiplier multiplier applied to the base fee for computing the fee cap
+	baseFeeMultiplier float64
+	// deferOnHighGas if true the sends refused while the gas price is above
+	// the cap are queued until the price goes down
+	deferOnHighGas bool
+	// retryAfter interval the queued sends are retried at
+	retryAfter time.Duration
+
+	// lastBaseFee and lastGasPrice last values seen, for status purposes
+	lastBaseFee  atomic.Value
+	lastGasPrice atomic.Value
+	// deferred number of sends deferred because of the gas price
+	deferred int64
+}
+
+func newGasPolicy(maxFeePerGas, maxTipPerGas uint64,
+	baseFeeMultiplier float64,
+	deferOnHighGas bool,
+	retryAfter time.Duration,
+) *gasPolicy {
+	if baseFeeMultiplier <= 0 {
+		baseFeeMultiplier = DefaultBaseFeeMultiplier
+	}
+	if retryAfter == 0 {
+		retryAfter = DefaultGasRetryAfter
+	}
+	return &gasPolicy{
+		maxFeePerGas:      new(big.Int).SetUint64(maxFeePerGas),
+		maxTipPerGas:      new(big.Int).SetUint64(maxTipPerGas),
+		baseFeeMultiplier: baseFeeMultiplier,
+		deferOnHighGas:    deferOnHighGas,
+		retryAfter:        retryAfter,
+	}
+}
+
+// gasPrices contains the fees to use in a transaction, if the network
+// does not support EIP-1559 only gasPrice is set
+type gasPrices struct {
+	gasPrice,
+	gasFeeCap,
+	gasTipCap *big.Int
+}
+
+// prices computes the fees to use according to the policy, returns
+// ErrGasPriceTooHigh if the transaction cannot be priced below the cap
+func (gp *gasPolicy) prices(ctx context.Context, backend evmBackend, feeMarket bool) (*gasPrices, error) {
+	if !feeMarket {
+		gasPrice, err := backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get gas price: %w", err)
+		}
+		gp.lastGasPrice.Store(new(big.Int).Set(gasPrice))
+		evmGasPriceGauge.Set(float64(gasPrice.Uint64()))
+		if gp.maxFeePerGas.Sign() > 0 && gasPrice.Cmp(gp.maxFeePerGas) > 0 {
+			return nil, fmt.Errorf("%w: gas price %s > %s", ErrGasPriceTooHigh, gasPrice, gp.maxFeePerGas)
+		}
+		return &gasPrices{gasPrice: gasPrice}, nil
+	}
+	header, err := backend.HeaderByNumber(ctx, nil) // nil means latest block
+	if err != nil {
+		return nil, fmt.Errorf("cannot get latest block header: %w", err)
+	}
+	baseFee := header.BaseFee
+	gp.lastBaseFee.Store(new(big.Int).Set(baseFee))
+	evmBaseFeeGauge.Set(float64(baseFee.Uint64()))
+	tip, err := backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get gas tip cap: %w", err)
+	}
+	if gp.maxTipPerGas.Sign() > 0 && tip.Cmp(gp.maxTipPerGas) > 0 {
+		tip = new(big.Int).Set(gp.maxTipPerGas)
+	}
+	// feeCap = baseFee * multiplier + tip
+	feeCap, _ := new(big.Float).Mul(
+		new(big.Float).SetInt(baseFee),
+		big.NewFloat(gp.baseFeeMultiplier),
+	).Int(nil)
+	feeCap.Add(feeCap, tip)
+	gp.lastGasPrice.Store(new(big.Int).Set(feeCap))
+	evmGasPriceGauge.Set(float64(feeCap.Uint64()))
+	if gp.maxFeePerGas.Sign() > 0 && feeCap.Cmp(gp.maxFeePerGas) > 0 {
+		// the transaction can still be included if the cap covers the
+		// current base fee and the tip
+		if new(big.Int).Add(baseFee, tip).Cmp(gp.maxFeePerGas) > 0 {
+			return nil, fmt.Errorf("%w: base fee %s + tip %s > %s",
+				ErrGasPriceTooHigh, baseFee, tip, gp.maxFeePerGas)
+		}
+		feeCap = new(big.Int).Set(gp.maxFeePerGas)
+	}
+	return &gasPrices{gasPrice: feeCap, gasFeeCap: feeCap, gasTipCap: tip}, nil
+}
+
+// GasStatus represents the gas policy of the EVM faucet and the last
+// gas values seen on the network
+type GasStatus struct {
+	MaxFeePerGas      string  `json:"maxFeePerGas"`
+	MaxTipPerGas      string  `json:"maxTipPerGas"`
+	BaseFeeMultiplier float64 `json:"baseFeeMultiplier"`
+	DeferOnHighGas    bool    `json:"deferOnHighGas"`
+	RetryAfter        string  `json:"retryAfter"`
+	LastBaseFee       string  `json:"lastBaseFee,omitempty"`
+	LastGasPrice      string  `json:"lastGasPrice,omitempty"`
+	DeferredSends     int64   `json:"deferredSends"`
+}
+
+func (gp *gasPolicy) status() *GasStatus {
+	gs := &GasStatus{
+		MaxFeePerGas:      gp.maxFeePerGas.String(),
+		MaxTipPerGas:      gp.maxTipPerGas.String(),
+		BaseFeeMultiplier: gp.baseFeeMultiplier,
+		DeferOnHighGas:    gp.deferOnHighGas,
+		RetryAfter:        gp.retryAfter.String(),
+		DeferredSends:     atomic.LoadInt64(&gp.deferred),
+	}
+	if baseFee, ok := gp.lastBaseFee.Load().(*big.Int); ok {
+		gs.LastBaseFee = baseFee.String()
+	}
+	if gasPrice, ok := gp.lastGasPrice.Load().(*big.Int); ok {
+		gs.LastGasPrice = gasPrice.String()
+	}
+	return gs
+}
+
+// deferredQueueName returns the storage queue of the sends deferred while
+// the gas price is above the cap
+func (e *EVM) deferredQueueName() string {
+	return e.queueName() + "/deferred"
+}
+
+// deferSend queues the send refused by the gas price, returns the id of
+// the queued send
+func (e *EVM) deferSend(to evmcommon.Address, amount uint64, cause error) (string, error) {
+	id := uuid.NewString()
+	if err := e.storage.EnqueueSend(&storage.QueuedSend{
+		ID:       id,
+		Queue:    e.deferredQueueName(),
+		To:       to,
+		Amount:   amount,
+		QueuedAt: time.Now(),
+	}); err != nil {
+		return "", fmt.Errorf("cannot defer send: %w", err)
+	}
+	atomic.AddInt64(&e.gasPolicy.deferred, 1)
+	evmDeferredCounter.Inc()
+	log.Warnf("send %s of %d tokens to %s deferred: %s", id, amount, to.Hex(), cause)
+	return id, nil
+}
+
+// ServeDeferredSends retries the deferred sends at the interval of the gas
+// policy until the context is done. The sends are done while the gas price
+// is below the cap, so any replica able to send serves the queue
+func (e *EVM) ServeDeferredSends(ctx context.Context) {
+	ticker := time.NewTicker(e.gasPolicy.retryAfter)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.sendDeferred(ctx)
+		}
+	}
+}
+
+// sendDeferred sends the deferred sends, oldest first, until the queue is
+// empty or the gas price is above the cap again
+func (e *EVM) sendDeferred(ctx context.Context) {
+	backend, err := e.backend(ctx)
+	if err != nil {
+		log.Warnf("cannot serve deferred sends: %s", err)
+		return
+	}
+	for !e.forwards() {
+		if !e.gasBelowCap(ctx, backend) {
+			return
+		}
+		send, err := e.storage.TakeSend(e.deferredQueueName(), e.holder)
+		if errors.Is(err, storage.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Warnf("cannot take deferred send: %s", err)
+			return
+		}
+		txHash, err := e.send(ctx, send.To, send.Amount)
+		if errors.Is(err, ErrGasPriceTooHigh) {
+			// the price went up meanwhile, the send is queued again
+			// keeping its turn
+			send.Holder = ""
+			if err := e.storage.DeleteSend(send.ID); err != nil {
+				log.Warnf("cannot queue deferred send %s again: %s", send.ID, err)
+			} else if err := e.storage.EnqueueSend(send); err != nil {
+				log.Warnf("cannot queue deferred send %s again: %s", send.ID, err)
+			}
+			return
+		}
+		if derr := e.storage.DeleteSend(send.ID); derr != nil {
+			log.Warnf("cannot delete deferred send %s: %s", send.ID, derr)
+		}
+		if err != nil {
+			log.Warnf("deferred send %s of %d tokens to %s failed: %s",
+				send.ID, send.Amount, send.To.Hex(), err)
+			continue
+		}
+		log.Infof("deferred send %s of %d tokens to %s sent in tx %s",
+			send.ID, send.Amount, send.To.Hex(), txHash.Hex())
+		if e.sybil != nil {
+			rctx, cancel := context.WithTimeout(ctx, e.timeout)
+			e.sybil.recordGrant(rctx, backend, e.storage, e.claimKey(send.To), send.To)
+			cancel()
+		}
+	}
+}
+
+// gasBelowCap returns true if the sends can be priced below the cap
+func (e *EVM) gasBelowCap(ctx context.Context, backend evmBackend) bool {
+	tctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	feeMarket, err := e.feeMarketSupported(tctx, backend)
+	if err != nil {
+		log.Warnf("cannot serve deferred sends: %s", err)
+		return false
+	}
+	_, err = e.gasPolicy.prices(tctx, backend, feeMarket)
+	if errors.Is(err, ErrGasPriceTooHigh) {
+		log.Debugf("deferred sends waiting: %s", err)
+		return false
+	}
+	if err != nil {
+		log.Warnf("cannot serve deferred sends: %s", err)
+		return false
+	}
+	return true
+}
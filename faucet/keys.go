@@ -0,0 +1,381 @@
+package faucet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// KeyState represents the state of a faucet signer key during its rotation
+type KeyState string
+
+const (
+	// KeyStateIncoming the key is added but not used for new sends yet
+	KeyStateIncoming KeyState = "incoming"
+	// KeyStateActive the key is used for new sends
+	KeyStateActive KeyState = "active"
+	// KeyStateRetiring the key is not used for new sends but it is still
+	// tracked until its pending txs or packages are settled
+	KeyStateRetiring KeyState = "retiring"
+	// KeyStateRemoved the key is removed, it is only stored so the key is not
+	// restored from the configuration after a restart
+	KeyStateRemoved KeyState = "removed"
+)
+
+var (
+	// ErrKeyNotFound error returned if a signer key is not part of the faucet
+	ErrKeyNotFound error = errors.New("signer key not found")
+	// ErrKeyExists error returned if a signer key is already part of the faucet
+	ErrKeyExists error = errors.New("signer key already exists")
+	// ErrInvalidKeyState error returned if a key rotation step is not allowed
+	// from the current key state
+	ErrInvalidKeyState error = errors.New("invalid signer key state")
+	// ErrLastActiveKey error returned if the last active key is retired
+	ErrLastActiveKey error = errors.New("cannot retire the last active signer key")
+	// ErrKeyPending error returned if a retiring key with pending txs
+	// or packages is removed
+	ErrKeyPending error = errors.New("signer key has pending operations")
+	// ErrNoActiveKey error returned if no signer key is active after
+	// restoring the stored key states
+	ErrNoActiveKey error = errors.New("no active signer key")
+)
+
+// KeyStatus represents a faucet signer key and its rotation state
+type KeyStatus struct {
+	Address evmcommon.Address `json:"address"`
+	State   KeyState          `json:"state"`
+}
+
+// newSigner returns a signer in the given state for the given hex private key
+func newSigner(privKey string, state KeyState) (*Signer, error) {
+	s := new(ethereum.SignKeys)
+	if err := s.AddHexKey(privKey); err != nil {
+		return nil, fmt.Errorf("cannot import key: %w", err)
+	}
+	return &Signer{SignKeys: s, Taken: make(chan bool, 1), State: state}, nil
+}
+
+// findSigner returns the index of the signer with the given address
+func findSigner(signers []*Signer, address evmcommon.Address) (int, error) {
+	for i, signer := range signers {
+		if signer.SignKeys.Address() == address {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", ErrKeyNotFound, address.Hex())
+}
+
+// storeKeyState persists the rotation state of a signer key of the given
+// scope (i.e evm/sepolia), so it survives the restarts
+func storeKeyState(s storage.Storage, scope string, address evmcommon.Address, state KeyState) error {
+	if err := s.SetSignerKey(&storage.SignerKey{
+		Scope:     scope,
+		Address:   address,
+		State:     string(state),
+		UpdatedAt: time.Now(),
+	}); err != nil {
+		return fmt.Errorf("cannot store the state of the signer key %s: %w", address.Hex(), err)
+	}
+	return nil
+}
+
+// restoreKeyStates applies the rotation states stored for the scope to the
+// configured signers and returns the ones not removed. The private keys are
+// not stored, so the keys added at runtime must be added to the
+// configuration too, or they are reported and skipped. At least one signer
+// must remain active
+func restoreKeyStates(s storage.Storage, scope string, signers []*Signer) ([]*Signer, error) {
+	keys, err := s.SignerKeys(scope)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the signer keys of %s: %w", scope, err)
+	}
+	states := make(map[evmcommon.Address]KeyState)
+	for _, key := range keys {
+		switch state := KeyState(key.State); state {
+		case KeyStateIncoming, KeyStateActive, KeyStateRetiring, KeyStateRemoved:
+			states[key.Address] = state
+		default:
+			return nil, fmt.Errorf("%w: %s is %s", ErrInvalidKeyState, key.Address.Hex(), key.State)
+		}
+	}
+	configured := make(map[evmcommon.Address]bool)
+	restored := []*Signer{}
+	active := 0
+	for _, signer := range signers {
+		address := signer.SignKeys.Address()
+		configured[address] = true
+		if state, ok := states[address]; ok {
+			if state == KeyStateRemoved {
+				log.Warnf("signer key %s of %s was removed, remove it from the configuration", address.Hex(), scope)
+				continue
+			}
+			signer.State = state
+		}
+		if signer.State == KeyStateActive {
+			active++
+		}
+		restored = append(restored, signer)
+	}
+	for _, key := range keys {
+		if !configured[key.Address] && KeyState(key.State) != KeyStateRemoved {
+			log.Warnf("signer key %s of %s is %s but it is not configured, add it to the configuration",
+				key.Address.Hex(), scope, key.State)
+		}
+	}
+	if active == 0 {
+		return nil, fmt.Errorf("%w for %s after restoring the stored key states", ErrNoActiveKey, scope)
+	}
+	return restored, nil
+}
+
+// transitionSigner moves the signer with the given address from one state to
+// another, refusing to retire the last active signer. The new state is
+// stored before it is applied
+func transitionSigner(signers []*Signer, address evmcommon.Address, from, to KeyState,
+	store func(evmcommon.Address, KeyState) error,
+) error {
+	index, err := findSigner(signers, address)
+	if err != nil {
+		return err
+	}
+	if signers[index].State != from {
+		return fmt.Errorf("%w: %s is %s, expected %s",
+			ErrInvalidKeyState, address.Hex(), signers[index].State, from)
+	}
+	if from == KeyStateActive {
+		active := 0
+		for _, signer := range signers {
+			if signer.State == KeyStateActive {
+				active++
+			}
+		}
+		if active == 1 {
+			return fmt.Errorf("%w: %s", ErrLastActiveKey, address.Hex())
+		}
+	}
+	if err := store(address, to); err != nil {
+		return err
+	}
+	signers[index].State = to
+	return nil
+}
+
+// keysStatus returns the rotation state of the given signers
+func keysStatus(signers []*Signer) []*KeyStatus {
+	keys := []*KeyStatus{}
+	for _, signer := range signers {
+		keys = append(keys, &KeyStatus{Address: signer.SignKeys.Address(), State: signer.State})
+	}
+	return keys
+}
+
+// keysScope returns the storage scope of the EVM faucet signer keys
+func (e *EVM) keysScope() string {
+	return "evm/" + e.network
+}
+
+// storeKeyState persists the rotation state of an EVM faucet signer key
+func (e *EVM) storeKeyState(address evmcommon.Address, state KeyState) error {
+	return storeKeyState(e.storage, e.keysScope(), address, state)
+}
+
+// AddSigner adds a new signer to the EVM faucet in the incoming state
+func (e *EVM) AddSigner(privKey string) (evmcommon.Address, error) {
+	signer, err := newSigner(privKey, KeyStateIncoming)
+	if err != nil {
+		return evmcommon.Address{}, err
+	}
+	address := signer.SignKeys.Address()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, err := findSigner(e.signers, address); err == nil {
+		return evmcommon.Address{}, fmt.Errorf("%w: %s", ErrKeyExists, address.Hex())
+	}
+	if err := e.storeKeyState(address, KeyStateIncoming); err != nil {
+		return evmcommon.Address{}, err
+	}
+	e.signers = append(e.signers, signer)
+	return address, nil
+}
+
+// ActivateSigner moves an incoming signer of the EVM faucet to active
+func (e *EVM) ActivateSigner(address evmcommon.Address) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := transitionSigner(e.signers, address, KeyStateIncoming, KeyStateActive, e.storeKeyState); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetireSigner moves an active signer of the EVM faucet to retiring, it is
+// not used for new sends but its pending tx is still tracked
+func (e *EVM) RetireSigner(address evmcommon.Address) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := transitionSigner(e.signers, address, KeyStateActive, KeyStateRetiring, e.storeKeyState); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveSigner removes a retiring signer of the EVM faucet, it is refused
+// while the signer has a pending tx
+func (e *EVM) RemoveSigner(address evmcommon.Address) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	index, err := findSigner(e.signers, address)
+	if err != nil {
+		return err
+	}
+	if e.signers[index].State != KeyStateRetiring {
+		return fmt.Errorf("%w: %s is %s, expected %s",
+			ErrInvalidKeyState, address.Hex(), e.signers[index].State, KeyStateRetiring)
+	}
+	if len(e.signers[index].Taken) > 0 {
+		return fmt.Errorf("%w: %s has a pending tx", ErrKeyPending, address.Hex())
+	}
+	if err := e.storeKeyState(address, KeyStateRemoved); err != nil {
+		return err
+	}
+	e.signers = append(e.signers[:index:index], e.signers[index+1:]...)
+	return nil
+}
+
+// Keys returns the EVM faucet signers and their rotation state
+func (e *EVM) Keys() []*KeyStatus {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return keysStatus(e.signers)
+}
+
+// vocdoniNetwork returns the canonical name of the given Vocdoni network,
+// it must be one of the faucet networks
+func (v *Vocdoni) vocdoniNetwork(network string) (string, error) {
+	chainSpecs, err := vocdoniSpecsFor(network)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := v.signers[chainSpecs.network]; !ok {
+		return "", fmt.Errorf("%w: %s is not enabled", ErrInvalidNetwork, network)
+	}
+	return chainSpecs.network, nil
+}
+
+// keysScope returns the storage scope of the signer keys of the given Vocdoni network
+func keysScope(network string) string {
+	return "vocdoni/" + network
+}
+
+// storeKeyState returns the function persisting the rotation state of a
+// signer key of the given Vocdoni network
+func (v *Vocdoni) storeKeyState(network string) func(evmcommon.Address, KeyState) error {
+	return func(address evmcommon.Address, state KeyState) error {
+		return storeKeyState(v.storage, keysScope(network), address, state)
+	}
+}
+
+// AddSigner adds a new signer to the given Vocdoni network in the incoming state
+func (v *Vocdoni) AddSigner(network, privKey string) (evmcommon.Address, error) {
+	signer, err := newSigner(privKey, KeyStateIncoming)
+	if err != nil {
+		return evmcommon.Address{}, err
+	}
+	address := signer.SignKeys.Address()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if network, err = v.vocdoniNetwork(network); err != nil {
+		return evmcommon.Address{}, err
+	}
+	if _, err := findSigner(v.signers[network], address); err == nil {
+		return evmcommon.Address{}, fmt.Errorf("%w: %s", ErrKeyExists, address.Hex())
+	}
+	if err := v.storeKeyState(network)(address, KeyStateIncoming); err != nil {
+		return evmcommon.Address{}, err
+	}
+	v.signers[network] = append(v.signers[network], signer)
+	return address, nil
+}
+
+// ActivateSigner moves an incoming signer of the given Vocdoni network to active
+func (v *Vocdoni) ActivateSigner(network string, address evmcommon.Address) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	network, err := v.vocdoniNetwork(network)
+	if err != nil {
+		return err
+	}
+	if err := transitionSigner(v.signers[network], address, KeyStateIncoming, KeyStateActive,
+		v.storeKeyState(network)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetireSigner moves an active signer of the given Vocdoni network to retiring,
+// it does not sign new packages but the packages it signed are still tracked
+func (v *Vocdoni) RetireSigner(network string, address evmcommon.Address) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	network, err := v.vocdoniNetwork(network)
+	if err != nil {
+		return err
+	}
+	if err := transitionSigner(v.signers[network], address, KeyStateActive, KeyStateRetiring,
+		v.storeKeyState(network)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveSigner removes a retiring signer of the given Vocdoni network, if the
+// packages are tracked it is refused while a package it signed can be redeemed
+func (v *Vocdoni) RemoveSigner(network string, address evmcommon.Address) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	network, err := v.vocdoniNetwork(network)
+	if err != nil {
+		return err
+	}
+	signers := v.signers[network]
+	index, err := findSigner(signers, address)
+	if err != nil {
+		return err
+	}
+	if signers[index].State != KeyStateRetiring {
+		return fmt.Errorf("%w: %s is %s, expected %s",
+			ErrInvalidKeyState, address.Hex(), signers[index].State, KeyStateRetiring)
+	}
+	if v.packages != nil {
+		pending, err := v.packages.list(PackageStatusIssued, PackageStatusExpired)
+		if err != nil {
+			return err
+		}
+		for _, pr := range pending {
+			if pr.Network == network && evmcommon.BytesToAddress(pr.Signer) == address {
+				return fmt.Errorf("%w: %s signed the package %d", ErrKeyPending, address.Hex(), pr.Identifier)
+			}
+		}
+	}
+	if err := v.storeKeyState(network)(address, KeyStateRemoved); err != nil {
+		return err
+	}
+	v.signers[network] = append(signers[:index:index], signers[index+1:]...)
+	return nil
+}
+
+// Keys returns the signers of the given Vocdoni network and their rotation state
+func (v *Vocdoni) Keys(network string) ([]*KeyStatus, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	network, err := v.vocdoniNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	return keysStatus(v.signers[network]), nil
+}
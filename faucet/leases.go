@@ -0,0 +1,303 @@
+package faucet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+const (
+	// DefaultSignerLeaseTTL default validity of the EVM signer leases
+	DefaultSignerLeaseTTL = 30 * time.Second
+	// queuePollInterval interval for polling the forwarded sends
+	queuePollInterval = time.Second
+)
+
+// ErrSignerUnavailable error wrapping the sends not done as no signer is
+// available, such as the forwarded sends not done by any replica holding a
+// signer lease
+var ErrSignerUnavailable error = errors.New("no signer available")
+
+// forwardedErrors are the errors kept by the forwarded sends, the sends are
+// forwarded through the storage so only the message of their errors is kept
+var forwardedErrors = []error{ErrFaucetEmpty, ErrGasLimitTooHigh, ErrGasPriceTooHigh, ErrSignerUnavailable}
+
+// leaseName returns the storage lease name of the signer
+func (e *EVM) leaseName(signer evmcommon.Address) string {
+	return "evm/" + e.network + "/signer/" + strings.ToLower(signer.Hex())
+}
+
+// queueName returns the storage queue of the sends forwarded to the signers
+func (e *EVM) queueName() string {
+	return "evm/" + e.network
+}
+
+// EnableSignerLeases coordinates the faucet replicas sharing the storage and
+// the signer keys, so the nonces of a key are only used by one of them: a
+// signer is only used by the replica holding its lease in the storage, and
+// the replicas without any lease forward their sends through the storage
+// queue to the ones with a lease. The leases are renewed and the queue is
+// served until the context is done. It must be called after SetStorage
+func (e *EVM) EnableSignerLeases(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: signer lease %s", ErrInvalidTimeout, ttl)
+	}
+	e.lock.Lock()
+	e.leaseTTL = ttl
+	e.leases = make(map[evmcommon.Address]time.Time)
+	e.lock.Unlock()
+	log.Infof("signer leases enabled for replica %s", e.holder)
+	e.renewLeases()
+	go e.coordinate(ctx)
+	return nil
+}
+
+// coordinate renews the signer leases and serves the forwarded sends until
+// the context is done, then the leases are released
+func (e *EVM) coordinate(ctx context.Context) {
+	renew := time.NewTicker(e.leaseTTL / 3)
+	defer renew.Stop()
+	poll := time.NewTicker(queuePollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.ReleaseSignerLeases()
+			return
+		case <-renew.C:
+			e.renewLeases()
+		case <-poll.C:
+			e.serveQueue(ctx)
+		}
+	}
+}
+
+// renewLeases acquires or renews the leases of the active signers and
+// releases the ones of the signers not active anymore. If the storage fails
+// the leases held are kept until they expire
+func (e *EVM) renewLeases() {
+	for _, signer := range e.Signers() {
+		address := signer.SignKeys.Address()
+		name := e.leaseName(address)
+		if signer.State != KeyStateActive {
+			if _, ok := e.leaseExpiration(address); !ok {
+				continue
+			}
+			if err := e.storage.ReleaseLease(name, e.holder); err != nil {
+				log.Warnf("cannot release lease of signer %s: %s", address.Hex(), err)
+				continue
+			}
+			e.setLease(address, time.Time{})
+			continue
+		}
+		lease, err := e.storage.AcquireLease(name, e.holder, e.leaseTTL)
+		switch {
+		case errors.Is(err, storage.ErrLeaseHeld):
+			e.setLease(address, time.Time{})
+		case err != nil:
+			log.Warnf("cannot renew lease of signer %s: %s", address.Hex(), err)
+		default:
+			if _, ok := e.leaseExpiration(address); !ok {
+				log.Infof("lease of signer %s acquired", address.Hex())
+			}
+			e.setLease(address, lease.ExpiresAt)
+		}
+	}
+}
+
+// leaseExpiration returns the expiration of the lease of the signer, false
+// if the replica does not hold it or it has expired
+func (e *EVM) leaseExpiration(signer evmcommon.Address) (time.Time, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	expiresAt, ok := e.leases[signer]
+	return expiresAt, ok && time.Now().Before(expiresAt)
+}
+
+// fence renews the lease of the signer right before sending with it, so a
+// replica whose lease was taken over meanwhile, i.e. after a pause longer
+// than the lease, does not use the nonces of the signer. It returns false if
+// the lease is held by another replica
+func (e *EVM) fence(signer evmcommon.Address) (bool, error) {
+	if e.leaseTTL == 0 {
+		return true, nil
+	}
+	lease, err := e.storage.AcquireLease(e.leaseName(signer), e.holder, e.leaseTTL)
+	if errors.Is(err, storage.ErrLeaseHeld) {
+		log.Warnf("lease of signer %s lost: %s", signer.Hex(), err)
+		e.setLease(signer, time.Time{})
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: cannot renew lease of signer %s: %s", ErrSignerUnavailable, signer.Hex(), err)
+	}
+	e.setLease(signer, lease.ExpiresAt)
+	return true, nil
+}
+
+// setLease records the expiration of the lease of the signer, the zero
+// time means the replica does not hold it
+func (e *EVM) setLease(signer evmcommon.Address, expiresAt time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if expiresAt.IsZero() {
+		delete(e.leases, signer)
+		return
+	}
+	e.leases[signer] = expiresAt
+}
+
+// ReleaseSignerLeases releases the signer leases held by the replica, so
+// other replicas can use the signers without waiting for their expiration
+func (e *EVM) ReleaseSignerLeases() {
+	if e.leaseTTL == 0 {
+		return
+	}
+	for _, signer := range e.Signers() {
+		address := signer.SignKeys.Address()
+		if _, ok := e.leaseExpiration(address); !ok {
+			continue
+		}
+		if err := e.storage.ReleaseLease(e.leaseName(address), e.holder); err != nil {
+			log.Warnf("cannot release lease of signer %s: %s", address.Hex(), err)
+			continue
+		}
+		e.setLease(address, time.Time{})
+	}
+}
+
+// sendingSigners returns the active signers the replica can use for new
+// sends, the ones with a lease if the leases are enabled
+func (e *EVM) sendingSigners() []*Signer {
+	signers := e.activeSigners()
+	if e.leaseTTL == 0 {
+		return signers
+	}
+	leased := []*Signer{}
+	for _, signer := range signers {
+		if _, ok := e.leaseExpiration(signer.SignKeys.Address()); ok {
+			leased = append(leased, signer)
+		}
+	}
+	return leased
+}
+
+// forwards returns true if the sends must be forwarded to other replicas,
+// as the replica holds no signer lease
+func (e *EVM) forwards() bool {
+	return e.leaseTTL > 0 && len(e.sendingSigners()) == 0
+}
+
+// serveQueue takes as many forwarded sends as signers of the replica are
+// free and sends them
+func (e *EVM) serveQueue(ctx context.Context) {
+	free := 0
+	for _, signer := range e.sendingSigners() {
+		if len(signer.Taken) == 0 {
+			free++
+		}
+	}
+	for ; free > 0; free-- {
+		send, err := e.storage.TakeSend(e.queueName(), e.holder)
+		if errors.Is(err, storage.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Warnf("cannot take forwarded send: %s", err)
+			return
+		}
+		log.Infof("sending forwarded send %s of %d tokens to %s", send.ID, send.Amount, send.To.Hex())
+		go e.sendQueued(ctx, send)
+	}
+}
+
+// sendQueued sends a forwarded send and records its result, the send fails
+// with ErrSignerUnavailable if the replica loses its leases or stops before
+// a signer is free
+func (e *EVM) sendQueued(ctx context.Context, send *storage.QueuedSend) {
+	txHash, err := e.send(ctx, send.To, send.Amount)
+	send.TxHash = txHash
+	if err != nil {
+		send.Error = err.Error()
+	}
+	send.DoneAt = time.Now()
+	if err := e.storage.CompleteSend(send); err != nil {
+		log.Warnf("cannot complete forwarded send %s: %s", send.ID, err)
+	}
+}
+
+// forward sends the amount through the storage queue to the replicas holding
+// the signer leases and waits for the result. The send is canceled if no
+// replica takes it during the timeout, once taken its result is waited for
+// up to five timeouts. Pending is true if the send was taken but its result
+// is still unknown, so it can still be done
+func (e *EVM) forward(ctx context.Context, to evmcommon.Address, amount uint64) (*evmcommon.Hash, bool, error) {
+	id := uuid.NewString()
+	if err := e.storage.EnqueueSend(&storage.QueuedSend{
+		ID:       id,
+		Queue:    e.queueName(),
+		To:       to,
+		Amount:   amount,
+		QueuedAt: time.Now(),
+	}); err != nil {
+		return nil, false, fmt.Errorf("cannot forward send: %w", err)
+	}
+	log.Debugf("send %s to %s forwarded", id, to.Hex())
+	takeDeadline := time.Now().Add(e.timeout)
+	var doneDeadline time.Time
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		var send *storage.QueuedSend
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			send, err = e.storage.QueuedSend(id)
+		}
+		switch {
+		case err != nil:
+		case !send.DoneAt.IsZero():
+			if err := e.storage.DeleteSend(id); err != nil {
+				log.Warnf("cannot delete forwarded send %s: %s", id, err)
+			}
+			if send.Error != "" {
+				return nil, false, forwardedError(send.Error)
+			}
+			return send.TxHash, false, nil
+		case send.Holder == "" && time.Now().After(takeDeadline):
+			err = fmt.Errorf("%w: send not taken by any replica", ErrSignerUnavailable)
+		case send.Holder != "" && doneDeadline.IsZero():
+			doneDeadline = time.Now().Add(5 * e.timeout)
+			continue
+		case send.Holder != "" && time.Now().After(doneDeadline):
+			return nil, true, fmt.Errorf("%w: send %s taken by %s not finished", ErrSignerUnavailable, id, send.Holder)
+		default:
+			continue
+		}
+		// the send is canceled, unless a replica took it meanwhile
+		if cerr := e.storage.CancelSend(id); cerr != nil {
+			return nil, true, fmt.Errorf("%w, send %s cannot be canceled: %s", err, id, cerr)
+		}
+		return nil, false, err
+	}
+}
+
+// forwardedError returns the error of a forwarded send with its message,
+// wrapping the faucet error it starts with, if any
+func forwardedError(message string) error {
+	for _, err := range forwardedErrors {
+		if strings.HasPrefix(message, err.Error()) {
+			return fmt.Errorf("%w%s", err, strings.TrimPrefix(message, err.Error()))
+		}
+	}
+	return errors.New(message)
+}
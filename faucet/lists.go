@@ -0,0 +1,348 @@
+package faucet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// ListKind is the kind of an address list
+type ListKind string
+
+const (
+	// ListAllow the addresses allowed to claim on the restricted networks
+	ListAllow ListKind = "allow"
+	// ListDeny the addresses that cannot claim
+	ListDeny ListKind = "deny"
+
+	// ListSourceFile the entry is loaded from a static file
+	ListSourceFile = "file"
+	// ListSourceAdmin the entry is managed with the admin API
+	ListSourceAdmin = "admin"
+
+	// legacyListPrefix is the database prefix of the entries kept by the
+	// previous faucet versions
+	legacyListPrefix = "list/"
+)
+
+var (
+	// ErrAddressDenied error returned if an address is in the deny list
+	ErrAddressDenied error = errors.New("address denied")
+	// ErrAddressNotAllowed error returned if a network is restricted and the address is not in the allow list
+	ErrAddressNotAllowed error = errors.New("address not allowed on this network")
+	// ErrListEntryNotFound error returned if an entry is not in the list
+	ErrListEntryNotFound error = errors.New("list entry not found")
+	// ErrInvalidListEntry error returned if an entry is not valid or cannot be managed
+	ErrInvalidListEntry error = errors.New("invalid list entry")
+)
+
+// ListEntry represents an address in an allow or deny list
+type ListEntry struct {
+	// Address is the listed address
+	Address evmcommon.Address `json:"address"`
+	// Network is the network the entry applies to, all networks if empty
+	Network string `json:"network,omitempty"`
+	// Reason is the reason of the entry, recorded for the denies
+	Reason string `json:"reason,omitempty"`
+	// Source is the origin of the entry (file or admin)
+	Source string `json:"source,omitempty"`
+	// AddedAt is the time the entry was added
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// key returns the key of the entry in its list
+func (le *ListEntry) key() string {
+	return le.Network + "/" + strings.ToLower(le.Address.Hex())
+}
+
+// AddressLists represents the allow and deny lists of the recipient addresses,
+// the restricted networks only accept the addresses of the allow list
+type AddressLists struct {
+	restricted map[string]bool
+	// lists the entries of the files
+	lists map[ListKind]map[string]*ListEntry
+	// storage keeps the entries managed with the admin API, in memory
+	// unless another storage is set
+	storage storage.Storage
+	lock    sync.RWMutex
+}
+
+// NewAddressLists returns empty address lists, the given networks are
+// restricted to the addresses of the allow list
+func NewAddressLists(restrictedNetworks []string) *AddressLists {
+	al := &AddressLists{
+		restricted: make(map[string]bool),
+		lists: map[ListKind]map[string]*ListEntry{
+			ListAllow: make(map[string]*ListEntry),
+			ListDeny:  make(map[string]*ListEntry),
+		},
+		storage: storage.NewMemory(),
+	}
+	for _, network := range restrictedNetworks {
+		al.restricted[network] = true
+	}
+	return al
+}
+
+// SetStorage keeps the entries managed with the admin API in the given
+// storage, shared by the replicas if the storage is. It must be called
+// before managing the entries
+func (al *AddressLists) SetStorage(store storage.Storage) {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	al.storage = store
+}
+
+// LoadFile adds the entries of the given file to the list, with one entry per
+// line as address[,network[,reason]]. The empty lines and the ones starting
+// with # are ignored
+func (al *AddressLists) LoadFile(kind ListKind, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := readListEntries(f)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	list, ok := al.lists[kind]
+	if !ok {
+		return fmt.Errorf("%w: unknown list %s", ErrInvalidListEntry, kind)
+	}
+	for _, entry := range entries {
+		list[entry.key()] = entry
+	}
+	return nil
+}
+
+// readListEntries reads the entries of a list file
+func readListEntries(r io.Reader) ([]*ListEntry, error) {
+	entries := []*ListEntry{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, ",", 3)
+		address := strings.TrimSpace(fields[0])
+		if !evmcommon.IsHexAddress(address) {
+			return nil, fmt.Errorf("line %d: %w: invalid address %q", line, ErrInvalidListEntry, address)
+		}
+		entry := &ListEntry{
+			Address: evmcommon.HexToAddress(address),
+			Source:  ListSourceFile,
+			AddedAt: time.Now(),
+		}
+		if len(fields) > 1 {
+			entry.Network = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			entry.Reason = strings.TrimSpace(fields[2])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ImportLists imports the entries managed with the admin API kept in the
+// given database by the previous faucet versions, the entries already
+// stored are kept. It returns the number of entries imported
+func (al *AddressLists) ImportLists(database db.Database) (int, error) {
+	legacy := []*storage.ListEntry{}
+	var err error
+	if ierr := database.Iterate([]byte(legacyListPrefix), func(key, value []byte) bool {
+		kind, entryKey, _ := strings.Cut(string(key), ":")
+		entry := &ListEntry{}
+		if err = json.Unmarshal(value, entry); err != nil {
+			return false
+		}
+		legacy = append(legacy, &storage.ListEntry{List: kind, Key: entryKey, Data: value})
+		return true
+	}); ierr != nil {
+		return 0, ierr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot decode list entry: %w", err)
+	}
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+	imported := 0
+	for _, entry := range legacy {
+		if _, ok := al.lists[ListKind(entry.List)]; !ok {
+			continue
+		}
+		_, err := al.storage.ListEntry(entry.List, entry.Key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return imported, err
+		}
+		if err := al.storage.SetListEntry(entry); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// stored returns the entry of the list with the given key managed with the
+// admin API, nil if there is none
+func (al *AddressLists) stored(kind ListKind, key string) (*ListEntry, error) {
+	stored, err := al.storage.ListEntry(string(kind), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &ListEntry{}
+	if err := json.Unmarshal(stored.Data, entry); err != nil {
+		return nil, fmt.Errorf("cannot decode list entry: %w", err)
+	}
+	return entry, nil
+}
+
+// Add adds or replaces an entry managed with the admin API
+func (al *AddressLists) Add(kind ListKind, entry *ListEntry) error {
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+	list, ok := al.lists[kind]
+	if !ok {
+		return fmt.Errorf("%w: unknown list %s", ErrInvalidListEntry, kind)
+	}
+	if (entry.Address == evmcommon.Address{}) {
+		return fmt.Errorf("%w: missing address", ErrInvalidListEntry)
+	}
+	if _, ok := list[entry.key()]; ok {
+		return fmt.Errorf("%w: %s is in the %s list file", ErrInvalidListEntry, entry.Address.Hex(), kind)
+	}
+	added := *entry
+	added.Source = ListSourceAdmin
+	added.AddedAt = time.Now()
+	data, err := json.Marshal(&added)
+	if err != nil {
+		return err
+	}
+	return al.storage.SetListEntry(&storage.ListEntry{List: string(kind), Key: added.key(), Data: data})
+}
+
+// Remove removes an entry managed with the admin API
+func (al *AddressLists) Remove(kind ListKind, address evmcommon.Address, network string) error {
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+	list, ok := al.lists[kind]
+	if !ok {
+		return fmt.Errorf("%w: unknown list %s", ErrInvalidListEntry, kind)
+	}
+	key := (&ListEntry{Address: address, Network: network}).key()
+	if _, ok := list[key]; ok {
+		return fmt.Errorf("%w: %s is in the %s list file", ErrInvalidListEntry, address.Hex(), kind)
+	}
+	err := al.storage.DeleteListEntry(string(kind), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrListEntryNotFound, address.Hex())
+	}
+	return err
+}
+
+// Entries returns the entries of the given list, the ones of the files and
+// the ones managed with the admin API
+func (al *AddressLists) Entries(kind ListKind) ([]*ListEntry, error) {
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+	entries := []*ListEntry{}
+	for _, entry := range al.lists[kind] {
+		e := *entry
+		entries = append(entries, &e)
+	}
+	stored, err := al.storage.ListEntries(string(kind))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		// the entries of the files take precedence
+		if _, ok := al.lists[kind][s.Key]; ok {
+			continue
+		}
+		entry := &ListEntry{}
+		if err := json.Unmarshal(s.Data, entry); err != nil {
+			return nil, fmt.Errorf("cannot decode list entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key() < entries[j].key() })
+	return entries, nil
+}
+
+// Restricted returns the networks restricted to the addresses of the allow list
+func (al *AddressLists) Restricted() []string {
+	networks := []string{}
+	for network := range al.restricted {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// Check returns an error if the address cannot claim on the given network,
+// because it is denied or the network is restricted and it is not allowed
+func (al *AddressLists) Check(network string, address evmcommon.Address) error {
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+	lookup := func(kind ListKind) (*ListEntry, error) {
+		for _, scope := range []string{network, ""} {
+			key := (&ListEntry{Address: address, Network: scope}).key()
+			if entry, ok := al.lists[kind][key]; ok {
+				return entry, nil
+			}
+			entry, err := al.stored(kind, key)
+			if err != nil || entry != nil {
+				return entry, err
+			}
+		}
+		return nil, nil
+	}
+	entry, err := lookup(ListDeny)
+	if err != nil {
+		return fmt.Errorf("cannot check the deny list: %w", err)
+	}
+	if entry != nil {
+		log.Infow("denied address claim",
+			"address", address.Hex(),
+			"network", network,
+			"reason", entry.Reason,
+		)
+		if entry.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrAddressDenied, entry.Reason)
+		}
+		return ErrAddressDenied
+	}
+	if !al.restricted[network] {
+		return nil
+	}
+	entry, err = lookup(ListAllow)
+	if err != nil {
+		return fmt.Errorf("cannot check the allow list: %w", err)
+	}
+	if entry == nil {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, network)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package faucet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// PackageStatus represents the status of an issued Vocdoni faucet package
+type PackageStatus string
+
+const (
+	// PackageStatusIssued the package is issued and can be redeemed
+	PackageStatusIssued PackageStatus = "issued"
+	// PackageStatusRedeemed the package has been redeemed on the Vocdoni network
+	PackageStatusRedeemed PackageStatus = "redeemed"
+	// PackageStatusExpired the package has not been redeemed before its
+	// expiration, the payload has no expiration so it can still be redeemed
+	PackageStatusExpired PackageStatus = "expired"
+)
+
+var (
+	// ErrPackageNotFound error returned if an issued package cannot be found
+	ErrPackageNotFound error = errors.New("faucet package not found")
+	// ErrPendingPackage error returned if an address has still an unredeemed package
+	ErrPendingPackage error = errors.New("address has an unredeemed faucet package")
+	// ErrPackagesNotTracked error returned if the faucet packages are not persisted
+	ErrPackagesNotTracked error = errors.New("faucet packages are not tracked")
+	// ErrAccountNotFound error returned if a Vocdoni account does not exist
+	ErrAccountNotFound error = errors.New("account not found")
+)
+
+// PackageRecord represents a faucet package issued by the Vocdoni faucet
+type PackageRecord struct {
+	// Identifier is the identifier of the faucet package payload
+	Identifier uint64 `json:"identifier,string"`
+	// Network is the Vocdoni network the package was issued for
+	Network string `json:"network"`
+	// Signer is the faucet address that signed the package
+	Signer types.HexBytes `json:"signer"`
+	// To is the recipient of the package
+	To types.HexBytes `json:"to"`
+	// Amount is the amount of the package
+	Amount uint64 `json:"amount"`
+	// IssuedAt is the issuance time
+	IssuedAt time.Time `json:"issuedAt"`
+	// ExpiresAt is the expiration time, nil if the package does not expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// RedeemedAt is the time the package was seen redeemed
+	RedeemedAt *time.Time `json:"redeemedAt,omitempty"`
+	// BaseBalance is the balance of the recipient when the package was issued
+	BaseBalance uint64 `json:"baseBalance"`
+}
+
+// Status returns the current status of the package
+func (pr *PackageRecord) Status() PackageStatus {
+	switch {
+	case pr.RedeemedAt != nil:
+		return PackageStatusRedeemed
+	case pr.ExpiresAt != nil && time.Now().After(*pr.ExpiresAt):
+		return PackageStatusExpired
+	default:
+		return PackageStatusIssued
+	}
+}
+
+// legacyPackagePrefix is the prefix of the packages in the database used
+// before the packages were kept in the storage
+const legacyPackagePrefix = "pkg/"
+
+// packageStore persists the faucet packages issued by the Vocdoni faucet in
+// the storage, shared by the replicas using the same storage
+type packageStore struct {
+	storage storage.Storage
+}
+
+// set stores the given package, added or updated
+func (ps *packageStore) set(pr *PackageRecord) error {
+	data, err := json.Marshal(pr)
+	if err != nil {
+		return err
+	}
+	return ps.storage.SetPackage(&storage.Package{
+		Identifier: pr.Identifier,
+		Network:    pr.Network,
+		To:         evmcommon.BytesToAddress(pr.To),
+		IssuedAt:   pr.IssuedAt,
+		Data:       data,
+	})
+}
+
+// decode returns the record of the given stored package
+func (*packageStore) decode(pkg *storage.Package, err error) (*PackageRecord, error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrPackageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	pr := &PackageRecord{}
+	if err := json.Unmarshal(pkg.Data, pr); err != nil {
+		return nil, fmt.Errorf("cannot decode faucet package %d: %w", pkg.Identifier, err)
+	}
+	return pr, nil
+}
+
+// get returns the package with the given identifier
+func (ps *packageStore) get(identifier uint64) (*PackageRecord, error) {
+	return ps.decode(ps.storage.Package(identifier))
+}
+
+// last returns the last package issued to the given address on the given network
+func (ps *packageStore) last(network string, address evmcommon.Address) (*PackageRecord, error) {
+	return ps.decode(ps.storage.LastPackage(network, address))
+}
+
+// list returns all the packages with any of the given statuses
+func (ps *packageStore) list(statuses ...PackageStatus) ([]*PackageRecord, error) {
+	stored, err := ps.storage.Packages()
+	if err != nil {
+		return nil, err
+	}
+	packages := []*PackageRecord{}
+	for _, pkg := range stored {
+		pr, err := ps.decode(pkg, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range statuses {
+			if pr.Status() == status {
+				packages = append(packages, pr)
+				break
+			}
+		}
+	}
+	return packages, nil
+}
+
+// ImportPackages adds to the tracked packages the ones of the given database,
+// where they were kept before being tracked in the storage. The packages
+// already tracked are kept, it returns the number of packages imported
+func (v *Vocdoni) ImportPackages(database db.Database) (int, error) {
+	if v.packages == nil {
+		return 0, ErrPackagesNotTracked
+	}
+	legacy := []*PackageRecord{}
+	var err error
+	if ierr := database.Iterate([]byte(legacyPackagePrefix), func(_, value []byte) bool {
+		pr := &PackageRecord{}
+		if err = json.Unmarshal(value, pr); err != nil {
+			return false
+		}
+		legacy = append(legacy, pr)
+		return true
+	}); ierr != nil {
+		return 0, ierr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot decode faucet package: %w", err)
+	}
+	imported := 0
+	for _, pr := range legacy {
+		_, err := v.packages.get(pr.Identifier)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrPackageNotFound) {
+			return imported, err
+		}
+		if err := v.packages.set(pr); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// VocdoniAccountReader reads the balance of the accounts of a Vocdoni network
+type VocdoniAccountReader interface {
+	// Balance returns the balance of the given address or ErrAccountNotFound
+	Balance(ctx context.Context, address evmcommon.Address) (uint64, error)
+}
+
+// vocdoniAPIAccountReader is a VocdoniAccountReader using the Vocdoni API
+type vocdoniAPIAccountReader struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewVocdoniAPIAccountReader returns a VocdoniAccountReader using the
+// Vocdoni API available at the given endpoint
+func NewVocdoniAPIAccountReader(endpoint string) VocdoniAccountReader {
+	return &vocdoniAPIAccountReader{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// Balance implements VocdoniAccountReader
+func (r *vocdoniAPIAccountReader) Balance(ctx context.Context, address evmcommon.Address) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s/accounts/%x", r.endpoint, address.Bytes()),
+		nil,
+	)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: %s (%d)", ErrAccountNotFound, address.Hex(), resp.StatusCode)
+	}
+	account := struct {
+		Balance uint64 `json:"balance"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return 0, fmt.Errorf("cannot decode account: %w", err)
+	}
+	return account.Balance, nil
+}
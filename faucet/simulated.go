@@ -0,0 +1,328 @@
+package faucet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	goethereum "github.com/ethereum/go-ethereum"
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	evmtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	evmparams "github.com/ethereum/go-ethereum/params"
+)
+
+// SimulatedBackend is an in memory EVM chain used for testing, the sent txs
+// are kept in a pending block that is mined on Commit.
+// Unlike the go-ethereum simulated backend, which always uses the global
+// AllEthashProtocolChanges config, it is built from its own chain config
+// so a network without some forks can be simulated
+type SimulatedBackend struct {
+	lock         sync.Mutex
+	database     ethdb.Database
+	blockchain   *core.BlockChain
+	config       *evmparams.ChainConfig
+	pendingBlock *evmtypes.Block
+	pendingState *state.StateDB
+}
+
+// newSimulatedBackend creates a simulated chain from the given config,
+// with a genesis block funding the alloc accounts
+func newSimulatedBackend(
+	chainConfig *evmparams.ChainConfig,
+	alloc core.GenesisAlloc,
+	gasLimit uint64,
+) (*SimulatedBackend, error) {
+	database := rawdb.NewMemoryDatabase()
+	genesis := core.Genesis{Config: chainConfig, GasLimit: gasLimit, Alloc: alloc}
+	if _, err := genesis.Commit(database); err != nil {
+		return nil, fmt.Errorf("cannot commit the genesis: %w", err)
+	}
+	blockchain, err := core.NewBlockChain(database, nil, chainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the blockchain: %w", err)
+	}
+	b := &SimulatedBackend{
+		database:   database,
+		blockchain: blockchain,
+		config:     chainConfig,
+	}
+	if err := b.rollback(blockchain.CurrentBlock()); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Commit mines the pending txs as a new block and returns its hash
+func (b *SimulatedBackend) Commit() evmcommon.Hash {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, err := b.blockchain.InsertChain([]*evmtypes.Block{b.pendingBlock}); err != nil {
+		// the pending block is built by the backend itself
+		panic(err)
+	}
+	hash := b.pendingBlock.Hash()
+	if err := b.rollback(b.pendingBlock); err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// rollback starts an empty pending block on top of the given parent
+func (b *SimulatedBackend) rollback(parent *evmtypes.Block) error {
+	blocks, _ := core.GenerateChain(b.config, parent, ethash.NewFaker(), b.database, 1,
+		func(int, *core.BlockGen) {})
+	pendingState, err := state.New(blocks[0].Root(), b.blockchain.StateCache(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot open the pending state: %w", err)
+	}
+	b.pendingBlock = blocks[0]
+	b.pendingState = pendingState
+	return nil
+}
+
+// ChainID returns the chain id of the simulated chain
+func (b *SimulatedBackend) ChainID() *big.Int {
+	return b.config.ChainID
+}
+
+// stateAt returns the state at the given block number, nil means latest
+func (b *SimulatedBackend) stateAt(number *big.Int) (*state.StateDB, error) {
+	if number == nil {
+		return b.blockchain.State()
+	}
+	block := b.blockchain.GetBlockByNumber(number.Uint64())
+	if block == nil {
+		return nil, goethereum.NotFound
+	}
+	return b.blockchain.StateAt(block.Root())
+}
+
+// BalanceAt returns the wei balance of the account
+func (b *SimulatedBackend) BalanceAt(_ context.Context,
+	account evmcommon.Address, number *big.Int) (*big.Int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stateDB, err := b.stateAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return stateDB.GetBalance(account), nil
+}
+
+// StorageAt returns the value of the key in the account storage
+func (b *SimulatedBackend) StorageAt(_ context.Context,
+	account evmcommon.Address, key evmcommon.Hash, number *big.Int) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stateDB, err := b.stateAt(number)
+	if err != nil {
+		return nil, err
+	}
+	value := stateDB.GetState(account, key)
+	return value[:], nil
+}
+
+// CodeAt returns the code of the account
+func (b *SimulatedBackend) CodeAt(_ context.Context,
+	account evmcommon.Address, number *big.Int) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stateDB, err := b.stateAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return stateDB.GetCode(account), nil
+}
+
+// NonceAt returns the nonce of the account
+func (b *SimulatedBackend) NonceAt(_ context.Context,
+	account evmcommon.Address, number *big.Int) (uint64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stateDB, err := b.stateAt(number)
+	if err != nil {
+		return 0, err
+	}
+	return stateDB.GetNonce(account), nil
+}
+
+// PendingNonceAt returns the nonce of the account including the pending txs
+func (b *SimulatedBackend) PendingNonceAt(_ context.Context, account evmcommon.Address) (uint64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.pendingState.GetNonce(account), nil
+}
+
+// TransactionByHash returns the tx, looking at the pending block first
+func (b *SimulatedBackend) TransactionByHash(_ context.Context,
+	hash evmcommon.Hash) (*evmtypes.Transaction, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if tx := b.pendingBlock.Transaction(hash); tx != nil {
+		return tx, true, nil
+	}
+	if tx, _, _, _ := rawdb.ReadTransaction(b.database, hash); tx != nil {
+		return tx, false, nil
+	}
+	return nil, false, goethereum.NotFound
+}
+
+// TransactionReceipt returns the receipt of a mined tx
+func (b *SimulatedBackend) TransactionReceipt(_ context.Context, hash evmcommon.Hash) (*evmtypes.Receipt, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	receipt, _, _, _ := rawdb.ReadReceipt(b.database, hash, b.config)
+	if receipt == nil {
+		return nil, goethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// BlockByNumber returns a mined block, nil means latest
+func (b *SimulatedBackend) BlockByNumber(_ context.Context, number *big.Int) (*evmtypes.Block, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if number == nil {
+		return b.blockchain.CurrentBlock(), nil
+	}
+	block := b.blockchain.GetBlockByNumber(number.Uint64())
+	if block == nil {
+		return nil, goethereum.NotFound
+	}
+	return block, nil
+}
+
+// HeaderByNumber returns the header of a mined block, nil means latest
+func (b *SimulatedBackend) HeaderByNumber(_ context.Context, number *big.Int) (*evmtypes.Header, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if number == nil {
+		return b.blockchain.CurrentHeader(), nil
+	}
+	header := b.blockchain.GetHeaderByNumber(number.Uint64())
+	if header == nil {
+		return nil, goethereum.NotFound
+	}
+	return header, nil
+}
+
+// SuggestGasPrice returns the base fee of the pending block, or 1 if the
+// chain has no fee market
+func (b *SimulatedBackend) SuggestGasPrice(context.Context) (*big.Int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if baseFee := b.pendingBlock.BaseFee(); baseFee != nil {
+		return baseFee, nil
+	}
+	return big.NewInt(1), nil
+}
+
+// SuggestGasTipCap returns a tip of 1, there are no other txs to compete with
+func (b *SimulatedBackend) SuggestGasTipCap(context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+// EstimateGas returns the lowest gas limit the call succeeds with on top
+// of the pending state
+func (b *SimulatedBackend) EstimateGas(_ context.Context, call goethereum.CallMsg) (uint64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lo, hi := evmparams.TxGas-1, b.pendingBlock.GasLimit()
+	if call.Gas >= evmparams.TxGas {
+		hi = call.Gas
+	}
+	limit := hi
+	failed := func(gas uint64) (bool, error) {
+		call.Gas = gas
+		result, err := b.call(call, b.pendingState.Copy())
+		if errors.Is(err, core.ErrIntrinsicGas) {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		return result.Failed(), nil
+	}
+	for lo+1 < hi {
+		mid := (hi + lo) / 2
+		midFailed, err := failed(mid)
+		if err != nil {
+			return 0, err
+		}
+		if midFailed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if hi == limit {
+		limitFailed, err := failed(hi)
+		if err != nil {
+			return 0, err
+		}
+		if limitFailed {
+			return 0, fmt.Errorf("gas required exceeds allowance (%d)", limit)
+		}
+	}
+	return hi, nil
+}
+
+// call executes the call on top of the given state, the caller is funded
+// so only the execution itself can fail
+func (b *SimulatedBackend) call(call goethereum.CallMsg, stateDB *state.StateDB) (*core.ExecutionResult, error) {
+	header := b.pendingBlock.Header()
+	if call.GasPrice == nil {
+		call.GasPrice = new(big.Int)
+	}
+	call.GasFeeCap, call.GasTipCap = call.GasPrice, call.GasPrice
+	if call.Value == nil {
+		call.Value = new(big.Int)
+	}
+	stateDB.SetBalance(call.From, math.MaxBig256)
+	msg := evmtypes.NewMessage(call.From, call.To, stateDB.GetNonce(call.From), call.Value, call.Gas,
+		call.GasPrice, call.GasFeeCap, call.GasTipCap, call.Data, call.AccessList, true)
+	evm := vm.NewEVM(core.NewEVMBlockContext(header, b.blockchain, nil), core.NewEVMTxContext(msg),
+		stateDB, b.config, vm.Config{NoBaseFee: true})
+	return core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(math.MaxUint64)).TransitionDb()
+}
+
+// SendTransaction adds the tx to the pending block, the tx is refused if it
+// could not be included in the block, e.g. if the chain does not support
+// its type or its fee is below the base fee
+func (b *SimulatedBackend) SendTransaction(_ context.Context, tx *evmtypes.Transaction) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	parent := b.blockchain.GetBlockByHash(b.pendingBlock.ParentHash())
+	if parent == nil {
+		return fmt.Errorf("cannot find the pending block parent")
+	}
+	header := b.pendingBlock.Header()
+	gasPool := new(core.GasPool).AddGas(header.GasLimit - header.GasUsed)
+	if _, err := core.ApplyTransaction(b.config, b.blockchain, &header.Coinbase, gasPool,
+		b.pendingState.Copy(), header, tx, &header.GasUsed, vm.Config{}); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
+	}
+	blocks, _ := core.GenerateChain(b.config, parent, ethash.NewFaker(), b.database, 1,
+		func(_ int, block *core.BlockGen) {
+			for _, pending := range b.pendingBlock.Transactions() {
+				block.AddTxWithChain(b.blockchain, pending)
+			}
+			block.AddTxWithChain(b.blockchain, tx)
+		})
+	pendingState, err := state.New(blocks[0].Root(), b.blockchain.StateCache(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot open the pending state: %w", err)
+	}
+	b.pendingBlock = blocks[0]
+	b.pendingState = pendingState
+	return nil
+}
@@ -0,0 +1,254 @@
+package faucet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	evmcommon "github.com/ethereum/go-ethereum/common"
+	evmtypes "github.com/ethereum/go-ethereum/core/types"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+)
+
+// SybilAction is the action applied to the recipients matching a sybil rule
+type SybilAction string
+
+const (
+	// SybilActionRefuse the recipient is not funded
+	SybilActionRefuse SybilAction = "refuse"
+	// SybilActionReduce the recipient is funded with a reduced amount
+	SybilActionReduce SybilAction = "reduce"
+
+	// SybilRuleFresh matches the recipients below the minimum nonce
+	SybilRuleFresh = "fresh"
+	// SybilRuleContract matches the contract recipients
+	SybilRuleContract = "contract"
+	// SybilRuleSink matches the recipients that forwarded a previous grant to a sink
+	SybilRuleSink = "sink"
+
+	// DefaultSybilReducePercent percent of the amount sent to the reduced
+	// recipients when no percent is configured
+	DefaultSybilReducePercent = 50
+	// DefaultSybilSinkScanBlocks maximum number of blocks after a grant
+	// scanned looking for forwards to the sinks when no maximum is configured
+	DefaultSybilSinkScanBlocks = 100
+)
+
+// ErrSuspiciousRecipient error wrapping recipients refused by the sybil rules errors
+var ErrSuspiciousRecipient error = errors.New("recipient refused by the sybil rules")
+
+// sybilRules inspects the on-chain history of the EVM recipients before
+// sending, refusing or reducing the grants of the suspicious ones. The last
+// grants to the recipients are kept in the storage of the faucet
+type sybilRules struct {
+	// minNonce minimum nonce of the recipients, 0 disables the fresh rule
+	minNonce    uint64
+	freshAction SybilAction
+	// contractAction action for the contracts, the rule is disabled if empty
+	contractAction SybilAction
+	// sinks known sink addresses, the sink rule is disabled if empty
+	sinks          map[evmcommon.Address]bool
+	sinkAction     SybilAction
+	sinkScanBlocks uint64
+	// reducePercent percent of the amount sent to the reduced recipients
+	reducePercent uint64
+}
+
+// newSybilRules returns the sybil rules of the given config, nil if no rule is enabled
+func newSybilRules(cfg *config.SybilConfig) (*sybilRules, error) {
+	action := func(name, value string, defaultAction SybilAction) (SybilAction, error) {
+		switch SybilAction(value) {
+		case "":
+			return defaultAction, nil
+		case SybilActionRefuse, SybilActionReduce:
+			return SybilAction(value), nil
+		}
+		return "", fmt.Errorf("invalid %s rule action %q", name, value)
+	}
+	sr := &sybilRules{
+		minNonce:       cfg.MinNonce,
+		sinks:          make(map[evmcommon.Address]bool),
+		sinkScanBlocks: cfg.SinkScanBlocks,
+		reducePercent:  cfg.ReducePercent,
+	}
+	var err error
+	if sr.freshAction, err = action(SybilRuleFresh, cfg.FreshAction, SybilActionReduce); err != nil {
+		return nil, err
+	}
+	if sr.contractAction, err = action(SybilRuleContract, cfg.ContractAction, ""); err != nil {
+		return nil, err
+	}
+	if sr.sinkAction, err = action(SybilRuleSink, cfg.SinkAction, SybilActionRefuse); err != nil {
+		return nil, err
+	}
+	for _, sink := range cfg.Sinks {
+		if !evmcommon.IsHexAddress(sink) {
+			return nil, fmt.Errorf("invalid sink address %q", sink)
+		}
+		sr.sinks[evmcommon.HexToAddress(sink)] = true
+	}
+	if sr.minNonce == 0 && sr.contractAction == "" && len(sr.sinks) == 0 {
+		return nil, nil
+	}
+	if sr.sinkScanBlocks == 0 {
+		sr.sinkScanBlocks = DefaultSybilSinkScanBlocks
+	}
+	if sr.reducePercent == 0 {
+		sr.reducePercent = DefaultSybilReducePercent
+	}
+	if sr.reducePercent > 100 {
+		return nil, fmt.Errorf("invalid reduce percent %d", sr.reducePercent)
+	}
+	return sr, nil
+}
+
+// check applies the rules to the recipient, whose grants are stored with
+// the given key, and returns the amount to send. If a refusing rule matches
+// ErrSuspiciousRecipient is returned. The rules reducing the amount are
+// applied once, whatever the number of matches
+func (sr *sybilRules) check(ctx context.Context,
+	backend evmBackend,
+	store storage.Storage,
+	key string,
+	chainID int,
+	to evmcommon.Address,
+	amount uint64,
+) (uint64, error) {
+	nonce, err := backend.NonceAt(ctx, to, nil) // nil means latest block
+	if err != nil {
+		return 0, fmt.Errorf("cannot get recipient nonce: %w", err)
+	}
+	reduce := false
+	// decide logs the decision of a matching rule and returns true if refused
+	decide := func(rule string, action SybilAction, detail string) bool {
+		log.Infow("sybil rule matched",
+			"rule", rule,
+			"recipient", to.Hex(),
+			"action", string(action),
+			"detail", detail,
+		)
+		reduce = reduce || action == SybilActionReduce
+		return action == SybilActionRefuse
+	}
+	if sr.minNonce > 0 && nonce < sr.minNonce {
+		if decide(SybilRuleFresh, sr.freshAction, fmt.Sprintf("nonce %d below %d", nonce, sr.minNonce)) {
+			return 0, fmt.Errorf("%w: %s is a fresh address", ErrSuspiciousRecipient, to.Hex())
+		}
+	}
+	if sr.contractAction != "" {
+		code, err := backend.CodeAt(ctx, to, nil) // nil means latest block
+		if err != nil {
+			return 0, fmt.Errorf("cannot get code at %s: %w", to.Hex(), err)
+		}
+		if len(code) > 0 && decide(SybilRuleContract, sr.contractAction, "contract code deployed") {
+			return 0, fmt.Errorf("%w: %s is a contract", ErrSuspiciousRecipient, to.Hex())
+		}
+	}
+	// without outgoing txs nothing can have been forwarded
+	if len(sr.sinks) > 0 && nonce > 0 {
+		sink, err := sr.forwardedTo(ctx, backend, store, key, chainID, to, nonce)
+		if err != nil {
+			return 0, err
+		}
+		if sink != nil &&
+			decide(SybilRuleSink, sr.sinkAction, fmt.Sprintf("previous grant forwarded to %s", sink.Hex())) {
+			return 0, fmt.Errorf("%w: %s forwarded a previous grant to a sink", ErrSuspiciousRecipient, to.Hex())
+		}
+	}
+	if reduce {
+		// split the amount so the product cannot overflow with 18 decimals
+		return amount/100*sr.reducePercent + amount%100*sr.reducePercent/100, nil
+	}
+	return amount, nil
+}
+
+// forwardedTo returns the sink the recipient sent a tx to since its last
+// grant, nil if none. The blocks are scanned forward from the grant, up to
+// sinkScanBlocks of them, and only while some of the txs sent by the
+// recipient since the grant (its nonce increase) are not found, so the
+// recipients without outgoing txs cost no block request
+func (sr *sybilRules) forwardedTo(ctx context.Context,
+	backend evmBackend,
+	store storage.Storage,
+	key string,
+	chainID int,
+	to evmcommon.Address,
+	nonce uint64,
+) (*evmcommon.Address, error) {
+	grant, err := store.RecipientGrant(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get last grant to %s: %w", to.Hex(), err)
+	}
+	if nonce <= grant.Nonce {
+		return nil, nil
+	}
+	header, err := backend.HeaderByNumber(ctx, nil) // nil means latest block
+	if err != nil {
+		return nil, fmt.Errorf("cannot get latest block header: %w", err)
+	}
+	last := header.Number.Uint64()
+	if grant.Block+sr.sinkScanBlocks-1 < last {
+		last = grant.Block + sr.sinkScanBlocks - 1
+	}
+	pending := nonce - grant.Nonce
+	signer := evmtypes.LatestSignerForChainID(big.NewInt(int64(chainID)))
+	for number := grant.Block; number <= last && pending > 0; number++ {
+		block, err := backend.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return nil, fmt.Errorf("cannot get block %d: %w", number, err)
+		}
+		for _, tx := range block.Transactions() {
+			sender, err := evmtypes.Sender(signer, tx)
+			if err != nil {
+				log.Debugf("cannot get sender of tx %s: %s", tx.Hash().Hex(), err)
+				continue
+			}
+			// the txs sent before the grant were already counted
+			if sender != to || tx.Nonce() < grant.Nonce {
+				continue
+			}
+			if tx.To() != nil && sr.sinks[*tx.To()] {
+				return tx.To(), nil
+			}
+			pending--
+		}
+	}
+	return nil, nil
+}
+
+// recordGrant records in the storage a grant to the recipient for the sink
+// rule, the forwards are looked for from the latest block and with the
+// nonces from the current one
+func (sr *sybilRules) recordGrant(ctx context.Context,
+	backend evmBackend,
+	store storage.Storage,
+	key string,
+	to evmcommon.Address,
+) {
+	if len(sr.sinks) == 0 {
+		return
+	}
+	header, err := backend.HeaderByNumber(ctx, nil) // nil means latest block
+	if err != nil {
+		log.Warnf("cannot record grant to %s: %s", to.Hex(), err)
+		return
+	}
+	nonce, err := backend.NonceAt(ctx, to, nil) // nil means latest block
+	if err != nil {
+		log.Warnf("cannot record grant to %s: %s", to.Hex(), err)
+		return
+	}
+	if err := store.SetRecipientGrant(&storage.RecipientGrant{
+		Key:   key,
+		Block: header.Number.Uint64(),
+		Nonce: nonce,
+	}); err != nil {
+		log.Warnf("cannot record grant to %s: %s", to.Hex(), err)
+	}
+}
@@ -3,16 +3,31 @@ package faucet
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	evmcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/proto/build/go/models"
 	"go.vocdoni.io/vocdoni-faucet/config"
+	"go.vocdoni.io/vocdoni-faucet/storage"
 	"google.golang.org/protobuf/proto"
 )
 
+// VocdoniKeysSeparator separates the keys of a network signers rotation set
+const VocdoniKeysSeparator = ":"
+
+// packageReservationTTL is the maximum time an address is reserved while its
+// package is issued, in case the faucet stops without releasing it
+const packageReservationTTL = time.Minute
+
 var (
 	azeno = vocdoniSpecs{network: "azeno", networkID: "azeno"}
 	stage = vocdoniSpecs{network: "stage", networkID: "stage"}
@@ -52,32 +67,146 @@ type Vocdoni struct {
 	networkID []string
 	// amount of tokens to include
 	amount uint64
-	// signer account that will be used for signing
+	// signer default account that will be used for signing
 	signer *ethereum.SignKeys
+	// signers accounts that will be used for signing per network,
+	// if more than one the active accounts are used in rotation
+	signers map[string][]*Signer
+	// nextSigner index of the next signer to use per network
+	nextSigner map[string]int
 	// sendConditions conditions to meet before executing an action
 	sendConditions *sendConditions
+	// cooldown minimum time between packages for the same address
+	cooldown time.Duration
+	// topUpTarget balance the packages top the addresses up to, 0 means disabled
+	topUpTarget uint64
+	// topUpMaxGrant maximum amount of a top up package, 0 means no maximum
+	topUpMaxGrant uint64
+	// settings grant settings of the networks not using the defaults
+	settings map[string]*VocdoniNetworkSettings
+	// packages persisted issued packages, nil if packages are not tracked
+	packages *packageStore
+	// packageTTL time after which the unredeemed packages are reported as
+	// expired, 0 means no expiration
+	packageTTL time.Duration
+	// storage persists the signer key states
+	storage storage.Storage
+	// accountReaders used to check the packages redemption per network
+	accountReaders map[string]VocdoniAccountReader
+	lock           sync.Mutex
 }
 
 // NewVocdoni returns a new instance of a Vocdoni faucet
 func NewVocdoni() *Vocdoni {
-	return &Vocdoni{}
+	return &Vocdoni{storage: storage.NewMemory()}
 }
 
-// Amount returns amount
+// SetStorage persists the signer key states in the given storage, instead of
+// keeping them in memory, and restores the states stored by a previous run.
+// It must be called after Init
+func (v *Vocdoni) SetStorage(s storage.Storage) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	restored := make(map[string][]*Signer)
+	for network, signers := range v.signers {
+		var err error
+		if restored[network], err = restoreKeyStates(s, keysScope(network), signers); err != nil {
+			return err
+		}
+	}
+	v.signers = restored
+	v.storage = s
+	return nil
+}
+
+// Amount returns the default amount
 func (v *Vocdoni) Amount() uint64 {
 	return v.amount
 }
 
-// Signer returns the signer
+// VocdoniNetworkSettings represents the grant settings of a Vocdoni network
+type VocdoniNetworkSettings struct {
+	// Amount of tokens included in the packages
+	Amount uint64
+	// Threshold balance from which an address cannot get a package,
+	// only checked if the network has an account reader
+	Threshold uint64
+	// Challenge true if challenge enabled
+	Challenge bool
+	// Cooldown minimum time between packages for the same address,
+	// only checked if the packages are tracked
+	Cooldown time.Duration
+	// TopUpTarget balance the packages top the addresses up to instead of
+	// granting the amount, 0 means disabled. It requires an account reader
+	TopUpTarget uint64
+	// TopUpMaxGrant maximum amount of a top up package, 0 means no maximum
+	TopUpMaxGrant uint64
+}
+
+// Settings returns the grant settings of the given network
+func (v *Vocdoni) Settings(network string) *VocdoniNetworkSettings {
+	if chainSpecs, err := vocdoniSpecsFor(network); err == nil {
+		if settings, ok := v.settings[chainSpecs.network]; ok {
+			return settings
+		}
+	}
+	return &VocdoniNetworkSettings{
+		Amount:        v.amount,
+		Threshold:     v.sendConditions.Balance,
+		Challenge:     v.sendConditions.Challenge,
+		Cooldown:      v.cooldown,
+		TopUpTarget:   v.topUpTarget,
+		TopUpMaxGrant: v.topUpMaxGrant,
+	}
+}
+
+// Signer returns the default signer
 func (v *Vocdoni) Signer() *ethereum.SignKeys {
 	return v.signer
 }
 
+// Signers returns the signers of the given network
+func (v *Vocdoni) Signers(network string) []*Signer {
+	chainSpecs, err := vocdoniSpecsFor(network)
+	if err != nil {
+		return nil
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.signers[chainSpecs.network]
+}
+
+// Addresses returns the faucet addresses per network whose packages
+// can be redeemed, that is the active and retiring ones
+func (v *Vocdoni) Addresses() map[string][]evmcommon.Address {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	addresses := make(map[string][]evmcommon.Address)
+	for network, signers := range v.signers {
+		for _, signer := range signers {
+			if signer.State == KeyStateIncoming {
+				continue
+			}
+			addresses[network] = append(addresses[network], signer.SignKeys.Address())
+		}
+	}
+	return addresses
+}
+
 // Network returns the faucet vocdoni network
 func (v *Vocdoni) Network() []string {
 	return v.network
 }
 
+// ChainID returns the network ID of the given Vocdoni network
+func (v *Vocdoni) ChainID(network string) (string, error) {
+	chainSpecs, err := vocdoniSpecsFor(network)
+	if err != nil {
+		return "", err
+	}
+	return chainSpecs.networkID, nil
+}
+
 func (v *Vocdoni) setAmount(amount uint64) error {
 	if amount == 0 {
 		return ErrInvalidAmount
@@ -93,6 +222,67 @@ func (v *Vocdoni) setSendConditions(balance uint64, challenge bool) {
 	}
 }
 
+// setSigners sets the default signer and the signers per network, the
+// networks without specific keys use the default signer
+func (v *Vocdoni) setSigners(defaultKey string, networkKeys map[string]string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.signer = nil
+	if defaultKey != "" {
+		v.signer = new(ethereum.SignKeys)
+		if err := v.signer.AddHexKey(defaultKey); err != nil {
+			return fmt.Errorf("cannot import key: %w", err)
+		}
+	}
+	v.signers = make(map[string][]*Signer)
+	v.nextSigner = make(map[string]int)
+	for network, keys := range networkKeys {
+		chainSpecs, err := vocdoniSpecsFor(network)
+		if err != nil {
+			return fmt.Errorf("cannot set keys for %s: %w", network, err)
+		}
+		for _, key := range strings.Split(keys, VocdoniKeysSeparator) {
+			signer, err := newSigner(key, KeyStateActive)
+			if err != nil {
+				return fmt.Errorf("cannot set keys for %s: %w", network, err)
+			}
+			v.signers[chainSpecs.network] = append(v.signers[chainSpecs.network], signer)
+		}
+	}
+	for _, network := range v.network {
+		if len(v.signers[network]) > 0 {
+			continue
+		}
+		if v.signer == nil {
+			return fmt.Errorf("%w: no key for %s", ErrInvalidSigner, network)
+		}
+		// every network has its own signer state for the default key
+		v.signers[network] = []*Signer{{SignKeys: v.signer, State: KeyStateActive}}
+	}
+	return nil
+}
+
+// nextSignerFor returns the signer to use for the given network, rotating
+// across the network active signers or the default one if the network has
+// no signers. The lock must be held.
+func (v *Vocdoni) nextSignerFor(network string) (*ethereum.SignKeys, error) {
+	signers := []*ethereum.SignKeys{}
+	for _, signer := range v.signers[network] {
+		if signer.State == KeyStateActive {
+			signers = append(signers, signer.SignKeys)
+		}
+	}
+	if len(signers) == 0 {
+		if v.signer == nil {
+			return nil, fmt.Errorf("%w: no key for %s", ErrInvalidSigner, network)
+		}
+		return v.signer, nil
+	}
+	index := v.nextSigner[network] % len(signers)
+	v.nextSigner[network] = index + 1
+	return signers[index], nil
+}
+
 // Init initializes a Vocdoni instance with the given config
 func (v *Vocdoni) Init(ctx context.Context, vocdoniConfig *config.FaucetConfig) error {
 	// get chain specs
@@ -112,10 +302,9 @@ func (v *Vocdoni) Init(ctx context.Context, vocdoniConfig *config.FaucetConfig)
 		return err
 	}
 
-	// set signer
-	v.signer = new(ethereum.SignKeys)
-	if err := v.signer.AddHexKey(vocdoniConfig.VocdoniPrivKey); err != nil {
-		return fmt.Errorf("cannot import key: %w", err)
+	// set signers
+	if err := v.setSigners(vocdoniConfig.VocdoniPrivKey, vocdoniConfig.VocdoniNetworkPrivKeys); err != nil {
+		return err
 	}
 
 	// set send conditions
@@ -123,12 +312,247 @@ func (v *Vocdoni) Init(ctx context.Context, vocdoniConfig *config.FaucetConfig)
 		vocdoniConfig.VocdoniSendConditions.Balance,
 		vocdoniConfig.VocdoniSendConditions.Challenge,
 	)
+	if vocdoniConfig.VocdoniSendConditions.Challenge {
+		log.Warn("the vocdoni faucet challenge is not enforced yet, the claims do not solve it")
+	}
+
+	// set per network settings
+	v.cooldown = vocdoniConfig.VocdoniCooldown
+	v.topUpTarget = vocdoniConfig.VocdoniTopUpTarget
+	v.topUpMaxGrant = vocdoniConfig.VocdoniTopUpMaxGrant
+	if err := v.setNetworkSettings(
+		vocdoniConfig.VocdoniNetworkAmounts,
+		vocdoniConfig.VocdoniNetworkThresholds,
+		vocdoniConfig.VocdoniNetworkCooldowns,
+	); err != nil {
+		return err
+	}
+
+	// set packages expiration and the endpoints for checking its redemption
+	v.packageTTL = vocdoniConfig.VocdoniPackageTTL
+	v.accountReaders = make(map[string]VocdoniAccountReader)
+	for network, endpoint := range vocdoniConfig.VocdoniEndpoints {
+		chainSpecs, err := vocdoniSpecsFor(network)
+		if err != nil {
+			return fmt.Errorf("cannot set endpoint for %s: %w", network, err)
+		}
+		v.accountReaders[chainSpecs.network] = NewVocdoniAPIAccountReader(endpoint)
+	}
+
+	return nil
+}
+
+// setNetworkSettings sets the settings of the networks with specific amounts,
+// thresholds or cooldowns, the values not set use the defaults
+func (v *Vocdoni) setNetworkSettings(amounts, thresholds, cooldowns map[string]string) error {
+	v.settings = make(map[string]*VocdoniNetworkSettings)
+	settingsFor := func(network string) (*VocdoniNetworkSettings, error) {
+		chainSpecs, err := vocdoniSpecsFor(network)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set settings for %s: %w", network, err)
+		}
+		if _, ok := v.settings[chainSpecs.network]; !ok {
+			v.settings[chainSpecs.network] = v.Settings(chainSpecs.network)
+		}
+		return v.settings[chainSpecs.network], nil
+	}
+	for network, value := range amounts {
+		settings, err := settingsFor(network)
+		if err != nil {
+			return err
+		}
+		amount, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || amount == 0 {
+			return fmt.Errorf("%w for %s: %s", ErrInvalidAmount, network, value)
+		}
+		settings.Amount = amount
+	}
+	for network, value := range thresholds {
+		settings, err := settingsFor(network)
+		if err != nil {
+			return err
+		}
+		threshold, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold for %s: %w", network, err)
+		}
+		settings.Threshold = threshold
+	}
+	for network, value := range cooldowns {
+		settings, err := settingsFor(network)
+		if err != nil {
+			return err
+		}
+		cooldown, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid cooldown for %s: %w", network, err)
+		}
+		settings.Cooldown = cooldown
+	}
+	return nil
+}
+
+// TrackPackages persists the issued packages in the given storage, an address
+// cannot get a new package while it has an unredeemed one, even from another
+// replica using the same storage
+func (v *Vocdoni) TrackPackages(s storage.Storage) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.packages = &packageStore{storage: s}
+}
+
+// SetAccountReader sets the account reader used for checking the
+// redemption of the packages issued for the given network
+func (v *Vocdoni) SetAccountReader(network string, reader VocdoniAccountReader) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.accountReaders[network] = reader
+}
+
+// Package returns the issued package with the given identifier
+func (v *Vocdoni) Package(identifier uint64) (*PackageRecord, error) {
+	if v.packages == nil {
+		return nil, ErrPackagesNotTracked
+	}
+	return v.packages.get(identifier)
+}
+
+// WatchRedemptions checks periodically the Vocdoni networks for marking
+// the unredeemed packages as redeemed, until the context is done
+func (v *Vocdoni) WatchRedemptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.CheckRedemptions(ctx); err != nil {
+				log.Warnf("cannot check faucet packages redemption: %s", err)
+			}
+		}
+	}
+}
 
+// CheckRedemptions marks as redeemed the unredeemed packages, issued or
+// expired, whose recipient balance increased at least by the package amount
+func (v *Vocdoni) CheckRedemptions(ctx context.Context) error {
+	if v.packages == nil {
+		return ErrPackagesNotTracked
+	}
+	pending, err := v.packages.list(PackageStatusIssued, PackageStatusExpired)
+	if err != nil {
+		return err
+	}
+	for _, pr := range pending {
+		v.lock.Lock()
+		reader, ok := v.accountReaders[pr.Network]
+		v.lock.Unlock()
+		if !ok {
+			continue
+		}
+		balance, err := reader.Balance(ctx, evmcommon.BytesToAddress(pr.To))
+		if err != nil {
+			if !errors.Is(err, ErrAccountNotFound) {
+				log.Warnf("cannot check faucet package %d redemption: %s", pr.Identifier, err)
+			}
+			continue
+		}
+		if balance < pr.BaseBalance+pr.Amount {
+			continue
+		}
+		now := time.Now()
+		pr.RedeemedAt = &now
+		if err := v.packages.set(pr); err != nil {
+			return err
+		}
+		log.Infof("faucet package %d for %s on %s redeemed",
+			pr.Identifier, evmcommon.BytesToAddress(pr.To).Hex(), pr.Network)
+	}
 	return nil
 }
 
-// GenerateFaucetPackage generates a faucet package
-func (v *Vocdoni) GenerateFaucetPackage(address evmcommon.Address) (*models.FaucetPackage, error) {
+// GenerateFaucetPackage generates a faucet package for the given network with the
+// network amount. If the packages are tracked it is refused while the address has
+// an unredeemed one or the network cooldown is active. If the network has an account
+// reader it is refused if the address balance is above the network threshold. In
+// top up mode the amount tops the address balance up to the network target
+func (v *Vocdoni) GenerateFaucetPackage(network string, address evmcommon.Address) (*models.FaucetPackage, error) {
+	return v.GenerateFaucetPackageAmount(network, address, 0)
+}
+
+// GenerateFaucetPackageAmount generates a faucet package as GenerateFaucetPackage
+// but with the given amount, 0 means the network amount
+func (v *Vocdoni) GenerateFaucetPackageAmount(network string,
+	address evmcommon.Address,
+	amount uint64,
+) (*models.FaucetPackage, error) {
+	return v.GenerateFaucetPackageWith(network, address, &GrantSettings{Amount: amount})
+}
+
+// GenerateFaucetPackageWith generates a faucet package as GenerateFaucetPackage
+// but with the given grant settings instead of the network ones
+func (v *Vocdoni) GenerateFaucetPackageWith(network string,
+	address evmcommon.Address,
+	grant *GrantSettings,
+) (*models.FaucetPackage, error) {
+	chainSpecs, err := vocdoniSpecsFor(network)
+	if err != nil {
+		return nil, err
+	}
+	network = chainSpecs.network
+	settings := *v.Settings(network)
+	if grant.Amount > 0 {
+		settings.Amount = grant.Amount
+	}
+	if grant.Threshold > 0 {
+		settings.Threshold = grant.Threshold
+	}
+	if grant.Cooldown > 0 {
+		settings.Cooldown = grant.Cooldown
+	}
+	// in top up mode the target is the threshold, unless an amount is given
+	topUp := grant.Amount == 0 && settings.TopUpTarget > 0
+	if topUp {
+		settings.Threshold = settings.TopUpTarget
+	}
+	amount := settings.Amount
+	if v.packages != nil {
+		release, err := v.reservePackage(network, address, settings.Cooldown)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	// get the current balance for checking the threshold and detecting the redemption later
+	var balance uint64
+	v.lock.Lock()
+	reader, ok := v.accountReaders[network]
+	v.lock.Unlock()
+	if topUp && !ok {
+		return nil, fmt.Errorf("%w: %s has no account reader", ErrTopUpUnavailable, network)
+	}
+	if ok {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		balance, err = reader.Balance(ctx, address)
+		if err != nil && !errors.Is(err, ErrAccountNotFound) {
+			return nil, fmt.Errorf("cannot get %s balance: %w", address.Hex(), err)
+		}
+		if balance >= settings.Threshold {
+			return nil, fmt.Errorf("%w: %s has already a balance of %d",
+				ErrBalanceAboveThreshold, address.Hex(), balance)
+		}
+	}
+	if topUp {
+		amount = topUpAmount(settings.TopUpTarget, balance, settings.TopUpMaxGrant)
+	}
+	v.lock.Lock()
+	signer, err := v.nextSignerFor(network)
+	v.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
 	identifier, err := rand.Int(rand.Reader, big.NewInt(int64(MAXUINT64)))
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate faucet package identifier")
@@ -137,18 +561,91 @@ func (v *Vocdoni) GenerateFaucetPackage(address evmcommon.Address) (*models.Fauc
 	payload := &models.FaucetPayload{
 		Identifier: identifier.Uint64(),
 		To:         address.Bytes(),
-		Amount:     v.amount,
+		Amount:     amount,
 	}
 	payloadBytes, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
-	payloadSignature, err := v.signer.SignEthereum(payloadBytes)
+	payloadSignature, err := signer.SignEthereum(payloadBytes)
 	if err != nil {
 		return nil, err
 	}
+	if v.packages != nil {
+		if err := v.trackPackage(network, address, signer.Address(), balance, payload); err != nil {
+			return nil, fmt.Errorf("cannot track faucet package: %w", err)
+		}
+	}
 	return &models.FaucetPackage{
 		Payload:   payloadBytes,
 		Signature: payloadSignature,
 	}, nil
 }
+
+// reservePackage checks the address can get a new package on the network and
+// reserves it until the returned release function is called. The reservation
+// is a lease in the storage held only by this issuance, so a concurrent
+// package is refused even if it is issued by another replica
+func (v *Vocdoni) reservePackage(network string,
+	address evmcommon.Address,
+	cooldown time.Duration,
+) (func(), error) {
+	name := "vocdoni/" + network + "/package/" + strings.ToLower(address.Hex())
+	holder := uuid.NewString()
+	_, err := v.packages.storage.AcquireLease(name, holder, packageReservationTTL)
+	if errors.Is(err, storage.ErrLeaseHeld) {
+		return nil, fmt.Errorf("%w: a package is being issued", ErrPendingPackage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot reserve faucet package: %w", err)
+	}
+	release := func() {
+		if err := v.packages.storage.ReleaseLease(name, holder); err != nil {
+			log.Warnf("cannot release faucet package reservation of %s: %s", address.Hex(), err)
+		}
+	}
+	last, err := v.packages.last(network, address)
+	if err != nil && !errors.Is(err, ErrPackageNotFound) {
+		release()
+		return nil, fmt.Errorf("cannot get last faucet package: %w", err)
+	}
+	// the expired packages can still be redeemed, as their payload does
+	// not expire, so they are pending too
+	switch {
+	case last == nil:
+	case last.Status() == PackageStatusIssued:
+		release()
+		return nil, fmt.Errorf("%w: %d", ErrPendingPackage, last.Identifier)
+	case last.Status() == PackageStatusExpired:
+		release()
+		return nil, fmt.Errorf("%w: %d expired but can still be redeemed", ErrPendingPackage, last.Identifier)
+	}
+	if last != nil && time.Since(last.IssuedAt) < cooldown {
+		release()
+		return nil, &CooldownError{NextAt: last.IssuedAt.Add(cooldown)}
+	}
+	return release, nil
+}
+
+// trackPackage persists the given package payload issued for the network
+func (v *Vocdoni) trackPackage(network string,
+	address,
+	signer evmcommon.Address,
+	balance uint64,
+	payload *models.FaucetPayload,
+) error {
+	pr := &PackageRecord{
+		Identifier:  payload.Identifier,
+		Network:     network,
+		Signer:      signer.Bytes(),
+		To:          address.Bytes(),
+		Amount:      payload.Amount,
+		IssuedAt:    time.Now(),
+		BaseBalance: balance,
+	}
+	if v.packageTTL > 0 {
+		expiresAt := pr.IssuedAt.Add(v.packageTTL)
+		pr.ExpiresAt = &expiresAt
+	}
+	return v.packages.set(pr)
+}
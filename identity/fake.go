@@ -0,0 +1,83 @@
+package identity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeGitHub is a fake GitHub OAuth server for testing, it authenticates the
+// users of the registered codes
+type FakeGitHub struct {
+	*httptest.Server
+	users  map[string]*Identity
+	tokens map[string]*Identity
+	lock   sync.Mutex
+}
+
+// NewFakeGitHub starts a fake GitHub OAuth server, it must be closed after use
+func NewFakeGitHub() *FakeGitHub {
+	f := &FakeGitHub{
+		users:  make(map[string]*Identity),
+		tokens: make(map[string]*Identity),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", f.accessToken)
+	mux.HandleFunc("/user", f.user)
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// AddCode registers a single-use code authenticating the given user, the
+// ID must be numeric as in GitHub
+func (f *FakeGitHub) AddCode(code string, user *Identity) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.users[code] = user
+}
+
+// Provider returns a GitHub provider using the fake server
+func (f *FakeGitHub) Provider() *GitHub {
+	g := NewGitHub("client", "secret", "https://faucet.test/callback")
+	g.URL = f.URL
+	g.APIURL = f.URL
+	return g
+}
+
+func (f *FakeGitHub) accessToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.Method != http.MethodPost || r.FormValue("client_secret") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	resp := map[string]string{"error": "bad_verification_code"}
+	if user, ok := f.users[r.FormValue("code")]; ok {
+		delete(f.users, r.FormValue("code"))
+		token := "token" + strconv.Itoa(len(f.tokens))
+		f.tokens[token] = user
+		resp = map[string]string{"access_token": token, "token_type": "bearer"}
+	}
+	// GitHub replies the errors with a 200 status
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (f *FakeGitHub) user(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	user, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	id, _ := strconv.ParseInt(user.ID, 10, 64)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         id,
+		"login":      user.Login,
+		"created_at": user.CreatedAt.UTC().Format(time.RFC3339),
+	})
+}
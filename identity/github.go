@@ -0,0 +1,132 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// GitHubName is the name of the GitHub provider
+	GitHubName = "github"
+	// DefaultGitHubURL is the URL of the GitHub OAuth endpoints
+	DefaultGitHubURL = "https://github.com"
+	// DefaultGitHubAPIURL is the URL of the GitHub API
+	DefaultGitHubAPIURL = "https://api.github.com"
+)
+
+// GitHub is the GitHub OAuth identity provider
+type GitHub struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	// URL is the base URL of the OAuth endpoints, it can be changed for testing
+	URL string
+	// APIURL is the base URL of the API, it can be changed for testing
+	APIURL string
+	http   *http.Client
+}
+
+// NewGitHub returns a GitHub provider for the given OAuth app
+func NewGitHub(clientID, clientSecret, redirectURL string) *GitHub {
+	return &GitHub{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		URL:          DefaultGitHubURL,
+		APIURL:       DefaultGitHubAPIURL,
+		http:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name implements Provider
+func (g *GitHub) Name() string {
+	return GitHubName
+}
+
+// AuthURL implements Provider, no scope is requested as only the public
+// profile is read
+func (g *GitHub) AuthURL(state string) string {
+	params := url.Values{}
+	params.Set("client_id", g.clientID)
+	params.Set("redirect_uri", g.redirectURL)
+	params.Set("state", state)
+	params.Set("allow_signup", "false")
+	return strings.TrimSuffix(g.URL, "/") + "/login/oauth/authorize?" + params.Encode()
+}
+
+// Identity implements Provider
+func (g *GitHub) Identity(ctx context.Context, code string) (*Identity, error) {
+	params := url.Values{}
+	params.Set("client_id", g.clientID)
+	params.Set("client_secret", g.clientSecret)
+	params.Set("code", code)
+	params.Set("redirect_uri", g.redirectURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(g.URL, "/")+"/login/oauth/access_token", strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := &struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := g.do(req, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: cannot get access token: %s %s", ErrProvider, token.Error, token.ErrorDescription)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(g.APIURL, "/")+"/user", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	user := &struct {
+		ID        int64     `json:"id"`
+		Login     string    `json:"login"`
+		CreatedAt time.Time `json:"created_at"`
+	}{}
+	if err := g.do(req, user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("%w: missing user id", ErrProvider)
+	}
+	return &Identity{
+		Provider:  GitHubName,
+		ID:        strconv.FormatInt(user.ID, 10),
+		Login:     user.Login,
+		CreatedAt: user.CreatedAt,
+	}, nil
+}
+
+// do executes the given request and decodes the JSON response on the given value
+func (g *GitHub) do(req *http.Request, value interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: %s", ErrProvider, resp.Status, data)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("%w: cannot decode response: %s", ErrProvider, err)
+	}
+	return nil
+}
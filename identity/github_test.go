@@ -0,0 +1,43 @@
+package identity_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/vocdoni-faucet/identity"
+)
+
+func TestGitHub(t *testing.T) {
+	server := identity.NewFakeGitHub()
+	defer server.Close()
+	github := server.Provider()
+	createdAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	server.AddCode("code1", &identity.Identity{ID: "42", Login: "alice", CreatedAt: createdAt})
+
+	// should redirect to the authorize URL with the state
+	authURL, err := url.Parse(github.AuthURL("state1"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, authURL.Path, qt.Equals, "/login/oauth/authorize")
+	qt.Assert(t, authURL.Query().Get("state"), qt.Equals, "state1")
+	qt.Assert(t, authURL.Query().Get("client_id"), qt.Equals, "client")
+
+	// should exchange the code for the identity
+	id, err := github.Identity(context.Background(), "code1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, id, qt.DeepEquals, &identity.Identity{
+		Provider:  identity.GitHubName,
+		ID:        "42",
+		Login:     "alice",
+		CreatedAt: createdAt,
+	})
+	qt.Assert(t, id.Key(), qt.Equals, "github:42")
+
+	// should refuse used and unknown codes
+	_, err = github.Identity(context.Background(), "code1")
+	qt.Assert(t, err, qt.ErrorIs, identity.ErrProvider)
+	_, err = github.Identity(context.Background(), "invalid")
+	qt.Assert(t, err, qt.ErrorIs, identity.ErrProvider)
+}
@@ -0,0 +1,44 @@
+// Package identity implements the identity providers used for gating the
+// faucet claims to authenticated accounts, such as GitHub users.
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrProvider error returned if the identity provider fails or refuses the authentication
+	ErrProvider error = errors.New("identity provider error")
+)
+
+// Identity represents an account authenticated by an identity provider
+type Identity struct {
+	// Provider is the name of the identity provider
+	Provider string `json:"provider"`
+	// ID is the stable identifier of the account in the provider
+	ID string `json:"id"`
+	// Login is the human-readable name of the account
+	Login string `json:"login"`
+	// CreatedAt is the creation time of the account
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Key returns the identifier of the identity across providers
+func (i *Identity) Key() string {
+	return i.Provider + ":" + i.ID
+}
+
+// Provider is an OAuth identity provider
+type Provider interface {
+	// Name returns the name of the provider, used in the API routes
+	Name() string
+	// AuthURL returns the URL to redirect the users to for authenticating,
+	// the provider redirects back to the configured redirect URL with the
+	// code and the given state
+	AuthURL(state string) string
+	// Identity exchanges the code of the redirect and returns the identity
+	// of the authenticated user
+	Identity(ctx context.Context, code string) (*Identity, error)
+}
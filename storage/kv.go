@@ -0,0 +1,799 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
+	"go.vocdoni.io/dvote/log"
+)
+
+// SchemaVersion is the schema version of the data stored by this version of the faucet
+const SchemaVersion = 6
+
+const (
+	schemaVersionKey  = "meta/schemaVersion"
+	auditSeqKey       = "meta/auditSeq"
+	claimPrefix       = "claim/"
+	tokenPrefix       = "token/"
+	pendingTxPrefix   = "ptx/"
+	auditPrefix       = "audit/"
+	leasePrefix       = "lease/"
+	sendPrefix        = "send/"
+	signerKeyPrefix   = "signerkey/"
+	packagePrefix     = "pkg/"
+	lastPackagePrefix = "lastpkg/"
+	listEntryPrefix   = "listentry/"
+	secretPrefix      = "secret/"
+	noncePrefix       = "nonce/"
+	sessionPrefix     = "session/"
+	grantPrefix       = "rgrant/"
+)
+
+// migration upgrades the stored data to its schema version
+type migration struct {
+	version     uint32
+	description string
+	// apply changes the stored data, nil if the version only adds new items
+	apply func(tx db.WriteTx) error
+}
+
+// migrations are the schema migrations ordered by version, the last one
+// must be SchemaVersion. A migration is never changed once released, the
+// changes need a new one
+var migrations = []*migration{
+	{version: 1, description: "claims, tokens, pending txs and audit records"},
+	{version: 2, description: "signer leases and send queue"},
+	{version: 3, description: "signer key states"},
+	{version: 4, description: "vocdoni packages"},
+	{version: 5, description: "address list entries"},
+	{version: 6, description: "secrets, used nonces, sign in sessions and recipient grants"},
+}
+
+// KV is a Storage backed by an embedded key-value database
+type KV struct {
+	db db.Database
+	// lock serializes the read-modify-write operations
+	lock sync.Mutex
+}
+
+// OpenKV opens or creates a Storage backed by a Pebble database in the given
+// directory, migrating its data to the current schema version
+func OpenKV(dir string) (*KV, error) {
+	database, err := pebbledb.New(db.Options{Path: dir})
+	if err != nil {
+		return nil, err
+	}
+	kv, err := NewKV(database)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	return kv, nil
+}
+
+// NewKV returns a Storage backed by the given database, migrating its data
+// to the current schema version. The database is closed with the Storage
+func NewKV(database db.Database) (*KV, error) {
+	kv := &KV{db: database}
+	if err := kv.migrate(); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// migrate applies the migrations newer than the stored schema version, each
+// one in its own transaction with the version update
+func (kv *KV) migrate() error {
+	current, err := kv.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: stored %d, supported up to %d", ErrSchemaVersion, current, SchemaVersion)
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := kv.update(func(tx db.WriteTx) error {
+			if m.apply != nil {
+				if err := m.apply(tx); err != nil {
+					return err
+				}
+			}
+			version := make([]byte, 4)
+			binary.BigEndian.PutUint32(version, m.version)
+			return tx.Set([]byte(schemaVersionKey), version)
+		}); err != nil {
+			return fmt.Errorf("cannot migrate to schema version %d: %w", m.version, err)
+		}
+		log.Infof("storage migrated to schema version %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+// Close implements Storage
+func (kv *KV) Close() error {
+	return kv.db.Close()
+}
+
+// SchemaVersion implements Storage, 0 means a new database
+func (kv *KV) SchemaVersion() (uint32, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	data, err := tx.Get([]byte(schemaVersionKey))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, fmt.Errorf("%w: invalid stored version %x", ErrSchemaVersion, data)
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// get decodes the JSON value of the key, ErrNotFound if it does not exist
+func get(tx db.ReadTx, key []byte, value interface{}) error {
+	data, err := tx.Get(key)
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// set encodes the value of the key as JSON
+func set(tx db.WriteTx, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return tx.Set(key, data)
+}
+
+// update runs the given function in a write transaction, committed if it
+// does not fail
+func (kv *KV) update(fn func(tx db.WriteTx) error) error {
+	tx := kv.db.WriteTx()
+	defer tx.Discard()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Claim implements Storage
+func (kv *KV) Claim(key string) (*Claim, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	claim := &Claim{}
+	if err := get(tx, []byte(claimPrefix+key), claim); err != nil {
+		return nil, fmt.Errorf("claim %s: %w", key, err)
+	}
+	return claim, nil
+}
+
+// ReserveClaim implements Storage
+func (kv *KV) ReserveClaim(key string, cooldown time.Duration) (*Claim, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	var previous *Claim
+	err := kv.update(func(tx db.WriteTx) error {
+		now := time.Now()
+		last := &Claim{}
+		err := get(tx, []byte(claimPrefix+key), last)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			last = nil
+		case err != nil:
+			return err
+		case now.Sub(last.At) < cooldown:
+			return &CooldownError{NextAt: last.At.Add(cooldown)}
+		}
+		claim := &Claim{Key: key, At: now, Count: 1}
+		if last != nil {
+			claim.Count = last.Count + 1
+		}
+		previous = last
+		return set(tx, []byte(claimPrefix+key), claim)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// ReleaseClaim implements Storage
+func (kv *KV) ReleaseClaim(key string, previous *Claim) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		if previous == nil {
+			return tx.Delete([]byte(claimPrefix + key))
+		}
+		return set(tx, []byte(claimPrefix+key), previous)
+	})
+}
+
+// Token implements Storage
+func (kv *KV) Token(token string) (*Token, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	t := &Token{}
+	if err := get(tx, []byte(tokenPrefix+token), t); err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	return t, nil
+}
+
+// SetToken implements Storage
+func (kv *KV) SetToken(token *Token) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, []byte(tokenPrefix+token.Token), token)
+	})
+}
+
+// UseToken implements Storage
+func (kv *KV) UseToken(token string) (*Token, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	t := &Token{}
+	err := kv.update(func(tx db.WriteTx) error {
+		if err := get(tx, []byte(tokenPrefix+token), t); err != nil {
+			return fmt.Errorf("token: %w", err)
+		}
+		if t.Remaining == 0 {
+			return ErrQuotaExceeded
+		}
+		t.Remaining--
+		return set(tx, []byte(tokenPrefix+token), t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// AddPendingTx implements Storage
+func (kv *KV) AddPendingTx(ptx *PendingTx) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, append([]byte(pendingTxPrefix), ptx.Hash.Bytes()...), ptx)
+	})
+}
+
+// RemovePendingTx implements Storage
+func (kv *KV) RemovePendingTx(hash common.Hash) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return tx.Delete(append([]byte(pendingTxPrefix), hash.Bytes()...))
+	})
+}
+
+// PendingTxs implements Storage
+func (kv *KV) PendingTxs() ([]*PendingTx, error) {
+	txs := []*PendingTx{}
+	var err error
+	if ierr := kv.db.Iterate([]byte(pendingTxPrefix), func(_, value []byte) bool {
+		ptx := &PendingTx{}
+		if err = json.Unmarshal(value, ptx); err != nil {
+			return false
+		}
+		txs = append(txs, ptx)
+		return true
+	}); ierr != nil {
+		return nil, ierr
+	}
+	return txs, err
+}
+
+// auditKey returns the key of the audit record with the given sequence
+func auditKey(seq uint64) []byte {
+	key := make([]byte, len(auditPrefix)+8)
+	copy(key, auditPrefix)
+	binary.BigEndian.PutUint64(key[len(auditPrefix):], seq)
+	return key
+}
+
+// AppendAudit implements Storage
+func (kv *KV) AppendAudit(record *AuditRecord) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		last := uint64(0)
+		data, err := tx.Get([]byte(auditSeqKey))
+		switch {
+		case err == nil:
+			last = binary.BigEndian.Uint64(data)
+		case !errors.Is(err, db.ErrKeyNotFound):
+			return err
+		}
+		if record.Seq != last+1 {
+			return fmt.Errorf("%w: audit record %d does not follow %d", ErrConflict, record.Seq, last)
+		}
+		if err := tx.Set(auditKey(record.Seq), record.Data); err != nil {
+			return err
+		}
+		return tx.Set([]byte(auditSeqKey), auditKey(record.Seq)[len(auditPrefix):])
+	})
+}
+
+// AuditRecords implements Storage
+func (kv *KV) AuditRecords(from uint64) ([]*AuditRecord, error) {
+	records := []*AuditRecord{}
+	if err := kv.db.Iterate([]byte(auditPrefix), func(key, value []byte) bool {
+		seq := binary.BigEndian.Uint64(key[len(key)-8:])
+		if seq >= from {
+			records = append(records, &AuditRecord{Seq: seq, Data: append([]byte{}, value...)})
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AcquireLease implements Storage
+func (kv *KV) AcquireLease(name, holder string, ttl time.Duration) (*Lease, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	lease := &Lease{}
+	err := kv.update(func(tx db.WriteTx) error {
+		now := time.Now()
+		err := get(tx, []byte(leasePrefix+name), lease)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return err
+		case lease.Holder != holder && now.Before(lease.ExpiresAt):
+			return leaseHeldError(lease)
+		}
+		lease = &Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+		return set(tx, []byte(leasePrefix+name), lease)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// ReleaseLease implements Storage
+func (kv *KV) ReleaseLease(name, holder string) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		lease := &Lease{}
+		err := get(tx, []byte(leasePrefix+name), lease)
+		if errors.Is(err, ErrNotFound) || (err == nil && lease.Holder != holder) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Delete([]byte(leasePrefix + name))
+	})
+}
+
+// EnqueueSend implements Storage
+func (kv *KV) EnqueueSend(send *QueuedSend) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, []byte(sendPrefix+send.ID), send)
+	})
+}
+
+// TakeSend implements Storage
+func (kv *KV) TakeSend(queue, holder string) (*QueuedSend, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	waiting := []QueuedSend{}
+	var err error
+	if ierr := kv.db.Iterate([]byte(sendPrefix), func(_, value []byte) bool {
+		send := QueuedSend{}
+		if err = json.Unmarshal(value, &send); err != nil {
+			return false
+		}
+		if send.Queue == queue && send.Holder == "" {
+			waiting = append(waiting, send)
+		}
+		return true
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+	send := oldestSend(waiting)
+	if send == nil {
+		return nil, fmt.Errorf("queue %s: %w", queue, ErrNotFound)
+	}
+	send.Holder = holder
+	if err := kv.update(func(tx db.WriteTx) error {
+		return set(tx, []byte(sendPrefix+send.ID), send)
+	}); err != nil {
+		return nil, err
+	}
+	return send, nil
+}
+
+// CompleteSend implements Storage
+func (kv *KV) CompleteSend(send *QueuedSend) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		stored := &QueuedSend{}
+		if err := get(tx, []byte(sendPrefix+send.ID), stored); err != nil {
+			return fmt.Errorf("send %s: %w", send.ID, err)
+		}
+		stored.TxHash, stored.Error, stored.DoneAt = send.TxHash, send.Error, send.DoneAt
+		return set(tx, []byte(sendPrefix+send.ID), stored)
+	})
+}
+
+// QueuedSend implements Storage
+func (kv *KV) QueuedSend(id string) (*QueuedSend, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	send := &QueuedSend{}
+	if err := get(tx, []byte(sendPrefix+id), send); err != nil {
+		return nil, fmt.Errorf("send %s: %w", id, err)
+	}
+	return send, nil
+}
+
+// CancelSend implements Storage
+func (kv *KV) CancelSend(id string) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		send := &QueuedSend{}
+		err := get(tx, []byte(sendPrefix+id), send)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if send.Holder != "" {
+			return fmt.Errorf("%w: send %s taken by %s", ErrConflict, id, send.Holder)
+		}
+		return tx.Delete([]byte(sendPrefix + id))
+	})
+}
+
+// DeleteSend implements Storage
+func (kv *KV) DeleteSend(id string) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return tx.Delete([]byte(sendPrefix + id))
+	})
+}
+
+// signerKeyKey returns the key of the signer key state with the given scope
+// and address
+func signerKeyKey(scope string, address common.Address) []byte {
+	return append([]byte(signerKeyPrefix+scope+"/"), address.Bytes()...)
+}
+
+// SignerKeys implements Storage
+func (kv *KV) SignerKeys(scope string) ([]*SignerKey, error) {
+	keys := []*SignerKey{}
+	var err error
+	if ierr := kv.db.Iterate([]byte(signerKeyPrefix+scope+"/"), func(_, value []byte) bool {
+		key := &SignerKey{}
+		if err = json.Unmarshal(value, key); err != nil {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	}); ierr != nil {
+		return nil, ierr
+	}
+	return keys, err
+}
+
+// SetSignerKey implements Storage
+func (kv *KV) SetSignerKey(key *SignerKey) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, signerKeyKey(key.Scope, key.Address), key)
+	})
+}
+
+// packageKey returns the key of the package with the given identifier
+func packageKey(identifier uint64) []byte {
+	key := make([]byte, len(packagePrefix)+8)
+	copy(key, packagePrefix)
+	binary.BigEndian.PutUint64(key[len(packagePrefix):], identifier)
+	return key
+}
+
+// lastPackageKey returns the key of the identifier of the package issued
+// last to the address on the network
+func lastPackageKey(network string, to common.Address) []byte {
+	return append([]byte(lastPackagePrefix+network+"/"), to.Bytes()...)
+}
+
+// SetPackage implements Storage, the package is indexed as the last one of
+// its recipient unless a later one is stored
+func (kv *KV) SetPackage(pkg *Package) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		last, err := lastPackage(tx, pkg.Network, pkg.To)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return err
+		case last.IssuedAt.After(pkg.IssuedAt):
+			return set(tx, packageKey(pkg.Identifier), pkg)
+		}
+		if err := set(tx, packageKey(pkg.Identifier), pkg); err != nil {
+			return err
+		}
+		return tx.Set(lastPackageKey(pkg.Network, pkg.To), packageKey(pkg.Identifier)[len(packagePrefix):])
+	})
+}
+
+// lastPackage returns the package issued last to the address on the network
+func lastPackage(tx db.ReadTx, network string, to common.Address) (*Package, error) {
+	identifier, err := tx.Get(lastPackageKey(network, to))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, fmt.Errorf("last package of %s on %s: %w", to.Hex(), network, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pkg := &Package{}
+	if err := get(tx, append([]byte(packagePrefix), identifier...), pkg); err != nil {
+		return nil, fmt.Errorf("package: %w", err)
+	}
+	return pkg, nil
+}
+
+// Package implements Storage
+func (kv *KV) Package(identifier uint64) (*Package, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	pkg := &Package{}
+	if err := get(tx, packageKey(identifier), pkg); err != nil {
+		return nil, fmt.Errorf("package %d: %w", identifier, err)
+	}
+	return pkg, nil
+}
+
+// LastPackage implements Storage
+func (kv *KV) LastPackage(network string, to common.Address) (*Package, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	return lastPackage(tx, network, to)
+}
+
+// Packages implements Storage
+func (kv *KV) Packages() ([]*Package, error) {
+	packages := []*Package{}
+	var err error
+	if ierr := kv.db.Iterate([]byte(packagePrefix), func(_, value []byte) bool {
+		pkg := &Package{}
+		if err = json.Unmarshal(value, pkg); err != nil {
+			return false
+		}
+		packages = append(packages, pkg)
+		return true
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+	sortPackages(packages)
+	return packages, nil
+}
+
+// listEntryKey returns the key of the entry of the list with the given key
+func listEntryKey(list, key string) []byte {
+	return []byte(listEntryPrefix + list + "/" + key)
+}
+
+// SetListEntry implements Storage
+func (kv *KV) SetListEntry(entry *ListEntry) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, listEntryKey(entry.List, entry.Key), entry)
+	})
+}
+
+// ListEntry implements Storage
+func (kv *KV) ListEntry(list, key string) (*ListEntry, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	entry := &ListEntry{}
+	if err := get(tx, listEntryKey(list, key), entry); err != nil {
+		return nil, fmt.Errorf("%s list entry %s: %w", list, key, err)
+	}
+	return entry, nil
+}
+
+// DeleteListEntry implements Storage
+func (kv *KV) DeleteListEntry(list, key string) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		_, err := tx.Get(listEntryKey(list, key))
+		if errors.Is(err, db.ErrKeyNotFound) {
+			return fmt.Errorf("%s list entry %s: %w", list, key, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Delete(listEntryKey(list, key))
+	})
+}
+
+// ListEntries implements Storage, the entries are iterated in the order of
+// their keys
+func (kv *KV) ListEntries(list string) ([]*ListEntry, error) {
+	entries := []*ListEntry{}
+	var err error
+	if ierr := kv.db.Iterate(listEntryKey(list, ""), func(_, value []byte) bool {
+		entry := &ListEntry{}
+		if err = json.Unmarshal(value, entry); err != nil {
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	}); ierr != nil {
+		return nil, ierr
+	}
+	return entries, err
+}
+
+// Secret implements Storage
+func (kv *KV) Secret(name string, secret []byte) ([]byte, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	var stored []byte
+	err := kv.update(func(tx db.WriteTx) error {
+		data, err := tx.Get([]byte(secretPrefix + name))
+		if err == nil {
+			stored = append([]byte{}, data...)
+			return nil
+		}
+		if !errors.Is(err, db.ErrKeyNotFound) {
+			return err
+		}
+		stored = append([]byte{}, secret...)
+		return tx.Set([]byte(secretPrefix+name), secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// UseNonce implements Storage
+func (kv *KV) UseNonce(nonce string, expiresAt time.Time) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		_, err := tx.Get([]byte(noncePrefix + nonce))
+		if err == nil {
+			return fmt.Errorf("%w: nonce %s already used", ErrConflict, nonce)
+		}
+		if !errors.Is(err, db.ErrKeyNotFound) {
+			return err
+		}
+		return set(tx, []byte(noncePrefix+nonce), expiresAt)
+	})
+}
+
+// DeleteNonces implements Storage
+func (kv *KV) DeleteNonces(before time.Time) error {
+	return kv.deleteExpired(noncePrefix, before, func(value []byte) (time.Time, error) {
+		var expiresAt time.Time
+		err := json.Unmarshal(value, &expiresAt)
+		return expiresAt, err
+	})
+}
+
+// deleteExpired deletes the items of the prefix expired before the given
+// time, with their expiration decoded by the given function
+func (kv *KV) deleteExpired(prefix string, before time.Time,
+	expiration func(value []byte) (time.Time, error),
+) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	expired := [][]byte{}
+	var err error
+	if ierr := kv.db.Iterate([]byte(prefix), func(key, value []byte) bool {
+		var expiresAt time.Time
+		if expiresAt, err = expiration(value); err != nil {
+			return false
+		}
+		if expiresAt.Before(before) {
+			expired = append(expired, append([]byte(prefix), key...))
+		}
+		return true
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return err
+	}
+	return kv.update(func(tx db.WriteTx) error {
+		for _, key := range expired {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetSession implements Storage
+func (kv *KV) SetSession(session *Session) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, []byte(sessionPrefix+session.Token), session)
+	})
+}
+
+// Session implements Storage
+func (kv *KV) Session(token string) (*Session, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	session := &Session{}
+	if err := get(tx, []byte(sessionPrefix+token), session); err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	return session, nil
+}
+
+// DeleteSessions implements Storage
+func (kv *KV) DeleteSessions(before time.Time) error {
+	return kv.deleteExpired(sessionPrefix, before, func(value []byte) (time.Time, error) {
+		session := &Session{}
+		err := json.Unmarshal(value, session)
+		return session.ExpiresAt, err
+	})
+}
+
+// SetRecipientGrant implements Storage
+func (kv *KV) SetRecipientGrant(grant *RecipientGrant) error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.update(func(tx db.WriteTx) error {
+		return set(tx, []byte(grantPrefix+grant.Key), grant)
+	})
+}
+
+// RecipientGrant implements Storage
+func (kv *KV) RecipientGrant(key string) (*RecipientGrant, error) {
+	tx := kv.db.ReadTx()
+	defer tx.Discard()
+	grant := &RecipientGrant{}
+	if err := get(tx, []byte(grantPrefix+key), grant); err != nil {
+		return nil, fmt.Errorf("recipient grant %s: %w", key, err)
+	}
+	return grant, nil
+}
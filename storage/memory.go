@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Memory is a Storage keeping the state in memory, for the tests and the
+// deployments that do not need to persist it
+type Memory struct {
+	claims     map[string]Claim
+	tokens     map[string]Token
+	pendingTxs map[common.Hash]PendingTx
+	audit      []AuditRecord
+	leases     map[string]Lease
+	sends      map[string]QueuedSend
+	signerKeys map[string]SignerKey
+	packages   map[uint64]Package
+	lists      map[string]map[string]ListEntry
+	secrets    map[string][]byte
+	nonces     map[string]time.Time
+	sessions   map[string]Session
+	grants     map[string]RecipientGrant
+	lock       sync.Mutex
+}
+
+// NewMemory returns an empty in-memory Storage
+func NewMemory() *Memory {
+	return &Memory{
+		claims:     make(map[string]Claim),
+		tokens:     make(map[string]Token),
+		pendingTxs: make(map[common.Hash]PendingTx),
+		leases:     make(map[string]Lease),
+		sends:      make(map[string]QueuedSend),
+		signerKeys: make(map[string]SignerKey),
+		packages:   make(map[uint64]Package),
+		lists:      make(map[string]map[string]ListEntry),
+		secrets:    make(map[string][]byte),
+		nonces:     make(map[string]time.Time),
+		sessions:   make(map[string]Session),
+		grants:     make(map[string]RecipientGrant),
+	}
+}
+
+// Close implements Storage
+func (m *Memory) Close() error {
+	return nil
+}
+
+// SchemaVersion implements Storage, the memory is always on the current version
+func (m *Memory) SchemaVersion() (uint32, error) {
+	return SchemaVersion, nil
+}
+
+// Claim implements Storage
+func (m *Memory) Claim(key string) (*Claim, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	claim, ok := m.claims[key]
+	if !ok {
+		return nil, fmt.Errorf("claim %s: %w", key, ErrNotFound)
+	}
+	return &claim, nil
+}
+
+// ReserveClaim implements Storage
+func (m *Memory) ReserveClaim(key string, cooldown time.Duration) (*Claim, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	last, ok := m.claims[key]
+	if ok && now.Sub(last.At) < cooldown {
+		return nil, &CooldownError{NextAt: last.At.Add(cooldown)}
+	}
+	m.claims[key] = Claim{Key: key, At: now, Count: last.Count + 1}
+	if !ok {
+		return nil, nil
+	}
+	return &last, nil
+}
+
+// ReleaseClaim implements Storage
+func (m *Memory) ReleaseClaim(key string, previous *Claim) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if previous == nil {
+		delete(m.claims, key)
+		return nil
+	}
+	m.claims[key] = *previous
+	return nil
+}
+
+// Token implements Storage
+func (m *Memory) Token(token string) (*Token, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.tokens[token]
+	if !ok {
+		return nil, fmt.Errorf("token: %w", ErrNotFound)
+	}
+	return &t, nil
+}
+
+// SetToken implements Storage
+func (m *Memory) SetToken(token *Token) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tokens[token.Token] = *token
+	return nil
+}
+
+// UseToken implements Storage
+func (m *Memory) UseToken(token string) (*Token, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.tokens[token]
+	if !ok {
+		return nil, fmt.Errorf("token: %w", ErrNotFound)
+	}
+	if t.Remaining == 0 {
+		return nil, ErrQuotaExceeded
+	}
+	t.Remaining--
+	m.tokens[token] = t
+	return &t, nil
+}
+
+// AddPendingTx implements Storage
+func (m *Memory) AddPendingTx(tx *PendingTx) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pendingTxs[tx.Hash] = *tx
+	return nil
+}
+
+// RemovePendingTx implements Storage
+func (m *Memory) RemovePendingTx(hash common.Hash) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.pendingTxs, hash)
+	return nil
+}
+
+// PendingTxs implements Storage
+func (m *Memory) PendingTxs() ([]*PendingTx, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	txs := []*PendingTx{}
+	for _, tx := range m.pendingTxs {
+		tx := tx
+		txs = append(txs, &tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return bytes.Compare(txs[i].Hash[:], txs[j].Hash[:]) < 0 })
+	return txs, nil
+}
+
+// AppendAudit implements Storage
+func (m *Memory) AppendAudit(record *AuditRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if record.Seq != uint64(len(m.audit))+1 {
+		return fmt.Errorf("%w: audit record %d does not follow %d", ErrConflict, record.Seq, len(m.audit))
+	}
+	m.audit = append(m.audit, AuditRecord{Seq: record.Seq, Data: append([]byte{}, record.Data...)})
+	return nil
+}
+
+// AuditRecords implements Storage
+func (m *Memory) AuditRecords(from uint64) ([]*AuditRecord, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	records := []*AuditRecord{}
+	for _, record := range m.audit {
+		if record.Seq >= from {
+			record := record
+			records = append(records, &record)
+		}
+	}
+	return records, nil
+}
+
+// AcquireLease implements Storage
+func (m *Memory) AcquireLease(name, holder string, ttl time.Duration) (*Lease, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	if lease, ok := m.leases[name]; ok && lease.Holder != holder && now.Before(lease.ExpiresAt) {
+		return nil, leaseHeldError(&lease)
+	}
+	lease := Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+	m.leases[name] = lease
+	return &lease, nil
+}
+
+// ReleaseLease implements Storage
+func (m *Memory) ReleaseLease(name, holder string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if lease, ok := m.leases[name]; ok && lease.Holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+// EnqueueSend implements Storage
+func (m *Memory) EnqueueSend(send *QueuedSend) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sends[send.ID] = *send
+	return nil
+}
+
+// TakeSend implements Storage
+func (m *Memory) TakeSend(queue, holder string) (*QueuedSend, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	waiting := []QueuedSend{}
+	for _, send := range m.sends {
+		if send.Queue == queue && send.Holder == "" {
+			waiting = append(waiting, send)
+		}
+	}
+	send := oldestSend(waiting)
+	if send == nil {
+		return nil, fmt.Errorf("queue %s: %w", queue, ErrNotFound)
+	}
+	send.Holder = holder
+	m.sends[send.ID] = *send
+	return send, nil
+}
+
+// CompleteSend implements Storage
+func (m *Memory) CompleteSend(send *QueuedSend) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, ok := m.sends[send.ID]
+	if !ok {
+		return fmt.Errorf("send %s: %w", send.ID, ErrNotFound)
+	}
+	stored.TxHash, stored.Error, stored.DoneAt = send.TxHash, send.Error, send.DoneAt
+	m.sends[send.ID] = stored
+	return nil
+}
+
+// QueuedSend implements Storage
+func (m *Memory) QueuedSend(id string) (*QueuedSend, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	send, ok := m.sends[id]
+	if !ok {
+		return nil, fmt.Errorf("send %s: %w", id, ErrNotFound)
+	}
+	return &send, nil
+}
+
+// CancelSend implements Storage
+func (m *Memory) CancelSend(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if send, ok := m.sends[id]; ok && send.Holder != "" {
+		return fmt.Errorf("%w: send %s taken by %s", ErrConflict, id, send.Holder)
+	}
+	delete(m.sends, id)
+	return nil
+}
+
+// DeleteSend implements Storage
+func (m *Memory) DeleteSend(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sends, id)
+	return nil
+}
+
+// SignerKeys implements Storage
+func (m *Memory) SignerKeys(scope string) ([]*SignerKey, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	keys := []*SignerKey{}
+	for _, key := range m.signerKeys {
+		if key.Scope == scope {
+			key := key
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i].Address[:], keys[j].Address[:]) < 0 })
+	return keys, nil
+}
+
+// SetSignerKey implements Storage
+func (m *Memory) SetSignerKey(key *SignerKey) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.signerKeys[string(signerKeyKey(key.Scope, key.Address))] = *key
+	return nil
+}
+
+// SetPackage implements Storage
+func (m *Memory) SetPackage(pkg *Package) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored := *pkg
+	stored.Data = append([]byte{}, pkg.Data...)
+	m.packages[pkg.Identifier] = stored
+	return nil
+}
+
+// Package implements Storage
+func (m *Memory) Package(identifier uint64) (*Package, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pkg, ok := m.packages[identifier]
+	if !ok {
+		return nil, fmt.Errorf("package %d: %w", identifier, ErrNotFound)
+	}
+	return &pkg, nil
+}
+
+// LastPackage implements Storage
+func (m *Memory) LastPackage(network string, to common.Address) (*Package, error) {
+	packages, err := m.Packages()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(packages) - 1; i >= 0; i-- {
+		if packages[i].Network == network && packages[i].To == to {
+			return packages[i], nil
+		}
+	}
+	return nil, fmt.Errorf("last package of %s on %s: %w", to.Hex(), network, ErrNotFound)
+}
+
+// Packages implements Storage
+func (m *Memory) Packages() ([]*Package, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	packages := []*Package{}
+	for _, pkg := range m.packages {
+		pkg := pkg
+		packages = append(packages, &pkg)
+	}
+	sortPackages(packages)
+	return packages, nil
+}
+
+// SetListEntry implements Storage
+func (m *Memory) SetListEntry(entry *ListEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.lists[entry.List] == nil {
+		m.lists[entry.List] = make(map[string]ListEntry)
+	}
+	stored := *entry
+	stored.Data = append([]byte{}, entry.Data...)
+	m.lists[entry.List][entry.Key] = stored
+	return nil
+}
+
+// ListEntry implements Storage
+func (m *Memory) ListEntry(list, key string) (*ListEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.lists[list][key]
+	if !ok {
+		return nil, fmt.Errorf("%s list entry %s: %w", list, key, ErrNotFound)
+	}
+	return &entry, nil
+}
+
+// DeleteListEntry implements Storage
+func (m *Memory) DeleteListEntry(list, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.lists[list][key]; !ok {
+		return fmt.Errorf("%s list entry %s: %w", list, key, ErrNotFound)
+	}
+	delete(m.lists[list], key)
+	return nil
+}
+
+// ListEntries implements Storage
+func (m *Memory) ListEntries(list string) ([]*ListEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entries := []*ListEntry{}
+	for _, entry := range m.lists[list] {
+		entry := entry
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// Secret implements Storage
+func (m *Memory) Secret(name string, secret []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.secrets[name]; !ok {
+		m.secrets[name] = append([]byte{}, secret...)
+	}
+	return append([]byte{}, m.secrets[name]...), nil
+}
+
+// UseNonce implements Storage
+func (m *Memory) UseNonce(nonce string, expiresAt time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.nonces[nonce]; ok {
+		return fmt.Errorf("%w: nonce %s already used", ErrConflict, nonce)
+	}
+	m.nonces[nonce] = expiresAt
+	return nil
+}
+
+// DeleteNonces implements Storage
+func (m *Memory) DeleteNonces(before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for nonce, expiresAt := range m.nonces {
+		if expiresAt.Before(before) {
+			delete(m.nonces, nonce)
+		}
+	}
+	return nil
+}
+
+// SetSession implements Storage
+func (m *Memory) SetSession(session *Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sessions[session.Token] = *session
+	return nil
+}
+
+// Session implements Storage
+func (m *Memory) Session(token string) (*Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	session, ok := m.sessions[token]
+	if !ok {
+		return nil, fmt.Errorf("session: %w", ErrNotFound)
+	}
+	return &session, nil
+}
+
+// DeleteSessions implements Storage
+func (m *Memory) DeleteSessions(before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for token, session := range m.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(m.sessions, token)
+		}
+	}
+	return nil
+}
+
+// SetRecipientGrant implements Storage
+func (m *Memory) SetRecipientGrant(grant *RecipientGrant) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.grants[grant.Key] = *grant
+	return nil
+}
+
+// RecipientGrant implements Storage
+func (m *Memory) RecipientGrant(key string) (*RecipientGrant, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	grant, ok := m.grants[key]
+	if !ok {
+		return nil, fmt.Errorf("recipient grant %s: %w", key, ErrNotFound)
+	}
+	return &grant, nil
+}
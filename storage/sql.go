@@ -0,0 +1,856 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	// postgres driver of the storage shared by the faucet replicas
+	"github.com/lib/pq"
+	"go.vocdoni.io/dvote/log"
+)
+
+// sqlMigrations are the statements of the schema versions of the SQL
+// storage, indexed by version minus one. The statements are portable
+// between Postgres and SQLite, and a version is never changed once
+// released, the changes need a new one
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE IF NOT EXISTS claims (
+			claim_key TEXT PRIMARY KEY,
+			granted_at BIGINT NOT NULL,
+			grants BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS tokens (
+			token TEXT PRIMARY KEY,
+			tier TEXT NOT NULL,
+			remaining BIGINT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS pending_txs (
+			hash TEXT PRIMARY KEY,
+			signer TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			amount TEXT NOT NULL,
+			sent_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_records (
+			seq BIGINT PRIMARY KEY,
+			data BYTEA NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS send_queue (
+			id TEXT PRIMARY KEY,
+			queue TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			amount TEXT NOT NULL,
+			queued_at BIGINT NOT NULL,
+			holder TEXT NOT NULL,
+			tx_hash TEXT NOT NULL,
+			send_error TEXT NOT NULL,
+			done_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS send_queue_waiting ON send_queue (queue, holder, queued_at)`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS signer_keys (
+			scope TEXT NOT NULL,
+			address TEXT NOT NULL,
+			state TEXT NOT NULL,
+			updated_at BIGINT NOT NULL,
+			PRIMARY KEY (scope, address)
+		)`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS packages (
+			identifier TEXT PRIMARY KEY,
+			network TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			issued_at BIGINT NOT NULL,
+			data BYTEA NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS packages_recipient ON packages (network, recipient, issued_at)`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS list_entries (
+			list TEXT NOT NULL,
+			entry_key TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			PRIMARY KEY (list, entry_key)
+		)`,
+	},
+	{
+		`CREATE TABLE IF NOT EXISTS secrets (
+			name TEXT PRIMARY KEY,
+			secret BYTEA NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS used_nonces (
+			nonce TEXT PRIMARY KEY,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token TEXT PRIMARY KEY,
+			address TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS recipient_grants (
+			grant_key TEXT PRIMARY KEY,
+			block BIGINT NOT NULL,
+			nonce BIGINT NOT NULL
+		)`,
+	},
+}
+
+// SQL is a Storage backed by an SQL database, such as Postgres, that can be
+// shared by several faucet replicas. The checks and the writes of the
+// claims and the quotas are atomic in the database, so the replicas cannot
+// grant the same claim concurrently
+type SQL struct {
+	db *sql.DB
+	// now is the expression of the current unix time in nanoseconds of the
+	// database clock, so the replicas do not depend on their own clocks
+	now string
+}
+
+const (
+	// postgresNow current unix time in nanoseconds of the Postgres clock
+	postgresNow = `CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000000000 AS BIGINT)`
+	// sqliteNow current unix time in nanoseconds of the SQLite clock, in
+	// milliseconds precision
+	sqliteNow = `CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000`
+)
+
+// OpenSQL opens a Storage backed by the SQL database of the given driver
+// (i.e postgres) and data source name, migrating its data to the current
+// schema version
+func OpenSQL(driver, dsn string) (*SQL, error) {
+	database, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Ping(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("cannot connect to the %s database: %w", driver, err)
+	}
+	s, err := NewSQL(database)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQL returns a Storage backed by the given SQL database, migrating its
+// data to the current schema version. The database is closed with the Storage
+func NewSQL(database *sql.DB) (*SQL, error) {
+	s := &SQL{db: database, now: sqliteNow}
+	if _, ok := database.Driver().(*pq.Driver); ok {
+		s.now = postgresNow
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the migrations newer than the stored schema version, each
+// one in its own transaction. The transaction starts by moving the version
+// forward, so the replicas starting at the same time wait for each other
+// and apply every migration only once
+func (s *SQL) migrate() error {
+	if len(sqlMigrations) != SchemaVersion {
+		return fmt.Errorf("%w: no SQL migration for version %d", ErrSchemaVersion, SchemaVersion)
+	}
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		id INTEGER PRIMARY KEY,
+		version BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("cannot create the schema version: %w", err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO schema_version (id, version) VALUES (1, 0)
+		ON CONFLICT (id) DO NOTHING`); err != nil {
+		return fmt.Errorf("cannot create the schema version: %w", err)
+	}
+	for {
+		current, err := s.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		if current > SchemaVersion {
+			return fmt.Errorf("%w: stored %d, supported up to %d", ErrSchemaVersion, current, SchemaVersion)
+		}
+		if current == SchemaVersion {
+			return nil
+		}
+		migrated, err := s.migrateTo(current + 1)
+		if err != nil {
+			return fmt.Errorf("cannot migrate to schema version %d: %w", current+1, err)
+		}
+		if migrated {
+			log.Infof("storage migrated to schema version %d: %s", current+1, migrations[current].description)
+		}
+	}
+}
+
+// migrateTo applies the migration of the given version, false if another
+// replica applied it meanwhile
+func (s *SQL) migrateTo(version uint32) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	result, err := tx.Exec(`UPDATE schema_version SET version = $1 WHERE id = 1 AND version = $2`,
+		version, version-1)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	for _, statement := range sqlMigrations[version-1] {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// Close implements Storage
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+// SchemaVersion implements Storage, 0 means a new database
+func (s *SQL) SchemaVersion() (uint32, error) {
+	var version int64
+	err := s.db.QueryRow(`SELECT version FROM schema_version WHERE id = 1`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version < 0 || version > int64(^uint32(0)) {
+		return 0, fmt.Errorf("%w: invalid stored version %d", ErrSchemaVersion, version)
+	}
+	return uint32(version), nil
+}
+
+// unixNano returns the time as nanoseconds since the Unix epoch, 0 for the
+// zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano returns the time of the nanoseconds since the Unix epoch, the
+// zero time for 0
+func fromUnixNano(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// Claim implements Storage
+func (s *SQL) Claim(key string) (*Claim, error) {
+	var at, grants int64
+	err := s.db.QueryRow(`SELECT granted_at, grants FROM claims WHERE claim_key = $1`, key).Scan(&at, &grants)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("claim %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Claim{Key: key, At: fromUnixNano(at), Count: uint64(grants)}, nil
+}
+
+// ReserveClaim implements Storage. The grant is written only if the stored
+// claim is still the checked one, so if another replica grants the key
+// between the check and the write, the cooldown is checked again
+func (s *SQL) ReserveClaim(key string, cooldown time.Duration) (*Claim, error) {
+	for {
+		now := time.Now()
+		last, err := s.Claim(key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			last = nil
+		case err != nil:
+			return nil, err
+		case now.Sub(last.At) < cooldown:
+			return nil, &CooldownError{NextAt: last.At.Add(cooldown)}
+		}
+		var result sql.Result
+		if last == nil {
+			result, err = s.db.Exec(`INSERT INTO claims (claim_key, granted_at, grants) VALUES ($1, $2, 1)
+				ON CONFLICT (claim_key) DO NOTHING`, key, now.UnixNano())
+		} else {
+			result, err = s.db.Exec(`UPDATE claims SET granted_at = $1, grants = $2
+				WHERE claim_key = $3 AND granted_at = $4 AND grants = $5`,
+				now.UnixNano(), int64(last.Count+1), key, unixNano(last.At), int64(last.Count))
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			return last, nil
+		}
+	}
+}
+
+// ReleaseClaim implements Storage
+func (s *SQL) ReleaseClaim(key string, previous *Claim) error {
+	if previous == nil {
+		_, err := s.db.Exec(`DELETE FROM claims WHERE claim_key = $1`, key)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO claims (claim_key, granted_at, grants) VALUES ($1, $2, $3)
+		ON CONFLICT (claim_key) DO UPDATE SET granted_at = excluded.granted_at, grants = excluded.grants`,
+		key, unixNano(previous.At), int64(previous.Count))
+	return err
+}
+
+// Token implements Storage
+func (s *SQL) Token(token string) (*Token, error) {
+	t := &Token{Token: token}
+	var remaining, createdAt int64
+	err := s.db.QueryRow(`SELECT tier, remaining, created_at FROM tokens WHERE token = $1`, token).
+		Scan(&t.Tier, &remaining, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("token: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Remaining = uint64(remaining)
+	t.CreatedAt = fromUnixNano(createdAt)
+	return t, nil
+}
+
+// SetToken implements Storage
+func (s *SQL) SetToken(token *Token) error {
+	_, err := s.db.Exec(`INSERT INTO tokens (token, tier, remaining, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token) DO UPDATE SET tier = excluded.tier, remaining = excluded.remaining,
+		created_at = excluded.created_at`,
+		token.Token, token.Tier, int64(token.Remaining), unixNano(token.CreatedAt))
+	return err
+}
+
+// UseToken implements Storage, the quota is decremented in a single
+// statement so the replicas cannot exceed it concurrently
+func (s *SQL) UseToken(token string) (*Token, error) {
+	t := &Token{Token: token}
+	var remaining, createdAt int64
+	err := s.db.QueryRow(`UPDATE tokens SET remaining = remaining - 1 WHERE token = $1 AND remaining > 0
+		RETURNING tier, remaining, created_at`, token).Scan(&t.Tier, &remaining, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.Token(token); err != nil {
+			return nil, err
+		}
+		return nil, ErrQuotaExceeded
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Remaining = uint64(remaining)
+	t.CreatedAt = fromUnixNano(createdAt)
+	return t, nil
+}
+
+// AddPendingTx implements Storage
+func (s *SQL) AddPendingTx(ptx *PendingTx) error {
+	_, err := s.db.Exec(`INSERT INTO pending_txs (hash, signer, recipient, amount, sent_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hash) DO UPDATE SET signer = excluded.signer, recipient = excluded.recipient,
+		amount = excluded.amount, sent_at = excluded.sent_at`,
+		ptx.Hash.Hex(), ptx.Signer.Hex(), ptx.To.Hex(), strconv.FormatUint(ptx.Amount, 10), unixNano(ptx.SentAt))
+	return err
+}
+
+// RemovePendingTx implements Storage
+func (s *SQL) RemovePendingTx(hash common.Hash) error {
+	_, err := s.db.Exec(`DELETE FROM pending_txs WHERE hash = $1`, hash.Hex())
+	return err
+}
+
+// PendingTxs implements Storage, the hashes are stored as lowercase hex of
+// the same length so their order is the order of the bytes
+func (s *SQL) PendingTxs() ([]*PendingTx, error) {
+	rows, err := s.db.Query(`SELECT hash, signer, recipient, amount, sent_at FROM pending_txs ORDER BY hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	txs := []*PendingTx{}
+	for rows.Next() {
+		var hash, signer, to, amount string
+		var sentAt int64
+		if err := rows.Scan(&hash, &signer, &to, &amount, &sentAt); err != nil {
+			return nil, err
+		}
+		ptx := &PendingTx{
+			Hash:   common.HexToHash(hash),
+			Signer: common.HexToAddress(signer),
+			To:     common.HexToAddress(to),
+			SentAt: fromUnixNano(sentAt),
+		}
+		if ptx.Amount, err = strconv.ParseUint(amount, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid amount of pending tx %s: %w", hash, err)
+		}
+		txs = append(txs, ptx)
+	}
+	return txs, rows.Err()
+}
+
+// AppendAudit implements Storage. If another replica appends the same
+// sequence concurrently only one of the records is kept
+func (s *SQL) AppendAudit(record *AuditRecord) error {
+	var last int64
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM audit_records`).Scan(&last); err != nil {
+		return err
+	}
+	if record.Seq != uint64(last)+1 {
+		return fmt.Errorf("%w: audit record %d does not follow %d", ErrConflict, record.Seq, last)
+	}
+	result, err := s.db.Exec(`INSERT INTO audit_records (seq, data) VALUES ($1, $2) ON CONFLICT (seq) DO NOTHING`,
+		int64(record.Seq), record.Data)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: audit record %d already exists", ErrConflict, record.Seq)
+	}
+	return nil
+}
+
+// AuditRecords implements Storage
+func (s *SQL) AuditRecords(from uint64) ([]*AuditRecord, error) {
+	rows, err := s.db.Query(`SELECT seq, data FROM audit_records WHERE seq >= $1 ORDER BY seq`, int64(from))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []*AuditRecord{}
+	for rows.Next() {
+		var seq int64
+		record := &AuditRecord{}
+		if err := rows.Scan(&seq, &record.Data); err != nil {
+			return nil, err
+		}
+		record.Seq = uint64(seq)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// AcquireLease implements Storage, the lease is only written if it is free,
+// expired or already held by the holder, in a single statement so two
+// holders cannot acquire it concurrently. The expirations are taken from the
+// database clock, so the skew between the clocks of the replicas cannot let
+// two of them hold the lease
+func (s *SQL) AcquireLease(name, holder string, ttl time.Duration) (*Lease, error) {
+	lease := &Lease{Name: name, Holder: holder}
+	var expiresAt int64
+	err := s.db.QueryRow(`INSERT INTO leases (name, holder, expires_at) VALUES ($1, $2, `+s.now+` + $3)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= `+s.now+`
+		RETURNING expires_at`,
+		name, holder, int64(ttl)).Scan(&expiresAt)
+	if err == nil {
+		lease.ExpiresAt = fromUnixNano(expiresAt)
+		return lease, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err := s.db.QueryRow(`SELECT holder, expires_at FROM leases WHERE name = $1`, name).
+		Scan(&lease.Holder, &expiresAt); err != nil {
+		return nil, err
+	}
+	lease.ExpiresAt = fromUnixNano(expiresAt)
+	return nil, leaseHeldError(lease)
+}
+
+// ReleaseLease implements Storage
+func (s *SQL) ReleaseLease(name, holder string) error {
+	_, err := s.db.Exec(`DELETE FROM leases WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
+
+// EnqueueSend implements Storage
+func (s *SQL) EnqueueSend(send *QueuedSend) error {
+	txHash := ""
+	if send.TxHash != nil {
+		txHash = send.TxHash.Hex()
+	}
+	_, err := s.db.Exec(`INSERT INTO send_queue
+		(id, queue, recipient, amount, queued_at, holder, tx_hash, send_error, done_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		send.ID, send.Queue, send.To.Hex(), strconv.FormatUint(send.Amount, 10), unixNano(send.QueuedAt),
+		send.Holder, txHash, send.Error, unixNano(send.DoneAt))
+	return err
+}
+
+// sendColumns are the columns of the sends scanned by scanSend
+const sendColumns = `id, queue, recipient, amount, queued_at, holder, tx_hash, send_error, done_at`
+
+// scanSend scans a send selected with sendColumns
+func scanSend(row *sql.Row) (*QueuedSend, error) {
+	send := &QueuedSend{}
+	var to, amount, txHash string
+	var queuedAt, doneAt int64
+	if err := row.Scan(&send.ID, &send.Queue, &to, &amount, &queuedAt,
+		&send.Holder, &txHash, &send.Error, &doneAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if send.Amount, err = strconv.ParseUint(amount, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid amount of send %s: %w", send.ID, err)
+	}
+	send.To = common.HexToAddress(to)
+	if txHash != "" {
+		hash := common.HexToHash(txHash)
+		send.TxHash = &hash
+	}
+	send.QueuedAt = fromUnixNano(queuedAt)
+	send.DoneAt = fromUnixNano(doneAt)
+	return send, nil
+}
+
+// TakeSend implements Storage. The send is only assigned if it is still
+// waiting, so if another holder takes it concurrently ErrNotFound is
+// returned and the next waiting one is taken by the next call
+func (s *SQL) TakeSend(queue, holder string) (*QueuedSend, error) {
+	send, err := scanSend(s.db.QueryRow(`UPDATE send_queue SET holder = $1 WHERE holder = '' AND id = (
+			SELECT id FROM send_queue WHERE queue = $2 AND holder = '' ORDER BY queued_at, id LIMIT 1
+		) RETURNING `+sendColumns, holder, queue))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("queue %s: %w", queue, ErrNotFound)
+	}
+	return send, err
+}
+
+// CompleteSend implements Storage
+func (s *SQL) CompleteSend(send *QueuedSend) error {
+	txHash := ""
+	if send.TxHash != nil {
+		txHash = send.TxHash.Hex()
+	}
+	result, err := s.db.Exec(`UPDATE send_queue SET tx_hash = $1, send_error = $2, done_at = $3 WHERE id = $4`,
+		txHash, send.Error, unixNano(send.DoneAt), send.ID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("send %s: %w", send.ID, ErrNotFound)
+	}
+	return nil
+}
+
+// QueuedSend implements Storage
+func (s *SQL) QueuedSend(id string) (*QueuedSend, error) {
+	send, err := scanSend(s.db.QueryRow(`SELECT `+sendColumns+` FROM send_queue WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("send %s: %w", id, ErrNotFound)
+	}
+	return send, err
+}
+
+// CancelSend implements Storage
+func (s *SQL) CancelSend(id string) error {
+	result, err := s.db.Exec(`DELETE FROM send_queue WHERE id = $1 AND holder = ''`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+	send, err := s.QueuedSend(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: send %s taken by %s", ErrConflict, id, send.Holder)
+}
+
+// DeleteSend implements Storage
+func (s *SQL) DeleteSend(id string) error {
+	_, err := s.db.Exec(`DELETE FROM send_queue WHERE id = $1`, id)
+	return err
+}
+
+// SignerKeys implements Storage, the addresses are stored as lowercase hex
+// so their order is the order of the bytes
+func (s *SQL) SignerKeys(scope string) ([]*SignerKey, error) {
+	rows, err := s.db.Query(`SELECT address, state, updated_at FROM signer_keys WHERE scope = $1
+		ORDER BY address`, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*SignerKey{}
+	for rows.Next() {
+		var address string
+		var updatedAt int64
+		key := &SignerKey{Scope: scope}
+		if err := rows.Scan(&address, &key.State, &updatedAt); err != nil {
+			return nil, err
+		}
+		key.Address = common.HexToAddress(address)
+		key.UpdatedAt = fromUnixNano(updatedAt)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SetSignerKey implements Storage
+func (s *SQL) SetSignerKey(key *SignerKey) error {
+	_, err := s.db.Exec(`INSERT INTO signer_keys (scope, address, state, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, address) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at`,
+		key.Scope, strings.ToLower(key.Address.Hex()), key.State, unixNano(key.UpdatedAt))
+	return err
+}
+
+// SetPackage implements Storage
+func (s *SQL) SetPackage(pkg *Package) error {
+	_, err := s.db.Exec(`INSERT INTO packages (identifier, network, recipient, issued_at, data) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (identifier) DO UPDATE SET network = excluded.network, recipient = excluded.recipient,
+		issued_at = excluded.issued_at, data = excluded.data`,
+		strconv.FormatUint(pkg.Identifier, 10), pkg.Network, strings.ToLower(pkg.To.Hex()), unixNano(pkg.IssuedAt), pkg.Data)
+	return err
+}
+
+// Package implements Storage
+func (s *SQL) Package(identifier uint64) (*Package, error) {
+	packages, err := s.packages(`WHERE identifier = $1`, strconv.FormatUint(identifier, 10))
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("package %d: %w", identifier, ErrNotFound)
+	}
+	return packages[0], nil
+}
+
+// LastPackage implements Storage
+func (s *SQL) LastPackage(network string, to common.Address) (*Package, error) {
+	packages, err := s.packages(`WHERE network = $1 AND recipient = $2 ORDER BY issued_at DESC LIMIT 1`,
+		network, strings.ToLower(to.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("last package of %s on %s: %w", to.Hex(), network, ErrNotFound)
+	}
+	return packages[0], nil
+}
+
+// Packages implements Storage
+func (s *SQL) Packages() ([]*Package, error) {
+	packages, err := s.packages(``)
+	if err != nil {
+		return nil, err
+	}
+	sortPackages(packages)
+	return packages, nil
+}
+
+// packages returns the packages selected by the given condition
+func (s *SQL) packages(condition string, args ...interface{}) ([]*Package, error) {
+	rows, err := s.db.Query(`SELECT identifier, network, recipient, issued_at, data FROM packages `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	packages := []*Package{}
+	for rows.Next() {
+		var identifier, to string
+		var issuedAt int64
+		pkg := &Package{}
+		if err := rows.Scan(&identifier, &pkg.Network, &to, &issuedAt, &pkg.Data); err != nil {
+			return nil, err
+		}
+		if pkg.Identifier, err = strconv.ParseUint(identifier, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid package identifier %s: %w", identifier, err)
+		}
+		pkg.To = common.HexToAddress(to)
+		pkg.IssuedAt = fromUnixNano(issuedAt)
+		packages = append(packages, pkg)
+	}
+	return packages, rows.Err()
+}
+
+// SetListEntry implements Storage
+func (s *SQL) SetListEntry(entry *ListEntry) error {
+	_, err := s.db.Exec(`INSERT INTO list_entries (list, entry_key, data) VALUES ($1, $2, $3)
+		ON CONFLICT (list, entry_key) DO UPDATE SET data = excluded.data`,
+		entry.List, entry.Key, entry.Data)
+	return err
+}
+
+// ListEntry implements Storage
+func (s *SQL) ListEntry(list, key string) (*ListEntry, error) {
+	entry := &ListEntry{List: list, Key: key}
+	err := s.db.QueryRow(`SELECT data FROM list_entries WHERE list = $1 AND entry_key = $2`,
+		list, key).Scan(&entry.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s list entry %s: %w", list, key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// DeleteListEntry implements Storage
+func (s *SQL) DeleteListEntry(list, key string) error {
+	result, err := s.db.Exec(`DELETE FROM list_entries WHERE list = $1 AND entry_key = $2`, list, key)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s list entry %s: %w", list, key, ErrNotFound)
+	}
+	return nil
+}
+
+// ListEntries implements Storage
+func (s *SQL) ListEntries(list string) ([]*ListEntry, error) {
+	rows, err := s.db.Query(`SELECT entry_key, data FROM list_entries WHERE list = $1 ORDER BY entry_key`, list)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*ListEntry{}
+	for rows.Next() {
+		entry := &ListEntry{List: list}
+		if err := rows.Scan(&entry.Key, &entry.Data); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Secret implements Storage, if several replicas store a secret
+// concurrently only the first one is kept
+func (s *SQL) Secret(name string, secret []byte) ([]byte, error) {
+	if _, err := s.db.Exec(`INSERT INTO secrets (name, secret) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING`, name, secret); err != nil {
+		return nil, err
+	}
+	var stored []byte
+	if err := s.db.QueryRow(`SELECT secret FROM secrets WHERE name = $1`, name).Scan(&stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// UseNonce implements Storage, the nonce is only inserted if it is not
+// already, in a single statement so two replicas cannot use it concurrently
+func (s *SQL) UseNonce(nonce string, expiresAt time.Time) error {
+	result, err := s.db.Exec(`INSERT INTO used_nonces (nonce, expires_at) VALUES ($1, $2)
+		ON CONFLICT (nonce) DO NOTHING`, nonce, unixNano(expiresAt))
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return fmt.Errorf("%w: nonce %s already used", ErrConflict, nonce)
+	}
+	return nil
+}
+
+// DeleteNonces implements Storage
+func (s *SQL) DeleteNonces(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM used_nonces WHERE expires_at < $1`, unixNano(before))
+	return err
+}
+
+// SetSession implements Storage
+func (s *SQL) SetSession(session *Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (token, address, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET address = excluded.address, expires_at = excluded.expires_at`,
+		session.Token, strings.ToLower(session.Address.Hex()), unixNano(session.ExpiresAt))
+	return err
+}
+
+// Session implements Storage
+func (s *SQL) Session(token string) (*Session, error) {
+	var address string
+	var expiresAt int64
+	err := s.db.QueryRow(`SELECT address, expires_at FROM sessions WHERE token = $1`, token).
+		Scan(&address, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Token: token, Address: common.HexToAddress(address), ExpiresAt: fromUnixNano(expiresAt)}, nil
+}
+
+// DeleteSessions implements Storage
+func (s *SQL) DeleteSessions(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < $1`, unixNano(before))
+	return err
+}
+
+// SetRecipientGrant implements Storage
+func (s *SQL) SetRecipientGrant(grant *RecipientGrant) error {
+	_, err := s.db.Exec(`INSERT INTO recipient_grants (grant_key, block, nonce) VALUES ($1, $2, $3)
+		ON CONFLICT (grant_key) DO UPDATE SET block = excluded.block, nonce = excluded.nonce`,
+		grant.Key, int64(grant.Block), int64(grant.Nonce))
+	return err
+}
+
+// RecipientGrant implements Storage
+func (s *SQL) RecipientGrant(key string) (*RecipientGrant, error) {
+	var block, nonce int64
+	err := s.db.QueryRow(`SELECT block, nonce FROM recipient_grants WHERE grant_key = $1`, key).
+		Scan(&block, &nonce)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("recipient grant %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RecipientGrant{Key: key, Block: uint64(block), Nonce: uint64(nonce)}, nil
+}
@@ -0,0 +1,326 @@
+// Package storage persists the state of the faucet, such as the claims, the
+// bearer tokens, the pending txs, the audit records, the Vocdoni packages,
+// the address lists, the sign in sessions and the states of the signer keys,
+// so it survives the restarts. A shared storage also
+// coordinates the faucet replicas with the leases of the signers and the
+// queue of the forwarded sends.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrNotFound error returned if a stored item does not exist
+	ErrNotFound error = errors.New("not found")
+	// ErrCooldownActive error returned if a claim is reserved before the end of the cooldown
+	ErrCooldownActive error = errors.New("cooldown active")
+	// ErrQuotaExceeded error returned if a token has no requests remaining
+	ErrQuotaExceeded error = errors.New("quota exceeded")
+	// ErrConflict error returned if a write conflicts with the stored data
+	ErrConflict error = errors.New("conflict")
+	// ErrSchemaVersion error returned if the stored data has an unsupported schema version
+	ErrSchemaVersion error = errors.New("unsupported schema version")
+	// ErrLeaseHeld error returned if a lease is held by another holder
+	ErrLeaseHeld error = errors.New("lease held by another holder")
+)
+
+// CooldownError is returned if a claim is reserved before the end of the
+// cooldown, it wraps ErrCooldownActive
+type CooldownError struct {
+	// NextAt is the time from which the claim can be reserved again
+	NextAt time.Time
+}
+
+func (ce *CooldownError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrCooldownActive, ce.NextAt.Format(time.RFC3339))
+}
+
+func (ce *CooldownError) Unwrap() error {
+	return ErrCooldownActive
+}
+
+// Claim represents the last grant of a claim key, such as an address on a network
+type Claim struct {
+	// Key identifies what is claimed (i.e evm/sepolia/0x...)
+	Key string `json:"key"`
+	// At is the time of the last grant
+	At time.Time `json:"at"`
+	// Count is the number of grants
+	Count uint64 `json:"count"`
+}
+
+// Token represents a bearer token and its request quota
+type Token struct {
+	// Token is the bearer token
+	Token string `json:"token"`
+	// Tier is the tier of the token, if any
+	Tier string `json:"tier,omitempty"`
+	// Remaining is the number of requests remaining
+	Remaining uint64 `json:"remaining"`
+	// CreatedAt is the time the token was stored
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PendingTx represents an EVM tx sent and not mined yet
+type PendingTx struct {
+	// Hash is the tx hash
+	Hash common.Hash `json:"hash"`
+	// Signer is the faucet address that signed the tx
+	Signer common.Address `json:"signer"`
+	// To is the recipient of the tx
+	To common.Address `json:"to"`
+	// Amount is the amount sent
+	Amount uint64 `json:"amount"`
+	// SentAt is the time the tx was sent
+	SentAt time.Time `json:"sentAt"`
+}
+
+// AuditRecord represents an encoded audit log entry
+type AuditRecord struct {
+	// Seq is the position of the record, starting from 1
+	Seq uint64 `json:"seq"`
+	// Data is the encoded entry
+	Data []byte `json:"data"`
+}
+
+// Lease represents the exclusive right of a holder, such as a faucet replica,
+// on a resource until it expires
+type Lease struct {
+	// Name identifies the resource (i.e evm/sepolia/signer/0x...)
+	Name string `json:"name"`
+	// Holder identifies the holder of the lease
+	Holder string `json:"holder"`
+	// ExpiresAt is the time the lease expires if it is not renewed
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SignerKey represents the rotation state of a faucet signer key, its
+// private key is never stored
+type SignerKey struct {
+	// Scope groups the keys of the same signers (i.e evm/sepolia)
+	Scope string `json:"scope"`
+	// Address is the address of the key
+	Address common.Address `json:"address"`
+	// State is the rotation state of the key
+	State string `json:"state"`
+	// UpdatedAt is the time of the last state change
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Package represents an encoded faucet package issued by the Vocdoni faucet
+type Package struct {
+	// Identifier is the identifier of the package payload
+	Identifier uint64 `json:"identifier,string"`
+	// Network is the Vocdoni network the package was issued for
+	Network string `json:"network"`
+	// To is the recipient of the package
+	To common.Address `json:"to"`
+	// IssuedAt is the issuance time
+	IssuedAt time.Time `json:"issuedAt"`
+	// Data is the encoded package record
+	Data []byte `json:"data"`
+}
+
+// ListEntry represents an encoded entry of an address list managed with the
+// admin API
+type ListEntry struct {
+	// List is the list of the entry (i.e deny)
+	List string `json:"list"`
+	// Key identifies the entry in its list (i.e sepolia/0x...)
+	Key string `json:"key"`
+	// Data is the encoded entry
+	Data []byte `json:"data"`
+}
+
+// Session represents a sign in session of an address
+type Session struct {
+	// Token is the session token
+	Token string `json:"token"`
+	// Address is the signed in address
+	Address common.Address `json:"address"`
+	// ExpiresAt is the time after which the session is no longer valid
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RecipientGrant represents the last grant to an EVM recipient, from which
+// its later txs are looked for
+type RecipientGrant struct {
+	// Key identifies the recipient (i.e evm/sepolia/0x...)
+	Key string `json:"key"`
+	// Block is the latest block number when the grant was sent
+	Block uint64 `json:"block"`
+	// Nonce is the nonce of the recipient when the grant was sent
+	Nonce uint64 `json:"nonce"`
+}
+
+// QueuedSend represents a send of tokens forwarded through the shared queue
+// to a holder of the signer leases
+type QueuedSend struct {
+	// ID identifies the send
+	ID string `json:"id"`
+	// Queue groups the sends served by the same signers (i.e evm/sepolia)
+	Queue string `json:"queue"`
+	// To is the recipient of the send
+	To common.Address `json:"to"`
+	// Amount is the amount to send
+	Amount uint64 `json:"amount"`
+	// QueuedAt is the time the send was queued
+	QueuedAt time.Time `json:"queuedAt"`
+	// Holder is the holder that took the send, empty while waiting
+	Holder string `json:"holder,omitempty"`
+	// TxHash is the hash of the tx sent, nil if none
+	TxHash *common.Hash `json:"txHash,omitempty"`
+	// Error is the error of the send, if it failed
+	Error string `json:"error,omitempty"`
+	// DoneAt is the time the send finished, zero while not finished
+	DoneAt time.Time `json:"doneAt"`
+}
+
+// Storage persists the faucet state. All methods are safe for concurrent use.
+type Storage interface {
+	io.Closer
+
+	// SchemaVersion returns the schema version of the stored data
+	SchemaVersion() (uint32, error)
+
+	// Claim returns the last claim of the key or ErrNotFound
+	Claim(key string) (*Claim, error)
+	// ReserveClaim records a grant of the key if its last one is older than
+	// the cooldown, or returns a CooldownError. The check and the record are
+	// atomic, so a key cannot be granted twice concurrently. The previous
+	// claim, nil if none, is returned for restoring it if the grant fails
+	ReserveClaim(key string, cooldown time.Duration) (*Claim, error)
+	// ReleaseClaim restores the previous claim of the key returned by
+	// ReserveClaim, a nil claim deletes it
+	ReleaseClaim(key string, previous *Claim) error
+
+	// Token returns the given bearer token or ErrNotFound
+	Token(token string) (*Token, error)
+	// SetToken adds or replaces a bearer token
+	SetToken(token *Token) error
+	// UseToken consumes a request of the token and returns it, or
+	// ErrQuotaExceeded if it has no requests remaining
+	UseToken(token string) (*Token, error)
+
+	// AddPendingTx records a sent tx
+	AddPendingTx(tx *PendingTx) error
+	// RemovePendingTx removes a tx once mined or failed
+	RemovePendingTx(hash common.Hash) error
+	// PendingTxs returns the recorded txs, ordered by hash
+	PendingTxs() ([]*PendingTx, error)
+
+	// AppendAudit appends an audit record, its sequence must follow the last
+	// one or ErrConflict is returned
+	AppendAudit(record *AuditRecord) error
+	// AuditRecords returns the audit records from the given sequence
+	AuditRecords(from uint64) ([]*AuditRecord, error)
+
+	// AcquireLease acquires or renews the lease of the name for the holder
+	// during the ttl, or returns ErrLeaseHeld if another holder has it and
+	// it has not expired
+	AcquireLease(name, holder string, ttl time.Duration) (*Lease, error)
+	// ReleaseLease releases the lease of the name if the holder has it
+	ReleaseLease(name, holder string) error
+
+	// EnqueueSend adds a send to its queue
+	EnqueueSend(send *QueuedSend) error
+	// TakeSend assigns the oldest waiting send of the queue to the holder
+	// and returns it, or ErrNotFound if none is waiting. A send is only
+	// taken once, even by holders taking them concurrently
+	TakeSend(queue, holder string) (*QueuedSend, error)
+	// CompleteSend records the tx hash, the error and the end of a send
+	CompleteSend(send *QueuedSend) error
+	// QueuedSend returns the send or ErrNotFound
+	QueuedSend(id string) (*QueuedSend, error)
+	// CancelSend deletes a send if it is still waiting, or returns
+	// ErrConflict if it is already taken
+	CancelSend(id string) error
+	// DeleteSend deletes a send
+	DeleteSend(id string) error
+
+	// SignerKeys returns the signer keys of the scope, ordered by address
+	SignerKeys(scope string) ([]*SignerKey, error)
+	// SetSignerKey adds or replaces the state of a signer key
+	SetSignerKey(key *SignerKey) error
+
+	// SetPackage adds or replaces an issued package
+	SetPackage(pkg *Package) error
+	// Package returns the package with the given identifier or ErrNotFound
+	Package(identifier uint64) (*Package, error)
+	// LastPackage returns the package issued last to the address on the
+	// network or ErrNotFound
+	LastPackage(network string, to common.Address) (*Package, error)
+	// Packages returns the issued packages, ordered by issuance time
+	Packages() ([]*Package, error)
+
+	// SetListEntry adds or replaces an entry of an address list
+	SetListEntry(entry *ListEntry) error
+	// ListEntry returns the entry of the list with the given key or ErrNotFound
+	ListEntry(list, key string) (*ListEntry, error)
+	// DeleteListEntry deletes the entry of the list with the given key, or
+	// returns ErrNotFound if it does not exist
+	DeleteListEntry(list, key string) error
+	// ListEntries returns the entries of the list, ordered by key
+	ListEntries(list string) ([]*ListEntry, error)
+
+	// Secret returns the secret of the name, storing the given one if there
+	// is none yet, so every caller gets the first secret stored
+	Secret(name string, secret []byte) ([]byte, error)
+	// UseNonce records a single-use nonce valid until the given time, or
+	// returns ErrConflict if it was already used. The check and the record
+	// are atomic, so a nonce cannot be used twice concurrently
+	UseNonce(nonce string, expiresAt time.Time) error
+	// DeleteNonces deletes the used nonces expired before the given time
+	DeleteNonces(before time.Time) error
+
+	// SetSession adds or replaces a sign in session
+	SetSession(session *Session) error
+	// Session returns the session of the token or ErrNotFound, the expired
+	// sessions are returned until deleted
+	Session(token string) (*Session, error)
+	// DeleteSessions deletes the sessions expired before the given time
+	DeleteSessions(before time.Time) error
+
+	// SetRecipientGrant adds or replaces the last grant to a recipient
+	SetRecipientGrant(grant *RecipientGrant) error
+	// RecipientGrant returns the last grant to the recipient or ErrNotFound
+	RecipientGrant(key string) (*RecipientGrant, error)
+}
+
+// leaseHeldError returns the ErrLeaseHeld error of the given lease
+func leaseHeldError(lease *Lease) error {
+	return fmt.Errorf("%w: %s by %s until %s",
+		ErrLeaseHeld, lease.Name, lease.Holder, lease.ExpiresAt.Format(time.RFC3339))
+}
+
+// sortPackages orders the packages by issuance time, by identifier if
+// issued at the same time
+func sortPackages(packages []*Package) {
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].IssuedAt.Equal(packages[j].IssuedAt) {
+			return packages[i].Identifier < packages[j].Identifier
+		}
+		return packages[i].IssuedAt.Before(packages[j].IssuedAt)
+	})
+}
+
+// oldestSend returns the send queued first, by ID if queued at the same
+// time, or nil if there is none
+func oldestSend(sends []QueuedSend) *QueuedSend {
+	var oldest *QueuedSend
+	for i := range sends {
+		send := &sends[i]
+		if oldest == nil || send.QueuedAt.Before(oldest.QueuedAt) ||
+			(send.QueuedAt.Equal(oldest.QueuedAt) && send.ID < oldest.ID) {
+			oldest = send
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,492 @@
+package storage_test
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/pebbledb"
+	"go.vocdoni.io/vocdoni-faucet/storage"
+	// in-process SQL engine for testing the SQL storage
+	_ "modernc.org/sqlite"
+)
+
+func TestMemory(t *testing.T) {
+	testStorage(t, storage.NewMemory())
+}
+
+func TestKV(t *testing.T) {
+	kv, err := storage.OpenKV(t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer kv.Close()
+	testStorage(t, kv)
+}
+
+func TestKVSchema(t *testing.T) {
+	dir := t.TempDir()
+
+	// should create the current schema and keep the data after reopening
+	kv, err := storage.OpenKV(dir)
+	qt.Assert(t, err, qt.IsNil)
+	version, err := kv.SchemaVersion()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, version, qt.Equals, uint32(storage.SchemaVersion))
+	_, err = kv.ReserveClaim("evm/sepolia/0x01", time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, kv.Close(), qt.IsNil)
+	kv, err = storage.OpenKV(dir)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = kv.ReserveClaim("evm/sepolia/0x01", time.Hour)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrCooldownActive)
+	qt.Assert(t, kv.Close(), qt.IsNil)
+
+	// should refuse the data of a newer faucet version
+	database, err := pebbledb.New(db.Options{Path: dir})
+	qt.Assert(t, err, qt.IsNil)
+	tx := database.WriteTx()
+	qt.Assert(t, tx.Set([]byte("meta/schemaVersion"), []byte{0, 0, 0xff, 0xff}), qt.IsNil)
+	qt.Assert(t, tx.Commit(), qt.IsNil)
+	tx.Discard()
+	_, err = storage.NewKV(database)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrSchemaVersion)
+	qt.Assert(t, database.Close(), qt.IsNil)
+}
+
+// openSQLite returns an SQLite database in the given directory, waiting for
+// the locks of the concurrent writes
+func openSQLite(t *testing.T, dir string) *sql.DB {
+	database, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "faucet.db")+"?_pragma=busy_timeout(10000)")
+	qt.Assert(t, err, qt.IsNil)
+	return database
+}
+
+func TestSQL(t *testing.T) {
+	s, err := storage.NewSQL(openSQLite(t, t.TempDir()))
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+	testStorage(t, s)
+}
+
+func TestSQLSchema(t *testing.T) {
+	dir := t.TempDir()
+
+	// should create the current schema and keep the data after reopening
+	s, err := storage.NewSQL(openSQLite(t, dir))
+	qt.Assert(t, err, qt.IsNil)
+	version, err := s.SchemaVersion()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, version, qt.Equals, uint32(storage.SchemaVersion))
+	_, err = s.ReserveClaim("evm/sepolia/0x01", time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, s.Close(), qt.IsNil)
+	s, err = storage.NewSQL(openSQLite(t, dir))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = s.ReserveClaim("evm/sepolia/0x01", time.Hour)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrCooldownActive)
+
+	// should grant a key only once between the replicas sharing the database
+	replica, err := storage.NewSQL(openSQLite(t, dir))
+	qt.Assert(t, err, qt.IsNil)
+	var wg sync.WaitGroup
+	granted := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(s storage.Storage) {
+			defer wg.Done()
+			_, err := s.ReserveClaim("evm/sepolia/0x02", time.Hour)
+			if err == nil {
+				granted <- true
+				return
+			}
+			qt.Check(t, err, qt.ErrorIs, storage.ErrCooldownActive)
+		}([]storage.Storage{s, replica}[i%2])
+	}
+	wg.Wait()
+	close(granted)
+	qt.Assert(t, len(granted), qt.Equals, 1)
+	qt.Assert(t, replica.Close(), qt.IsNil)
+	qt.Assert(t, s.Close(), qt.IsNil)
+
+	// should refuse the data of a newer faucet version
+	database := openSQLite(t, dir)
+	_, err = database.Exec(`UPDATE schema_version SET version = 65535`)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = storage.NewSQL(database)
+	qt.Assert(t, err, qt.ErrorIs, storage.ErrSchemaVersion)
+	qt.Assert(t, database.Close(), qt.IsNil)
+}
+
+// testStorage checks the behaviour shared by the Storage implementations
+func testStorage(t *testing.T, s storage.Storage) {
+	t.Run("claims", func(t *testing.T) {
+		_, err := s.Claim("dev/0x01")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		previous, err := s.ReserveClaim("dev/0x01", time.Hour)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, previous, qt.IsNil)
+		claim, err := s.Claim("dev/0x01")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, claim.Count, qt.Equals, uint64(1))
+
+		// should refuse during the cooldown with its end
+		_, err = s.ReserveClaim("dev/0x01", time.Hour)
+		cooldownErr := &storage.CooldownError{}
+		qt.Assert(t, err, qt.ErrorAs, &cooldownErr)
+		qt.Assert(t, cooldownErr.NextAt.Sub(claim.At), qt.Equals, time.Hour)
+
+		// should accept after the cooldown, and restore the previous claim if released
+		previous, err = s.ReserveClaim("dev/0x01", 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, previous.Count, qt.Equals, uint64(1))
+		qt.Assert(t, s.ReleaseClaim("dev/0x01", previous), qt.IsNil)
+		claim, err = s.Claim("dev/0x01")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, claim.Count, qt.Equals, uint64(1))
+		qt.Assert(t, s.ReleaseClaim("dev/0x01", nil), qt.IsNil)
+		_, err = s.Claim("dev/0x01")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+
+		// should grant a key only once concurrently
+		var wg sync.WaitGroup
+		granted := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.ReserveClaim("dev/0x02", time.Hour); err == nil {
+					granted <- true
+				}
+			}()
+		}
+		wg.Wait()
+		close(granted)
+		qt.Assert(t, len(granted), qt.Equals, 1)
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		_, err := s.UseToken("token")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		qt.Assert(t, s.SetToken(&storage.Token{Token: "token", Tier: "partners", Remaining: 2}), qt.IsNil)
+		token, err := s.UseToken("token")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, token.Remaining, qt.Equals, uint64(1))
+		_, err = s.UseToken("token")
+		qt.Assert(t, err, qt.IsNil)
+		_, err = s.UseToken("token")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrQuotaExceeded)
+		token, err = s.Token("token")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, token.Tier, qt.Equals, "partners")
+		qt.Assert(t, token.Remaining, qt.Equals, uint64(0))
+	})
+
+	t.Run("pending txs", func(t *testing.T) {
+		txs, err := s.PendingTxs()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 0)
+		for _, hash := range []string{"0x02", "0x01"} {
+			qt.Assert(t, s.AddPendingTx(&storage.PendingTx{
+				Hash:   common.HexToHash(hash),
+				Signer: common.HexToAddress("0x0a"),
+				To:     common.HexToAddress("0x0b"),
+				Amount: 100,
+				SentAt: time.Now(),
+			}), qt.IsNil)
+		}
+		txs, err = s.PendingTxs()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 2)
+		qt.Assert(t, txs[0].Hash, qt.Equals, common.HexToHash("0x01"))
+		qt.Assert(t, txs[1].Amount, qt.Equals, uint64(100))
+		qt.Assert(t, s.RemovePendingTx(common.HexToHash("0x01")), qt.IsNil)
+		txs, err = s.PendingTxs()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 1)
+		qt.Assert(t, txs[0].Hash, qt.Equals, common.HexToHash("0x02"))
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		for seq := uint64(1); seq <= 3; seq++ {
+			qt.Assert(t, s.AppendAudit(&storage.AuditRecord{Seq: seq, Data: []byte{byte(seq)}}), qt.IsNil)
+		}
+		// should refuse the records not following the last one
+		err := s.AppendAudit(&storage.AuditRecord{Seq: 3, Data: []byte{3}})
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrConflict)
+		err = s.AppendAudit(&storage.AuditRecord{Seq: 5, Data: []byte{5}})
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrConflict)
+		records, err := s.AuditRecords(2)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, records, qt.DeepEquals, []*storage.AuditRecord{
+			{Seq: 2, Data: []byte{2}},
+			{Seq: 3, Data: []byte{3}},
+		})
+	})
+
+	t.Run("signer keys", func(t *testing.T) {
+		keys, err := s.SignerKeys("evm/sepolia")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, keys, qt.HasLen, 0)
+		for _, address := range []string{"0x0b", "0x0a"} {
+			qt.Assert(t, s.SetSignerKey(&storage.SignerKey{
+				Scope:   "evm/sepolia",
+				Address: common.HexToAddress(address),
+				State:   "incoming",
+			}), qt.IsNil)
+		}
+		qt.Assert(t, s.SetSignerKey(&storage.SignerKey{
+			Scope:   "evm/sepolia",
+			Address: common.HexToAddress("0x0a"),
+			State:   "active",
+		}), qt.IsNil)
+		qt.Assert(t, s.SetSignerKey(&storage.SignerKey{
+			Scope:   "vocdoni/dev",
+			Address: common.HexToAddress("0x0c"),
+			State:   "active",
+		}), qt.IsNil)
+		keys, err = s.SignerKeys("evm/sepolia")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, keys, qt.HasLen, 2)
+		qt.Assert(t, keys[0].Address, qt.Equals, common.HexToAddress("0x0a"))
+		qt.Assert(t, keys[0].State, qt.Equals, "active")
+		qt.Assert(t, keys[1].State, qt.Equals, "incoming")
+	})
+
+	t.Run("packages", func(t *testing.T) {
+		to := common.HexToAddress("0x01")
+		_, err := s.LastPackage("dev", to)
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		issuedAt := time.Unix(1700000000, 0)
+		for i, identifier := range []uint64{math.MaxUint64, 2} {
+			qt.Assert(t, s.SetPackage(&storage.Package{
+				Identifier: identifier,
+				Network:    "dev",
+				To:         to,
+				IssuedAt:   issuedAt.Add(time.Duration(i) * time.Hour),
+				Data:       []byte{byte(i)},
+			}), qt.IsNil)
+		}
+		qt.Assert(t, s.SetPackage(&storage.Package{
+			Identifier: 3,
+			Network:    "stage",
+			To:         to,
+			IssuedAt:   issuedAt.Add(2 * time.Hour),
+			Data:       []byte{3},
+		}), qt.IsNil)
+
+		// should keep the last package after updating an older one
+		qt.Assert(t, s.SetPackage(&storage.Package{
+			Identifier: math.MaxUint64,
+			Network:    "dev",
+			To:         to,
+			IssuedAt:   issuedAt,
+			Data:       []byte{9},
+		}), qt.IsNil)
+		last, err := s.LastPackage("dev", to)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, last.Identifier, qt.Equals, uint64(2))
+		pkg, err := s.Package(math.MaxUint64)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, pkg.Data, qt.DeepEquals, []byte{9})
+		qt.Assert(t, pkg.IssuedAt.Equal(issuedAt), qt.IsTrue)
+		_, err = s.Package(4)
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		packages, err := s.Packages()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, packages, qt.HasLen, 3)
+		qt.Assert(t, packages[0].Identifier, qt.Equals, uint64(math.MaxUint64))
+		qt.Assert(t, packages[2].Network, qt.Equals, "stage")
+	})
+
+	t.Run("list entries", func(t *testing.T) {
+		_, err := s.ListEntry("deny", "dev/0x01")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		for _, key := range []string{"dev/0x02", "/0x01", "dev/0x01"} {
+			qt.Assert(t, s.SetListEntry(&storage.ListEntry{List: "deny", Key: key, Data: []byte(key)}), qt.IsNil)
+		}
+		qt.Assert(t, s.SetListEntry(&storage.ListEntry{List: "allow", Key: "dev/0x01", Data: []byte{1}}), qt.IsNil)
+		qt.Assert(t, s.SetListEntry(&storage.ListEntry{List: "deny", Key: "dev/0x01", Data: []byte{2}}), qt.IsNil)
+		entry, err := s.ListEntry("deny", "dev/0x01")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, entry.Data, qt.DeepEquals, []byte{2})
+		entries, err := s.ListEntries("deny")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, entries, qt.HasLen, 3)
+		qt.Assert(t, entries[0].Key, qt.Equals, "/0x01")
+		qt.Assert(t, entries[2].Key, qt.Equals, "dev/0x02")
+
+		// should delete only the existing entries of the list
+		qt.Assert(t, s.DeleteListEntry("deny", "dev/0x01"), qt.IsNil)
+		qt.Assert(t, s.DeleteListEntry("deny", "dev/0x01"), qt.ErrorIs, storage.ErrNotFound)
+		_, err = s.ListEntry("allow", "dev/0x01")
+		qt.Assert(t, err, qt.IsNil)
+		entries, err = s.ListEntries("deny")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, entries, qt.HasLen, 2)
+	})
+
+	t.Run("nonces and secrets", func(t *testing.T) {
+		secret, err := s.Secret("nonces", []byte{1})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, secret, qt.DeepEquals, []byte{1})
+		secret, err = s.Secret("nonces", []byte{2})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, secret, qt.DeepEquals, []byte{1})
+		secret, err = s.Secret("other", []byte{3})
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, secret, qt.DeepEquals, []byte{3})
+
+		// should use a nonce once until deleted once expired
+		now := time.Now()
+		qt.Assert(t, s.UseNonce("n1", now.Add(-time.Minute)), qt.IsNil)
+		qt.Assert(t, s.UseNonce("n2", now.Add(time.Minute)), qt.IsNil)
+		qt.Assert(t, s.UseNonce("n1", now.Add(time.Minute)), qt.ErrorIs, storage.ErrConflict)
+		qt.Assert(t, s.DeleteNonces(now), qt.IsNil)
+		qt.Assert(t, s.UseNonce("n1", now.Add(time.Minute)), qt.IsNil)
+		qt.Assert(t, s.UseNonce("n2", now.Add(time.Minute)), qt.ErrorIs, storage.ErrConflict)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		_, err := s.Session("t1")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		expiresAt := time.Unix(1700000000, 0)
+		for i, token := range []string{"t1", "t2"} {
+			qt.Assert(t, s.SetSession(&storage.Session{
+				Token:     token,
+				Address:   common.HexToAddress("0x0a"),
+				ExpiresAt: expiresAt.Add(time.Duration(i) * time.Hour),
+			}), qt.IsNil)
+		}
+		session, err := s.Session("t1")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, session.Address, qt.Equals, common.HexToAddress("0x0a"))
+		qt.Assert(t, session.ExpiresAt.Equal(expiresAt), qt.IsTrue)
+		qt.Assert(t, s.DeleteSessions(expiresAt.Add(time.Minute)), qt.IsNil)
+		_, err = s.Session("t1")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		_, err = s.Session("t2")
+		qt.Assert(t, err, qt.IsNil)
+	})
+
+	t.Run("recipient grants", func(t *testing.T) {
+		_, err := s.RecipientGrant("evm/sepolia/0x01")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		qt.Assert(t, s.SetRecipientGrant(&storage.RecipientGrant{Key: "evm/sepolia/0x01", Block: 10, Nonce: 1}), qt.IsNil)
+		qt.Assert(t, s.SetRecipientGrant(&storage.RecipientGrant{Key: "evm/sepolia/0x01", Block: 20, Nonce: 3}), qt.IsNil)
+		grant, err := s.RecipientGrant("evm/sepolia/0x01")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, grant, qt.DeepEquals, &storage.RecipientGrant{Key: "evm/sepolia/0x01", Block: 20, Nonce: 3})
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		lease, err := s.AcquireLease("signer/0x01", "replica1", time.Hour)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, lease.Holder, qt.Equals, "replica1")
+		qt.Assert(t, time.Until(lease.ExpiresAt) > 59*time.Minute, qt.IsTrue)
+		qt.Assert(t, time.Until(lease.ExpiresAt) <= time.Hour, qt.IsTrue)
+		_, err = s.AcquireLease("signer/0x01", "replica2", time.Hour)
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrLeaseHeld)
+
+		// should renew the lease of its holder and release it only for it,
+		// after a while as the database clocks can have millisecond precision
+		time.Sleep(2 * time.Millisecond)
+		renewed, err := s.AcquireLease("signer/0x01", "replica1", time.Hour)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, renewed.ExpiresAt.After(lease.ExpiresAt), qt.IsTrue)
+		qt.Assert(t, s.ReleaseLease("signer/0x01", "replica2"), qt.IsNil)
+		_, err = s.AcquireLease("signer/0x01", "replica2", time.Hour)
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrLeaseHeld)
+		qt.Assert(t, s.ReleaseLease("signer/0x01", "replica1"), qt.IsNil)
+		_, err = s.AcquireLease("signer/0x01", "replica2", 0)
+		qt.Assert(t, err, qt.IsNil)
+
+		// should be acquired by another holder once expired
+		_, err = s.AcquireLease("signer/0x01", "replica1", time.Hour)
+		qt.Assert(t, err, qt.IsNil)
+
+		// should be acquired by only one holder concurrently
+		var wg sync.WaitGroup
+		acquired := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(holder string) {
+				defer wg.Done()
+				_, err := s.AcquireLease("signer/0x02", holder, time.Hour)
+				if err == nil {
+					acquired <- true
+					return
+				}
+				qt.Check(t, err, qt.ErrorIs, storage.ErrLeaseHeld)
+			}(fmt.Sprintf("replica%d", i))
+		}
+		wg.Wait()
+		close(acquired)
+		qt.Assert(t, len(acquired), qt.Equals, 1)
+	})
+
+	t.Run("send queue", func(t *testing.T) {
+		_, err := s.TakeSend("evm/sepolia", "replica1")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+		now := time.Now()
+		for i, id := range []string{"second", "first", "other"} {
+			queue := "evm/sepolia"
+			if id == "other" {
+				queue = "evm/goerli"
+			}
+			qt.Assert(t, s.EnqueueSend(&storage.QueuedSend{
+				ID:       id,
+				Queue:    queue,
+				To:       common.HexToAddress("0x0b"),
+				Amount:   100,
+				QueuedAt: now.Add(-time.Duration(i) * time.Second),
+			}), qt.IsNil)
+		}
+
+		// should take the oldest waiting send of the queue
+		send, err := s.TakeSend("evm/sepolia", "replica1")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, send.ID, qt.Equals, "first")
+		qt.Assert(t, send.Holder, qt.Equals, "replica1")
+		qt.Assert(t, send.Amount, qt.Equals, uint64(100))
+		qt.Assert(t, s.CancelSend("first"), qt.ErrorIs, storage.ErrConflict)
+		qt.Assert(t, s.CancelSend("second"), qt.IsNil)
+		_, err = s.TakeSend("evm/sepolia", "replica2")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+
+		// should record the result of the send
+		hash := common.HexToHash("0x01")
+		send.TxHash = &hash
+		send.DoneAt = time.Now()
+		qt.Assert(t, s.CompleteSend(send), qt.IsNil)
+		send, err = s.QueuedSend("first")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, *send.TxHash, qt.Equals, hash)
+		qt.Assert(t, send.DoneAt.IsZero(), qt.IsFalse)
+		qt.Assert(t, send.Holder, qt.Equals, "replica1")
+		qt.Assert(t, s.DeleteSend("first"), qt.IsNil)
+		_, err = s.QueuedSend("first")
+		qt.Assert(t, err, qt.ErrorIs, storage.ErrNotFound)
+
+		// should take a send only once concurrently
+		var wg sync.WaitGroup
+		taken := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(holder string) {
+				defer wg.Done()
+				if _, err := s.TakeSend("evm/goerli", holder); err == nil {
+					taken <- true
+				}
+			}(fmt.Sprintf("replica%d", i))
+		}
+		wg.Wait()
+		close(taken)
+		qt.Assert(t, len(taken), qt.Equals, 1)
+	})
+}